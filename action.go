@@ -1,5 +1,9 @@
 package autog
 
+import (
+	"fmt"
+	"strings"
+)
 
 type Action struct {
 	Name string
@@ -10,14 +14,23 @@ type Action struct {
 }
 
 func (a *Action) doNeedRun(content string) (need bool) {
+	if a.NeedRun == nil {
+		return false
+	}
 	return a.NeedRun(content)
 }
 
 func (a *Action) doCheck(content string) (ok bool, err string, payload interface{}) {
+	if a.Check == nil {
+		return true, "", nil
+	}
 	return a.Check(content)
 }
 
 func (a *Action) doRun(content string, payload interface{}) (ok bool, err string) {
+	if a.Run == nil {
+		return true, ""
+	}
 	return a.Run(content, payload)
 }
 
@@ -29,4 +42,40 @@ func (d *DoAction) doDo(content string) (ok bool, reflection string) {
 	return d.Do(content)
 }
 
+// RenderActions renders the names and descriptions of actions as a prompt
+func RenderActions(actions []*Action) string {
+	if len(actions) <= 0 {
+		return ""
+	}
+	buf := strings.Builder{}
+	buf.WriteString("You can use the following actions:\n")
+	for _, act := range actions {
+		buf.WriteString(fmt.Sprintf("- %s: %s\n", act.Name, act.Desc))
+	}
+	return buf.String()
+}
 
+// DispatchActions runs every action whose NeedRun accepts content, an action
+// is checked by Check before Run. The errors of failed actions are joined as
+// reflection content, so the model can correct its response.
+func DispatchActions(actions []*Action, content string) (ok bool, reflection string) {
+	var errs []string
+	for _, act := range actions {
+		if act == nil || !act.doNeedRun(content) {
+			continue
+		}
+		cok, cerr, payload := act.doCheck(content)
+		if !cok {
+			errs = append(errs, fmt.Sprintf("Action [%s] check failed: %s", act.Name, cerr))
+			continue
+		}
+		rok, rerr := act.doRun(content, payload)
+		if !rok {
+			errs = append(errs, fmt.Sprintf("Action [%s] run failed: %s", act.Name, rerr))
+		}
+	}
+	if len(errs) > 0 {
+		return false, strings.Join(errs, "\n")
+	}
+	return true, ""
+}
@@ -38,14 +38,29 @@ type Agent struct {
 	PromptMessages []ChatMessage
 	LLM LLM
 	Stream bool
+	// Options are the generation options of the calls, set by AskLLM
+	Options *GenerationOptions
 	ResponseStatus  LLMStatus
 	ResponseMessage ChatMessage
 	ReflectionContent string
 	AgentStage AgentStage
 	CanDoAction bool
 	DoAction *DoAction
+	Actions []*Action
 	CanDoReflection bool
 	DoReflection *DoReflection
+	// Usage aggregates the token usage of the LLM calls of the agent
+	Usage *UsageMeter
+	// ContextFit fits the prompt messages in the context window, nil
+	// sends them unchecked
+	ContextFit *ContextFit
+	// PromptTokens are the tokens of PromptMessages, counted when ContextFit
+	// or a MaxTokens of the prompts is set
+	PromptTokens int
+	// PromptTrims are the prompt items AskLLM trimmed to fit their budget
+	PromptTrims []PromptTrim
+
+	lastErr error
 }
 
 func (a *Agent) StreamStart() *strings.Builder {
@@ -75,6 +90,28 @@ func (a *Agent) StreamEnd(contentbuf *strings.Builder) {
 }
 
 
+// Err returns the error recorded by the chain since the last Prompt, once an
+// error is recorded the following steps are skipped.
+func (a *Agent) Err() error {
+	return a.lastErr
+}
+
+func (a *Agent) usageContext(cxt context.Context) context.Context {
+	if a.Usage == nil {
+		a.Usage = &UsageMeter{}
+	}
+	return WithUsageRecorder(cxt, a.Usage)
+}
+
+// ModelInfo returns the capabilities of the main model of the LLM of the
+// agent, a zero ModelInfo if they are unknown.
+func (a *Agent) ModelInfo() ModelInfo {
+	if a.LLM == nil {
+		return ModelInfo{}
+	}
+	return ModelInfoOf(a.LLM, false)
+}
+
 func (a *Agent) GetLongHistory() []ChatMessage {
 	return a.LongHistoryMessages
 }
@@ -83,8 +120,66 @@ func (a *Agent) GetShortHistory() []ChatMessage {
 	return a.ShortHistoryMessages
 }
 
+// RegisterActions adds actions to the registry of the agent, they are listed
+// in the prompt and used by Action when no DoAction is given.
+func (a *Agent) RegisterActions(actions ...*Action) *Agent {
+	a.Actions = append(a.Actions, actions...)
+	return a
+}
+
+// ActionPrompt returns a system prompt item listing the registered actions,
+// AskLLM puts it first in the prompt when actions are registered and the
+// prompts do not place it themselves.
+func (a *Agent) ActionPrompt() *PromptItem {
+	return &PromptItem{
+		Name : "actions",
+		GetPrompt : func (query string) (role string, prompt string) {
+			return ROLE_SYSTEM, RenderActions(a.Actions)
+		},
+		actions : true,
+	}
+}
+
+// promptItems returns the prompts of the call, with the action prompt
+func (a *Agent) promptItems() []*PromptItem {
+	if len(a.Actions) <= 0 {
+		return a.Prompts
+	}
+	for _, pmt := range a.Prompts {
+		if pmt.actions {
+			return a.Prompts
+		}
+	}
+	return append([]*PromptItem{ a.ActionPrompt() }, a.Prompts...)
+}
+
+// LongHistoryPrompt returns a prompt item of the long history, which
+// ContextFit may summarize
+func (a *Agent) LongHistoryPrompt() *PromptItem {
+	return &PromptItem{
+		Name : "long_history",
+		GetMessages : func (query string) []ChatMessage {
+			return a.LongHistoryMessages
+		},
+		history : historyLong,
+	}
+}
+
+// ShortHistoryPrompt returns a prompt item of the short history, which
+// ContextFit may drop or summarize
+func (a *Agent) ShortHistoryPrompt() *PromptItem {
+	return &PromptItem{
+		Name : "short_history",
+		GetMessages : func (query string) []ChatMessage {
+			return a.ShortHistoryMessages
+		},
+		history : historyShort,
+	}
+}
+
 func (a *Agent) Prompt(prompts ...*PromptItem) *Agent {
 	a.Prompts = prompts
+	a.lastErr = nil
 	a.CanDoAction = false
 	a.CanDoReflection = false
 	a.ReflectionContent = ""
@@ -93,6 +188,9 @@ func (a *Agent) Prompt(prompts ...*PromptItem) *Agent {
 
 func (a *Agent) ReadQuestion(cxt context.Context, input *Input, output *Output) *Agent {
 	a.AgentStage = AsReadQuestion
+	if a.lastErr != nil {
+		return a
+	}
 	if cxt == nil {
 		cxt = context.Background()
 	}
@@ -103,31 +201,65 @@ func (a *Agent) ReadQuestion(cxt context.Context, input *Input, output *Output)
 	return a
 }
 
-func (a *Agent) AskLLM(llm LLM, stream bool) *Agent {
+// AskLLM prepares the messages of the call, the generation options of the
+// prompts apply in order, and opts apply over them. The prompt items are cut
+// to their MaxTokens, then fitted by ContextFit, the trimmed ones are
+// recorded in PromptTrims.
+func (a *Agent) AskLLM(llm LLM, stream bool, opts ...*GenerationOptions) *Agent {
 	a.AgentStage = AsAskLLM
-	var msgs []ChatMessage
+	if a.lastErr != nil {
+		return a
+	}
+	if llm == nil {
+		a.lastErr = &LLMError{ Stage: a.AgentStage, Status: LLM_STATUS_BED_REQUEST, Message: "LLM is nil!" }
+		return a
+	}
+	var options *GenerationOptions
 	msg := ChatMessage{ Role:ROLE_USER, Content:a.Request }
 	for _, pmt := range a.Prompts {
-		pms := pmt.doGetMessages(a.Request)
-		if len(pms) > 0 {
-			msgs = append(msgs, pms...)
+		if pmt.Options != nil {
+			options = mergeOptions(options, pmt.Options)
+		}
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			options = mergeOptions(options, opt)
 		}
-		role, prompt := pmt.doGetPrompt(a.Request)
-		if IsValidRole(role) && len(prompt) > 0 {
-			m := ChatMessage{ Role:role, Content:prompt }
-			msgs = append(msgs, m)
+	}
+	a.LLM = llm
+	cxt := a.Context
+	if cxt == nil {
+		cxt = context.Background()
+	}
+	msgs, err := a.assemblePrompts(cxt, llm, options, msg)
+	if err != nil {
+		a.AgentStage = AsAskLLM
+		if lerr, ok := err.(*LLMError); ok {
+			a.ResponseStatus = lerr.Status
 		}
+		a.lastErr = err
+		return a
 	}
-	msgs = append(msgs, msg)
 	a.PromptMessages = msgs
 	a.ShortHistoryMessages = append(a.ShortHistoryMessages, msg)
-	a.LLM = llm
 	a.Stream = stream
+	a.Options = options
 	return a
 }
 
+func mergeOptions(opts *GenerationOptions, over *GenerationOptions) *GenerationOptions {
+	if opts == nil {
+		opts = &GenerationOptions{}
+	}
+	merged := opts.Merge(over)
+	return &merged
+}
+
 func (a *Agent) AskReflection(reflection string) *Agent {
 	a.AgentStage = AsAskReflection
+	if a.lastErr != nil {
+		return a
+	}
 	var contentbuf *strings.Builder
 	contentbuf = a.StreamStart()
 	a.StreamDelta(contentbuf, reflection)
@@ -140,10 +272,17 @@ func (a *Agent) AskReflection(reflection string) *Agent {
 
 func (a *Agent) WaitResponse(cxt context.Context) *Agent {
 	a.AgentStage = AsWaitResponse
+	if a.lastErr != nil {
+		return a
+	}
 	if cxt == nil {
 		cxt = context.Background()
 	}
 	a.Context = cxt
+	cxt = a.usageContext(cxt)
+	if a.Options != nil {
+		cxt = WithGenerationOptions(cxt, *a.Options)
+	}
 	var sts LLMStatus
 	var msg ChatMessage
 	var contentbuf *strings.Builder
@@ -164,11 +303,17 @@ func (a *Agent) WaitResponse(cxt context.Context) *Agent {
 	a.ShortHistoryMessages = append(a.ShortHistoryMessages, a.ResponseMessage)
 	a.CanDoAction = a.ResponseStatus == LLM_STATUS_OK
 	a.CanDoReflection = false
+	if a.ResponseStatus != LLM_STATUS_OK {
+		a.lastErr = NewLLMError(a.AgentStage, sts, msg)
+	}
 	return a
 }
 
 func (a *Agent) Summarize(cxt context.Context, summary *PromptItem, prefix *PromptItem, force bool) *Agent {
 	a.AgentStage = AsSummarize
+	if a.lastErr != nil {
+		return a
+	}
 	if cxt == nil {
 		cxt = context.Background()
 	}
@@ -176,7 +321,7 @@ func (a *Agent) Summarize(cxt context.Context, summary *PromptItem, prefix *Prom
 	var contentbuf *strings.Builder
 	contentbuf = a.StreamStart()
 	smy := &Summary{}
-	smy.Cxt = cxt
+	smy.Cxt = a.usageContext(cxt)
 	smy.LLM = a.LLM
 	smy.StreamReader = a
 	smy.StreamBuffer = contentbuf
@@ -188,11 +333,17 @@ func (a *Agent) Summarize(cxt context.Context, summary *PromptItem, prefix *Prom
 	if err != nil {
 		a.StreamError(contentbuf, LLM_STATUS_BED_MESSAGE, fmt.Sprintf("InitSummary ERROR: %s", err))
 		a.StreamEnd(contentbuf)
+		a.lastErr = &SummaryError{ Status: LLM_STATUS_BED_MESSAGE, Err: err }
 		return a
 	}
 	status, smsgs := smy.Summarize(a.LongHistoryMessages, a.ShortHistoryMessages, force)
 	if status != LLM_STATUS_OK {
 		a.StreamEnd(contentbuf)
+		serr := &SummaryError{ Status: status }
+		if len(smsgs) > 0 {
+			serr.Err = NewLLMError(a.AgentStage, status, smsgs[0])
+		}
+		a.lastErr = serr
 		return a
 	}
 	a.StreamEnd(contentbuf)
@@ -206,16 +357,21 @@ func (a *Agent) Summarize(cxt context.Context, summary *PromptItem, prefix *Prom
 func (a *Agent) Action(doAct *DoAction) *Agent {
 	a.AgentStage = AsAction
 	a.DoAction = doAct
-	if !a.CanDoAction {
+	if a.lastErr != nil || !a.CanDoAction {
 		return a
 	}
 	a.CanDoAction = false
 	a.CanDoReflection = false
 	a.ReflectionContent = ""
-	if a.DoAction == nil {
+	var ok bool
+	var react string
+	if a.DoAction != nil {
+		ok, react = a.DoAction.doDo(a.ResponseMessage.Content)
+	} else if len(a.Actions) > 0 {
+		ok, react = DispatchActions(a.Actions, a.ResponseMessage.Content)
+	} else {
 		return a
 	}
-	ok, react := a.DoAction.doDo(a.ResponseMessage.Content)
 	a.ReflectionContent = react
 	a.CanDoReflection = !ok
 	return a
@@ -234,7 +390,7 @@ func (a *Agent) Reflection(doRef *DoReflection, retry int) *Agent {
 		}
 	}
 	a.DoReflection = doRef
-	if !a.CanDoReflection {
+	if a.lastErr != nil || !a.CanDoReflection {
 		return a
 	}
 	react := a.ReflectionContent
@@ -243,6 +399,7 @@ func (a *Agent) Reflection(doRef *DoReflection, retry int) *Agent {
 	a.ReflectionContent = ""
 	retry -= 1
 	if retry <= 0 {
+		a.lastErr = &ActionError{ Reflection: react }
 		return a
 	}
 	if len(react) > 0 {
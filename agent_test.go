@@ -0,0 +1,128 @@
+package autog_test
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleAgent_RegisterActions() {
+	mock := &llm.Mock{
+		Responses: []llm.MockResponse{
+			{ Content: "search:" },
+			{ Content: "search: weather" },
+			{ Content: "search:" },
+			{ Content: "search:" },
+		},
+	}
+	mock.InitLLM()
+
+	agent := &autog.Agent{}
+	agent.RegisterActions(&autog.Action{
+		Name: "search",
+		Desc: "Reply 'search: <query>' to search the web",
+		NeedRun: func (content string) bool {
+			return strings.HasPrefix(content, "search:")
+		},
+		Check: func (content string) (bool, string, interface{}) {
+			query := strings.TrimSpace(strings.TrimPrefix(content, "search:"))
+			if len(query) <= 0 {
+				return false, "Query is empty!", nil
+			}
+			return true, "", query
+		},
+		Run: func (content string, payload interface{}) (bool, string) {
+			fmt.Println("Searching", payload)
+			return true, ""
+		},
+	})
+	system := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return autog.ROLE_SYSTEM, "Answer briefly."
+		},
+	}
+	input := &autog.Input{ ReadContent: func() string { return "How is the weather?" } }
+
+	// The registered actions are listed in the prompt without ActionPrompt,
+	// a failed check is reflected to the model
+	agent.Prompt(system).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false).
+	WaitResponse(nil).
+	Action(nil).
+	Reflection(nil, 3)
+
+	calls := mock.Calls()
+	fmt.Print(calls[0].Messages[0].Content)
+	fmt.Println(calls[0].Messages[1].Content)
+	fmt.Println(agent.Err(), len(calls), calls[1].Messages[len(calls[1].Messages)-1].Content)
+
+	// The reflection gives up after the retries
+	agent.Prompt(system).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false).
+	WaitResponse(nil).
+	Action(nil).
+	Reflection(nil, 2)
+	fmt.Println(agent.Err(), len(mock.Calls()))
+
+	// Output:
+	// Searching weather
+	// You can use the following actions:
+	// - search: Reply 'search: <query>' to search the web
+	// Answer briefly.
+	// <nil> 2 Action [search] check failed: Query is empty!
+	// Action ERROR: Action [search] check failed: Query is empty! 4
+}
+
+func ExampleAgent_Err() {
+	mock := &llm.Mock{
+		Responses: []llm.MockResponse{
+			{ Status: autog.LLM_STATUS_BED_RESPONSE, Content: "Server down!" },
+			{ Content: "Hello!" },
+			{ Status: autog.LLM_STATUS_EXCEED_CONTEXT, Content: "Too long!" },
+		},
+	}
+	mock.InitLLM()
+	input := &autog.Input{ ReadContent: func() string { return "Hi!" } }
+	summary := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return "", "Summarize our conversation."
+		},
+	}
+	prefix := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return "", "Summary: "
+		},
+	}
+
+	// A failed call records an LLMError, the later steps are skipped
+	agent := &autog.Agent{}
+	agent.Prompt().
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false).
+	WaitResponse(nil).
+	Summarize(nil, summary, prefix, true)
+	var lerr *autog.LLMError
+	fmt.Println(errors.As(agent.Err(), &lerr), lerr.Stage == autog.AsWaitResponse, lerr.Status, lerr.Message)
+	fmt.Println(len(mock.Calls()), agent.AgentStage == autog.AsSummarize)
+
+	// Prompt clears the error, a failed summary records a SummaryError
+	// wrapping the LLMError and keeps the history
+	agent.Prompt().
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false).
+	WaitResponse(nil).
+	Summarize(nil, summary, prefix, true)
+	var serr *autog.SummaryError
+	fmt.Println(errors.As(agent.Err(), &serr), serr.Status, errors.As(agent.Err(), &lerr), lerr.Stage == autog.AsSummarize)
+	fmt.Println(len(agent.LongHistoryMessages), len(agent.ShortHistoryMessages))
+
+	// Output:
+	// true true BED_RESPONSE Server down!
+	// 1 true
+	// true EXCEED_CONTEXT true true
+	// 0 4
+}
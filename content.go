@@ -0,0 +1,121 @@
+package autog
+
+import (
+	"os"
+	"fmt"
+	"mime"
+	"strings"
+	"net/http"
+	"path/filepath"
+	"encoding/base64"
+)
+
+const (
+	PART_TEXT  string = "text"
+	PART_IMAGE string = "image"
+)
+
+// ContentPart is a part of a multimodal message, an image is given by url,
+// by base64 data, or by a local file read when the message is sent.
+type ContentPart struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	// ImageUrl is an http(s) or data url
+	ImageUrl  string `json:"image_url,omitempty"`
+	// ImageData is the base64 encoded image of type ImageMime
+	ImageData string `json:"image_data,omitempty"`
+	ImageMime string `json:"image_mime,omitempty"`
+	ImageFile string `json:"image_file,omitempty"`
+	// Detail is the image detail hint, low, high or auto
+	Detail    string `json:"detail,omitempty"`
+}
+
+func TextPart(text string) ContentPart {
+	return ContentPart{ Type: PART_TEXT, Text: text }
+}
+
+func ImageUrlPart(url string) ContentPart {
+	return ContentPart{ Type: PART_IMAGE, ImageUrl: url }
+}
+
+func ImageBase64Part(mimetype, data string) ContentPart {
+	return ContentPart{ Type: PART_IMAGE, ImageMime: mimetype, ImageData: data }
+}
+
+func ImageFilePart(path string) ContentPart {
+	return ContentPart{ Type: PART_IMAGE, ImageFile: path }
+}
+
+// LoadImage returns the mime type and base64 data of an image part, an http
+// url can not be loaded and returns an error.
+func (p *ContentPart) LoadImage() (mimetype string, data string, err error) {
+	switch {
+	case len(p.ImageData) > 0:
+		mimetype = p.ImageMime
+		if len(mimetype) <= 0 {
+			raw, _ := base64.StdEncoding.DecodeString(p.ImageData)
+			mimetype = http.DetectContentType(raw)
+		}
+		return mimetype, p.ImageData, nil
+	case len(p.ImageFile) > 0:
+		raw, rerr := os.ReadFile(p.ImageFile)
+		if rerr != nil {
+			return "", "", fmt.Errorf("Failed to read image: %w", rerr)
+		}
+		mimetype = mime.TypeByExtension(strings.ToLower(filepath.Ext(p.ImageFile)))
+		if len(mimetype) <= 0 {
+			mimetype = http.DetectContentType(raw)
+		}
+		return mimetype, base64.StdEncoding.EncodeToString(raw), nil
+	case strings.HasPrefix(p.ImageUrl, "data:"):
+		// data:image/png;base64,xxxx
+		meta, payload, ok := strings.Cut(strings.TrimPrefix(p.ImageUrl, "data:"), ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return "", "", fmt.Errorf("Invalid image data url!")
+		}
+		return strings.TrimSuffix(meta, ";base64"), payload, nil
+	case len(p.ImageUrl) > 0:
+		return "", "", fmt.Errorf("Image url %s can not be loaded!", p.ImageUrl)
+	}
+	return "", "", fmt.Errorf("Image part is empty!")
+}
+
+// Url returns the url of an image part, a data url unless it has an http url
+func (p *ContentPart) Url() (string, error) {
+	if len(p.ImageUrl) > 0 {
+		return p.ImageUrl, nil
+	}
+	mimetype, data, err := p.LoadImage()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mimetype, data), nil
+}
+
+// Text returns the text of the message, Content followed by its text parts
+func (cm *ChatMessage) Text() string {
+	if len(cm.Parts) <= 0 {
+		return cm.Content
+	}
+	var texts []string
+	if len(cm.Content) > 0 {
+		texts = append(texts, cm.Content)
+	}
+	for _, part := range cm.Parts {
+		if part.Type == PART_TEXT && len(part.Text) > 0 {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Images returns the number of image parts of the message
+func (cm *ChatMessage) Images() int {
+	images := 0
+	for _, part := range cm.Parts {
+		if part.Type == PART_IMAGE {
+			images++
+		}
+	}
+	return images
+}
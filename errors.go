@@ -0,0 +1,111 @@
+package autog
+
+import (
+	"fmt"
+)
+
+func (s LLMStatus) String() string {
+	switch s {
+	case LLM_STATUS_OK:
+		return "OK"
+	case LLM_STATUS_USER_CANCELED:
+		return "USER_CANCELED"
+	case LLM_STATUS_EXCEED_CONTEXT:
+		return "EXCEED_CONTEXT"
+	case LLM_STATUS_BED_REQUEST:
+		return "BED_REQUEST"
+	case LLM_STATUS_BED_RESPONSE:
+		return "BED_RESPONSE"
+	case LLM_STATUS_BED_MESSAGE:
+		return "BED_MESSAGE"
+	case LLM_STATUS_UNKNOWN_ERROR:
+		return "UNKNOWN_ERROR"
+	}
+	return fmt.Sprintf("LLMStatus(%d)", int(s))
+}
+
+// LLMError is recorded by the agent when a call to the LLM fails, Err is the
+// error reported by the provider, e.g. llm.OpanaiAPIError or llm.OllamaAPIError.
+type LLMError struct {
+	Stage   AgentStage
+	Status  LLMStatus
+	Message string
+	Err     error
+}
+
+func NewLLMError(stage AgentStage, status LLMStatus, msg ChatMessage) *LLMError {
+	return &LLMError{ Stage: stage, Status: status, Message: msg.Content, Err: msg.Err }
+}
+
+func (e *LLMError) Error() string {
+	return fmt.Sprintf("LLM ERROR (%s): %s", e.Status, e.Message)
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.Err
+}
+
+// SummaryError is recorded by the agent when summarizing the history fails,
+// the history is kept unchanged.
+type SummaryError struct {
+	Status LLMStatus
+	Err    error
+}
+
+func (e *SummaryError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("Summary ERROR (%s)", e.Status)
+	}
+	return fmt.Sprintf("Summary ERROR (%s): %s", e.Status, e.Err)
+}
+
+func (e *SummaryError) Unwrap() error {
+	return e.Err
+}
+
+// ActionError is recorded by the agent when the actions still fail after
+// all reflection retries are used.
+type ActionError struct {
+	Reflection string
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("Action ERROR: %s", e.Reflection)
+}
+
+// PromptError is recorded by the agent when a prompt item fails to render,
+// e.g. a PromptTemplate whose variable fails at execution.
+type PromptError struct {
+	Name string
+	Err  error
+}
+
+func (e *PromptError) Error() string {
+	return fmt.Sprintf("Prompt ERROR (%s): %s", e.Name, e.Err)
+}
+
+func (e *PromptError) Unwrap() error {
+	return e.Err
+}
+
+// EmbeddingError is returned by an EmbeddingModel when only some texts are
+// embedded, the embeddings of the Failed texts are nil.
+type EmbeddingError struct {
+	// Failed are the indexes of the failed texts, Errs their errors
+	Failed []int
+	Errs   []error
+}
+
+func (e *EmbeddingError) Error() string {
+	if len(e.Errs) <= 0 {
+		return fmt.Sprintf("Embedding ERROR: %d texts failed", len(e.Failed))
+	}
+	return fmt.Sprintf("Embedding ERROR: %d texts failed, first: %s", len(e.Failed), e.Errs[0])
+}
+
+func (e *EmbeddingError) Unwrap() error {
+	if len(e.Errs) <= 0 {
+		return nil
+	}
+	return e.Errs[0]
+}
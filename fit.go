@@ -0,0 +1,477 @@
+package autog
+
+import (
+	"fmt"
+	"sort"
+	"context"
+	"strings"
+)
+
+const (
+	// defaultReserveRatio leaves 1/8 of the context window for the output
+	defaultReserveRatio = 8
+	// MessageOverheadTokens are the tokens of the role and separators of a message
+	MessageOverheadTokens = 4
+	// truncateRounds limits the recounts of a text being truncated
+	truncateRounds = 4
+	// truncateMarker replaces the text cut by TruncateMiddle
+	truncateMarker = "\n...\n"
+	defaultTruncateSummary = "Summarize the following content, keep the facts needed to answer questions about it."
+)
+
+type FitPolicy int
+
+const (
+	// FitDropHistory drops the oldest turns of the short history
+	FitDropHistory FitPolicy = iota
+	// FitSummarize summarizes the long and short history
+	FitSummarize
+	// FitTruncatePrompts truncates the prompt items of the lowest Priority
+	FitTruncatePrompts
+)
+
+// TruncateStrategy is how a prompt item over its budget is truncated
+type TruncateStrategy int
+
+const (
+	// TruncateAuto drops the oldest messages, then cuts the end of the text
+	TruncateAuto TruncateStrategy = iota
+	// TruncateHead drops the oldest messages, then cuts the beginning of the text
+	TruncateHead
+	// TruncateTail drops the latest messages, then cuts the end of the text,
+	// history items always drop their oldest turns
+	TruncateTail
+	// TruncateMiddle drops the middle messages, then cuts the middle of the text
+	TruncateMiddle
+	// TruncateSummarize summarizes the messages by the weak model, the
+	// summary is truncated as TruncateAuto if still too long
+	TruncateSummarize
+)
+
+// PromptTrim records a prompt item AskLLM trimmed to fit its budget
+type PromptTrim struct {
+	Name string
+	Item *PromptItem
+	// Tokens are the tokens of the item before and Kept after trimming
+	Tokens int
+	Kept   int
+	// Err is the failure of TruncateSummarize, the item was truncated instead
+	Err error
+}
+
+type historyKind int
+
+const (
+	historyNone historyKind = iota
+	historyLong
+	historyShort
+)
+
+// ContextFit makes AskLLM measure the prompt messages against the context
+// window of the model and apply Policies in order until they fit. The
+// history is only dropped or summarized when given by LongHistoryPrompt and
+// ShortHistoryPrompt of the agent.
+type ContextFit struct {
+	// ContextWindow overrides the context window known for the model
+	ContextWindow int
+	// ReserveTokens are left for the output when the call sets no MaxTokens,
+	// 1/8 of the context window if not set
+	ReserveTokens int
+	Policies []FitPolicy
+	// Summary and Prefix are the prompts of FitSummarize, Summary also
+	// instructs TruncateSummarize
+	Summary *PromptItem
+	Prefix  *PromptItem
+}
+
+// Limit returns the tokens the prompt of llm may take, 0 if the context
+// window is unknown.
+func (f *ContextFit) Limit(llm LLM, options *GenerationOptions) int {
+	window := f.ContextWindow
+	if window <= 0 {
+		window = ModelInfoOf(llm, false).ContextWindow
+	}
+	if window <= 0 {
+		return 0
+	}
+	reserve := f.ReserveTokens
+	if options != nil && options.MaxTokens != nil {
+		reserve = *options.MaxTokens
+	}
+	if reserve <= 0 {
+		reserve = window / defaultReserveRatio
+	}
+	return window - reserve
+}
+
+// MessageTokens counts the tokens of msg by llm, images are estimated
+func MessageTokens(cxt context.Context, llm LLM, msg ChatMessage) int {
+	tokens := MessageOverheadTokens + msg.Images() * defaultImageTokens
+	if text := msg.Text(); len(text) > 0 {
+		tokens += llm.CalcTokens(cxt, text)
+	}
+	for _, call := range msg.ToolCalls {
+		tokens += llm.CalcTokens(cxt, call.Name + call.Arguments)
+	}
+	return tokens
+}
+
+// promptSegment are the messages of a prompt item in the prompt
+type promptSegment struct {
+	item   *PromptItem
+	index  int
+	msgs   []ChatMessage
+	tokens int
+	err    error
+}
+
+func (s *promptSegment) measure(cxt context.Context, llm LLM) int {
+	s.tokens = 0
+	for _, msg := range s.msgs {
+		s.tokens += MessageTokens(cxt, llm, msg)
+	}
+	return s.tokens
+}
+
+func (a *Agent) promptSegment(index int, pmt *PromptItem) *promptSegment {
+	seg := &promptSegment{ item: pmt, index: index }
+	// A copy, truncating must not change the history
+	seg.msgs = append(seg.msgs, pmt.doGetMessages(a.Request)...)
+	role, prompt, err := pmt.renderPrompt(a.Request)
+	if err != nil {
+		seg.err = &PromptError{ Name: pmt.Name, Err: err }
+	}
+	if IsValidRole(role) && len(prompt) > 0 {
+		seg.msgs = append(seg.msgs, ChatMessage{ Role:role, Content:prompt })
+	}
+	return seg
+}
+
+// promptSegments returns the segments of the prompt items, and the first
+// failure of an item to render
+func (a *Agent) promptSegments() ([]*promptSegment, error) {
+	prompts := a.promptItems()
+	segments := make([]*promptSegment, len(prompts))
+	var err error
+	for i, pmt := range prompts {
+		segments[i] = a.promptSegment(i, pmt)
+		if err == nil {
+			err = segments[i].err
+		}
+	}
+	return segments, err
+}
+
+func segmentMessages(segments []*promptSegment) []ChatMessage {
+	var msgs []ChatMessage
+	for _, seg := range segments {
+		msgs = append(msgs, seg.msgs...)
+	}
+	return msgs
+}
+
+// dropOldestTurn drops the first message of msgs and the answers, tool calls
+// and tool results following it.
+func dropOldestTurn(msgs []ChatMessage) []ChatMessage {
+	i := 1
+	for i < len(msgs) && msgs[i].Role != ROLE_USER {
+		i++
+	}
+	return msgs[i:]
+}
+
+// cutRunes keeps keep runes of runes, cutting them by strategy
+func cutRunes(runes []rune, keep int, strategy TruncateStrategy) string {
+	if keep >= len(runes) {
+		return string(runes)
+	}
+	switch strategy {
+	case TruncateHead:
+		return string(runes[len(runes) - keep:])
+	case TruncateMiddle:
+		head := keep / 2
+		return string(runes[:head]) + truncateMarker + string(runes[len(runes) - (keep - head):])
+	}
+	return string(runes[:keep])
+}
+
+// truncateText cuts text by strategy until it takes at most tokens
+func truncateText(cxt context.Context, llm LLM, text string, tokens int, strategy TruncateStrategy) string {
+	if tokens <= 0 {
+		return ""
+	}
+	runes := []rune(text)
+	keep  := len(runes)
+	for round := 0; round < truncateRounds; round++ {
+		cut := cutRunes(runes, keep, strategy)
+		count := llm.CalcTokens(cxt, cut)
+		if count <= tokens {
+			return cut
+		}
+		keep = keep * tokens / count
+		if keep <= 0 {
+			return ""
+		}
+	}
+	return cutRunes(runes, keep, strategy)
+}
+
+// truncateSegment drops messages of seg, then cuts the text of the one left,
+// by strategy until it takes at most tokens. A history drops whole turns, so
+// no answer is left without its question.
+func truncateSegment(cxt context.Context, llm LLM, seg *promptSegment, tokens int, strategy TruncateStrategy) {
+	for len(seg.msgs) > 1 && seg.measure(cxt, llm) > tokens {
+		if seg.item.history != historyNone {
+			seg.msgs = dropOldestTurn(seg.msgs)
+			continue
+		}
+		i := 0
+		if strategy == TruncateTail {
+			i = len(seg.msgs) - 1
+		} else if strategy == TruncateMiddle && len(seg.msgs) > 2 {
+			i = len(seg.msgs) / 2
+		}
+		seg.msgs = append(seg.msgs[:i], seg.msgs[i + 1:]...)
+	}
+	if len(seg.msgs) <= 0 || seg.measure(cxt, llm) <= tokens {
+		return
+	}
+	msg := &seg.msgs[0]
+	room := tokens - (seg.tokens - llm.CalcTokens(cxt, msg.Content))
+	msg.Content = truncateText(cxt, llm, msg.Content, room, strategy)
+	if len(msg.Content) <= 0 && len(msg.Parts) <= 0 {
+		seg.msgs = seg.msgs[:0]
+	}
+	seg.measure(cxt, llm)
+}
+
+// summarizeSegment replaces the messages of seg by their summary by the weak
+// model in at most tokens.
+func (a *Agent) summarizeSegment(cxt context.Context, llm LLM, seg *promptSegment, tokens int) error {
+	prompt := defaultTruncateSummary
+	if a.ContextFit != nil && a.ContextFit.Summary != nil {
+		if _, p := a.ContextFit.Summary.doGetPrompt(a.Request); len(p) > 0 {
+			prompt = p
+		}
+	}
+	contentbuf := strings.Builder{}
+	for _, msg := range seg.msgs {
+		contentbuf.WriteString(msg.Text())
+		contentbuf.WriteString("\n")
+	}
+	role := ROLE_SYSTEM
+	if len(seg.msgs) == 1 {
+		role = seg.msgs[0].Role
+	}
+	scxt := WithGenerationOptions(a.usageContext(cxt), GenerationOptions{ MaxTokens: Ptr(tokens) })
+	status, smsg := llm.SendMessagesByWeakModel(scxt, []ChatMessage{
+		{ Role: ROLE_SYSTEM, Content: prompt },
+		{ Role: ROLE_USER, Content: contentbuf.String() },
+	})
+	if status != LLM_STATUS_OK {
+		return NewLLMError(AsAskLLM, status, smsg)
+	}
+	seg.msgs = []ChatMessage{ { Role: role, Content: smsg.Content } }
+	seg.measure(cxt, llm)
+	return nil
+}
+
+// trimSegment trims seg to tokens by the strategy of its item
+func (a *Agent) trimSegment(cxt context.Context, llm LLM, seg *promptSegment, tokens int) {
+	before := seg.tokens
+	strategy := seg.item.Truncate
+	var err error
+	if strategy == TruncateSummarize {
+		// A failed summary is truncated instead
+		err = a.summarizeSegment(cxt, llm, seg, tokens)
+		strategy = TruncateAuto
+	}
+	if seg.tokens > tokens {
+		truncateSegment(cxt, llm, seg, tokens, strategy)
+	}
+	a.recordTrim(seg, before, err)
+}
+
+// capSegment trims seg to the MaxTokens of its item
+func (a *Agent) capSegment(cxt context.Context, llm LLM, seg *promptSegment) {
+	if seg.item.MaxTokens > 0 && seg.tokens > seg.item.MaxTokens {
+		a.trimSegment(cxt, llm, seg, seg.item.MaxTokens)
+	}
+}
+
+func (a *Agent) recordTrim(seg *promptSegment, before int, err error) {
+	if seg.tokens >= before && err == nil {
+		return
+	}
+	for i := range a.PromptTrims {
+		if a.PromptTrims[i].Item == seg.item {
+			a.PromptTrims[i].Kept = seg.tokens
+			if err != nil {
+				a.PromptTrims[i].Err = err
+			}
+			return
+		}
+	}
+	a.PromptTrims = append(a.PromptTrims, PromptTrim{
+		Name   : seg.item.Name,
+		Item   : seg.item,
+		Tokens : before,
+		Kept   : seg.tokens,
+		Err    : err,
+	})
+}
+
+// needsMeasure is whether assemblePrompts counts the tokens of the prompts
+func (a *Agent) needsMeasure() bool {
+	if a.ContextFit != nil {
+		return true
+	}
+	for _, pmt := range a.Prompts {
+		if pmt.MaxTokens > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// assemblePrompts returns the prompt messages of the prompt items, each
+// within its MaxTokens, then fitted by a.ContextFit in the model budget.
+func (a *Agent) assemblePrompts(cxt context.Context, llm LLM, options *GenerationOptions, request ChatMessage) ([]ChatMessage, error) {
+	a.PromptTokens = 0
+	a.PromptTrims  = nil
+	segments, err := a.promptSegments()
+	if err != nil {
+		return nil, err
+	}
+	if !a.needsMeasure() {
+		return append(segmentMessages(segments), request), nil
+	}
+	for _, seg := range segments {
+		seg.measure(cxt, llm)
+		a.capSegment(cxt, llm, seg)
+	}
+	limit := 0
+	if a.ContextFit != nil {
+		limit = a.ContextFit.Limit(llm, options)
+	}
+	if limit > 0 {
+		segments, err = a.fitContext(cxt, llm, limit, segments, request)
+	}
+	a.PromptTokens = MessageTokens(cxt, llm, request)
+	for _, seg := range segments {
+		a.PromptTokens += seg.tokens
+	}
+	return append(segmentMessages(segments), request), err
+}
+
+// fitContext applies the policies of a.ContextFit until segments and the
+// request fit in limit, it returns the fitted segments.
+func (a *Agent) fitContext(cxt context.Context, llm LLM, limit int, segments []*promptSegment, request ChatMessage) ([]*promptSegment, error) {
+	fit := a.ContextFit
+	measure := func() int {
+		total := MessageTokens(cxt, llm, request)
+		for _, seg := range segments {
+			total += seg.tokens
+		}
+		return total
+	}
+	hasHistory := func(kinds ...historyKind) bool {
+		for _, seg := range segments {
+			for _, kind := range kinds {
+				if seg.item.history == kind {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	// The short history is dropped on a copy, kept only when the prompt fits
+	short := a.ShortHistoryMessages
+	dropped := false
+	rebuildHistory := func() {
+		for i, seg := range segments {
+			if seg.item.history == historyNone {
+				continue
+			}
+			segments[i] = a.promptSegment(seg.index, seg.item)
+			if seg.item.history == historyShort {
+				segments[i].msgs = append([]ChatMessage{}, short...)
+			}
+			segments[i].measure(cxt, llm)
+			a.capSegment(cxt, llm, segments[i])
+			a.recordTrim(segments[i], seg.tokens, nil)
+		}
+	}
+	total := measure()
+	for _, policy := range fit.Policies {
+		if total <= limit {
+			break
+		}
+		switch policy {
+		case FitDropHistory:
+			if !hasHistory(historyShort) {
+				continue
+			}
+			for total > limit && len(short) > 0 {
+				short = dropOldestTurn(short)
+				dropped = true
+				rebuildHistory()
+				total = measure()
+			}
+		case FitSummarize:
+			if fit.Summary == nil || fit.Prefix == nil {
+				continue
+			}
+			if !hasHistory(historyLong, historyShort) {
+				continue
+			}
+			if len(a.LongHistoryMessages) + len(a.ShortHistoryMessages) <= 0 {
+				continue
+			}
+			a.Summarize(cxt, fit.Summary, fit.Prefix, true)
+			a.AgentStage = AsAskLLM
+			if a.lastErr != nil {
+				return segments, a.lastErr
+			}
+			short, dropped = a.ShortHistoryMessages, false
+			rebuildHistory()
+			total = measure()
+		case FitTruncatePrompts:
+			order := append([]*promptSegment{}, segments...)
+			// Lower priority first, the later of equal ones first
+			sort.SliceStable(order, func(i, j int) bool {
+				if order[i].item.Priority != order[j].item.Priority {
+					return order[i].item.Priority < order[j].item.Priority
+				}
+				return order[i].index > order[j].index
+			})
+			for _, seg := range order {
+				if total <= limit {
+					break
+				}
+				// Never below the MinTokens of the item
+				tokens := seg.tokens - (total - limit)
+				if tokens < seg.item.MinTokens {
+					tokens = seg.item.MinTokens
+				}
+				if tokens >= seg.tokens {
+					continue
+				}
+				a.trimSegment(cxt, llm, seg, tokens)
+				total = measure()
+			}
+		}
+	}
+	if total > limit {
+		err := &LLMError{
+			Stage   : AsAskLLM,
+			Status  : LLM_STATUS_EXCEED_CONTEXT,
+			Message : fmt.Sprintf("Prompt of %d tokens exceeds the limit of %d tokens!", total, limit),
+		}
+		return segments, err
+	}
+	if dropped {
+		a.ShortHistoryMessages = short
+	}
+	return segments, nil
+}
@@ -0,0 +1,286 @@
+package autog_test
+
+import (
+	"fmt"
+	"strings"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleContextFit() {
+	mock := &llm.Mock{ Info: autog.ModelInfo{ ContextWindow: 160 } }
+	mock.InitLLM()
+
+	system := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return autog.ROLE_SYSTEM, "Answer briefly."
+		},
+		Priority : 10,
+	}
+	docs := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return autog.ROLE_SYSTEM, strings.Repeat("lorem ipsum ", 100)
+		},
+	}
+
+	agent := &autog.Agent{
+		ContextFit : &autog.ContextFit{
+			ReserveTokens : 32,
+			Policies : []autog.FitPolicy{ autog.FitDropHistory, autog.FitTruncatePrompts },
+		},
+	}
+	for i := 0; i < 4; i++ {
+		agent.ShortHistoryMessages = append(agent.ShortHistoryMessages,
+			autog.ChatMessage{ Role: autog.ROLE_USER, Content: strings.Repeat("question ", 10) },
+			autog.ChatMessage{ Role: autog.ROLE_ASSISTANT, Content: strings.Repeat("answer ", 10) },
+		)
+	}
+	input := &autog.Input{ ReadContent: func() string { return "Hi!" } }
+
+	// The oldest turns are dropped first, then the documents are truncated
+	agent.Prompt(system, docs, agent.ShortHistoryPrompt()).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false)
+	fmt.Println(agent.Err(), agent.PromptTokens <= 128, len(agent.PromptMessages))
+	fmt.Println(len(agent.ShortHistoryMessages), agent.PromptMessages[0].Content, len(agent.PromptMessages[1].Content) < 1200)
+
+	// A prompt the policies cannot fit fails before the call
+	agent.ContextFit.Policies = nil
+	agent.Prompt(system, docs).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false).
+	WaitResponse(nil)
+	fmt.Println(agent.ResponseStatus == autog.LLM_STATUS_EXCEED_CONTEXT, len(mock.Calls()))
+
+	// Output:
+	// <nil> true 3
+	// 1 Answer briefly. true
+	// true 0
+}
+
+func ExamplePromptItem_budget() {
+	mock := &llm.Mock{
+		Info: autog.ModelInfo{ ContextWindow: 100 },
+		Responses: []llm.MockResponse{ { Content: "Paris is the capital of France." } },
+	}
+	mock.InitLLM()
+
+	// The system prompt is never truncated, the retrieved context takes at
+	// most 30 tokens, the history gets the rest
+	system := &autog.PromptItem{
+		Name : "system",
+		GetPrompt : func (query string) (role string, prompt string) {
+			return autog.ROLE_SYSTEM, "Answer briefly."
+		},
+		Priority : 10,
+	}
+	retrieved := &autog.PromptItem{
+		Name : "retrieved",
+		GetPrompt : func (query string) (role string, prompt string) {
+			return autog.ROLE_SYSTEM, "BEGIN " + strings.Repeat("chunk ", 50) + "END"
+		},
+		Priority : 5,
+		MaxTokens : 30,
+		Truncate : autog.TruncateMiddle,
+	}
+	agent := &autog.Agent{
+		ContextFit : &autog.ContextFit{
+			ReserveTokens : 20,
+			Policies : []autog.FitPolicy{ autog.FitTruncatePrompts },
+		},
+	}
+	history := agent.ShortHistoryPrompt()
+	history.MinTokens = 10
+	for i := 0; i < 6; i++ {
+		agent.ShortHistoryMessages = append(agent.ShortHistoryMessages,
+			autog.ChatMessage{ Role: autog.ROLE_USER, Content: fmt.Sprintf("question %d", i) },
+			autog.ChatMessage{ Role: autog.ROLE_ASSISTANT, Content: fmt.Sprintf("answer %d", i) },
+		)
+	}
+	input := &autog.Input{ ReadContent: func() string { return "Hi!" } }
+
+	agent.Prompt(system, retrieved, history).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false)
+	fmt.Println(agent.Err(), agent.PromptTokens <= 80)
+	for _, trim := range agent.PromptTrims {
+		fmt.Println(trim.Name, trim.Tokens > trim.Kept)
+	}
+	content := agent.PromptMessages[1].Content
+	fmt.Println(strings.HasPrefix(content, "BEGIN"), strings.HasSuffix(content, "END"))
+	fmt.Println(agent.PromptMessages[2].Content)
+
+	// A summarized item is replaced by the summary of the weak model
+	retrieved.Truncate = autog.TruncateSummarize
+	agent.Prompt(system, retrieved).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false)
+	fmt.Println(agent.PromptMessages[1].Content, mock.Calls()[0].Weak)
+
+	// Output:
+	// <nil> true
+	// retrieved true
+	// short_history true
+	// true true
+	// question 4
+	// Paris is the capital of France. true
+}
+
+func ExampleContextFit_summarize() {
+	mock := &llm.Mock{
+		Info: autog.ModelInfo{ ContextWindow: 120 },
+		Responses: []llm.MockResponse{ { Content: "We talked about the weather." } },
+	}
+	mock.InitLLM()
+
+	summary := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return "", "Summarize our conversation."
+		},
+	}
+	prefix := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return "", "Summary: "
+		},
+	}
+	agent := &autog.Agent{
+		ContextFit : &autog.ContextFit{
+			ReserveTokens : 20,
+			Policies : []autog.FitPolicy{ autog.FitSummarize },
+			Summary : summary,
+			Prefix  : prefix,
+		},
+	}
+	for i := 0; i < 4; i++ {
+		agent.ShortHistoryMessages = append(agent.ShortHistoryMessages,
+			autog.ChatMessage{ Role: autog.ROLE_USER, Content: strings.Repeat("weather ", 10) },
+			autog.ChatMessage{ Role: autog.ROLE_ASSISTANT, Content: strings.Repeat("sunny ", 10) },
+		)
+	}
+	input := &autog.Input{ ReadContent: func() string { return "Hi!" } }
+
+	// The history is summarized by the weak model, then the prompt fits
+	agent.Prompt(agent.LongHistoryPrompt(), agent.ShortHistoryPrompt()).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false)
+	fmt.Println(agent.Err(), mock.Calls()[0].Weak)
+	for _, msg := range agent.PromptMessages {
+		fmt.Println(msg.Role, msg.Content)
+	}
+
+	// Without history items in the prompt, the history is neither summarized
+	// nor dropped
+	history := &autog.PromptItem{
+		GetMessages : func (query string) []autog.ChatMessage {
+			return agent.GetShortHistory()
+		},
+	}
+	agent.LongHistoryMessages = nil
+	agent.ShortHistoryMessages = nil
+	for i := 0; i < 4; i++ {
+		agent.ShortHistoryMessages = append(agent.ShortHistoryMessages,
+			autog.ChatMessage{ Role: autog.ROLE_USER, Content: strings.Repeat("weather ", 10) },
+			autog.ChatMessage{ Role: autog.ROLE_ASSISTANT, Content: strings.Repeat("sunny ", 10) },
+		)
+	}
+	agent.ContextFit.Policies = []autog.FitPolicy{ autog.FitDropHistory, autog.FitSummarize }
+	agent.Prompt(history).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false)
+	fmt.Println(agent.ResponseStatus == autog.LLM_STATUS_EXCEED_CONTEXT, len(agent.ShortHistoryMessages), len(mock.Calls()))
+
+	// Output:
+	// <nil> true
+	// user Summary: We talked about the weather.
+	// assistant OK
+	// user Hi!
+	// true 8 1
+}
+
+func ExamplePromptItem_truncate() {
+	mock := &llm.Mock{
+		Info: autog.ModelInfo{ ContextWindow: 1000 },
+		Responses: []llm.MockResponse{ { Status: autog.LLM_STATUS_BED_RESPONSE, Content: "Server down!" } },
+	}
+	mock.InitLLM()
+
+	text := &autog.PromptItem{
+		Name : "text",
+		GetPrompt : func (query string) (role string, prompt string) {
+			return autog.ROLE_SYSTEM, "BEGIN " + strings.Repeat("chunk ", 50) + "END"
+		},
+		MaxTokens : 20,
+	}
+	examples := &autog.PromptItem{
+		Name : "examples",
+		GetMessages : func (query string) []autog.ChatMessage {
+			var msgs []autog.ChatMessage
+			for i := 0; i < 4; i++ {
+				msgs = append(msgs, autog.ChatMessage{ Role: autog.ROLE_SYSTEM, Content: fmt.Sprintf("example %d", i) })
+			}
+			return msgs
+		},
+		MaxTokens : 20,
+	}
+	agent := &autog.Agent{ ContextFit: &autog.ContextFit{} }
+	history := agent.ShortHistoryPrompt()
+	history.MaxTokens = 25
+	history.Truncate = autog.TruncateTail
+	input := &autog.Input{ ReadContent: func() string { return "Hi!" } }
+	ask := func() {
+		agent.ShortHistoryMessages = nil
+		for i := 0; i < 4; i++ {
+			agent.ShortHistoryMessages = append(agent.ShortHistoryMessages,
+				autog.ChatMessage{ Role: autog.ROLE_USER, Content: fmt.Sprintf("question %d", i) },
+				autog.ChatMessage{ Role: autog.ROLE_ASSISTANT, Content: fmt.Sprintf("answer %d", i) },
+			)
+		}
+		agent.Prompt(text, examples, history).
+		ReadQuestion(nil, input, nil).
+		AskLLM(mock, false)
+	}
+	show := func() {
+		text := agent.PromptMessages[0].Content
+		fmt.Println(strings.HasPrefix(text, "BEGIN"), strings.HasSuffix(text, "END"))
+		for _, msg := range agent.PromptMessages[1:] {
+			fmt.Println(msg.Role, msg.Content)
+		}
+	}
+
+	// TruncateHead keeps the end of the text and the latest messages, the
+	// history drops whole turns by any strategy
+	text.Truncate = autog.TruncateHead
+	examples.Truncate = autog.TruncateHead
+	ask()
+	show()
+
+	// TruncateTail keeps the beginning of the text and the oldest messages
+	text.Truncate = autog.TruncateTail
+	examples.Truncate = autog.TruncateTail
+	ask()
+	show()
+
+	// A failed summary is recorded, the item is truncated instead
+	text.Truncate = autog.TruncateSummarize
+	ask()
+	for _, trim := range agent.PromptTrims {
+		fmt.Println(trim.Name, trim.Kept <= 20, trim.Err)
+	}
+
+	// Output:
+	// false true
+	// system example 2
+	// system example 3
+	// user question 3
+	// assistant answer 3
+	// user Hi!
+	// true false
+	// system example 0
+	// system example 1
+	// user question 3
+	// assistant answer 3
+	// user Hi!
+	// text true LLM ERROR (BED_RESPONSE): Server down!
+	// examples true <nil>
+	// short_history true <nil>
+}
@@ -0,0 +1,107 @@
+package autog
+
+import (
+	"context"
+)
+
+// GenerationOptions are the sampling settings of a call, a nil field keeps
+// the setting of the provider. Each provider maps the fields it supports.
+type GenerationOptions struct {
+	Temperature      *float32
+	TopP             *float32
+	TopK             *int
+	MaxTokens        *int
+	Stop             []string
+	Seed             *int
+	// N is the number of choices to generate, only the first one is returned
+	N                *int
+	PresencePenalty  *float32
+	FrequencyPenalty *float32
+	LogitBias        map[string]float32
+	User             string
+	// NumCtx is the context window of local runtimes such as Ollama
+	NumCtx           *int
+	// Grammar is a GBNF grammar constraining the output, for llama.cpp
+	Grammar          string
+	// ToolChoice is "auto", "none", "required" or the name of the tool the
+	// model must call, for providers such as OpenAI
+	ToolChoice       string
+}
+
+// Ptr returns a pointer to v, for the optional fields of GenerationOptions
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Merge returns a copy of o with the fields set in over replacing its own
+func (o GenerationOptions) Merge(over *GenerationOptions) GenerationOptions {
+	if over == nil {
+		return o
+	}
+	if over.Temperature != nil {
+		o.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		o.TopP = over.TopP
+	}
+	if over.TopK != nil {
+		o.TopK = over.TopK
+	}
+	if over.MaxTokens != nil {
+		o.MaxTokens = over.MaxTokens
+	}
+	if over.Stop != nil {
+		o.Stop = over.Stop
+	}
+	if over.Seed != nil {
+		o.Seed = over.Seed
+	}
+	if over.N != nil {
+		o.N = over.N
+	}
+	if over.PresencePenalty != nil {
+		o.PresencePenalty = over.PresencePenalty
+	}
+	if over.FrequencyPenalty != nil {
+		o.FrequencyPenalty = over.FrequencyPenalty
+	}
+	if over.LogitBias != nil {
+		o.LogitBias = over.LogitBias
+	}
+	if len(over.User) > 0 {
+		o.User = over.User
+	}
+	if over.NumCtx != nil {
+		o.NumCtx = over.NumCtx
+	}
+	if len(over.Grammar) > 0 {
+		o.Grammar = over.Grammar
+	}
+	if len(over.ToolChoice) > 0 {
+		o.ToolChoice = over.ToolChoice
+	}
+	return o
+}
+
+type generationContextKey struct{}
+
+// WithGenerationOptions returns a copy of cxt whose calls use opts, merged
+// over the options already in cxt.
+func WithGenerationOptions(cxt context.Context, opts GenerationOptions) context.Context {
+	if cxt == nil {
+		cxt = context.Background()
+	}
+	if prev := GenerationOptionsFromContext(cxt); prev != nil {
+		opts = prev.Merge(&opts)
+	}
+	return context.WithValue(cxt, generationContextKey{}, &opts)
+}
+
+// GenerationOptionsFromContext returns the options attached by WithGenerationOptions
+func GenerationOptionsFromContext(cxt context.Context) *GenerationOptions {
+	if cxt == nil {
+		return nil
+	}
+	opts, _ := cxt.Value(generationContextKey{}).(*GenerationOptions)
+	return opts
+}
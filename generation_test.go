@@ -0,0 +1,48 @@
+package autog_test
+
+import (
+	"fmt"
+	"context"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleGenerationOptions() {
+	mock := &llm.Mock{}
+	mock.InitLLM()
+
+	deterministic := &autog.PromptItem{
+		GetPrompt : func (query string) (role string, prompt string) {
+			return autog.ROLE_SYSTEM, "Answer briefly."
+		},
+		Options : &autog.GenerationOptions{ Temperature: autog.Ptr(float32(0)), Seed: autog.Ptr(42) },
+	}
+
+	agent := &autog.Agent{}
+	agent.Prompt(deterministic).
+	ReadQuestion(nil, &autog.Input{ ReadContent: func() string { return "Hi!" } }, nil).
+	AskLLM(mock, false, &autog.GenerationOptions{ MaxTokens: autog.Ptr(64) }).
+	WaitResponse(nil)
+
+	opts := mock.Calls()[0].Options
+	fmt.Println(*opts.Temperature, *opts.Seed, *opts.MaxTokens)
+
+	// The options map to the fields of each provider
+	cxt := autog.WithGenerationOptions(context.Background(), *opts)
+	cxt  = autog.WithGenerationOptions(cxt, autog.GenerationOptions{ Stop: []string{ "\n" } })
+	openai := &llm.OpenAi{ Temperature: 70 }
+	request, _ := openai.CreateChatCompletionRequest(nil, llm.ChatRequestOptions{ Options: autog.GenerationOptionsFromContext(cxt) })
+	data, _ := json.Marshal(request)
+	fmt.Println(string(data))
+
+	ollama := &llm.Ollama{ MaxTokens: 128 }
+	orequest, _ := ollama.CreateChatCompletionRequest(nil, llm.ChatRequestOptions{ Options: autog.GenerationOptionsFromContext(cxt) })
+	data, _ = json.Marshal(orequest.Options)
+	fmt.Println(string(data))
+
+	// Output:
+	// 0 42 64
+	// {"model":"","messages":[],"temperature":0,"stop":["\n"],"max_tokens":64,"seed":42}
+	// {"seed":42,"num_predict":64,"stop":["\n"],"temperature":0}
+}
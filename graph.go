@@ -0,0 +1,227 @@
+package autog
+
+import (
+	"fmt"
+	"reflect"
+)
+
+const (
+	GRAPH_END = "__end__"
+	defaultGraphMaxSteps = 100
+)
+
+// GraphState is the custom state shared by the nodes of a graph run,
+// it also records where the run is, so a run can be resumed.
+type GraphState struct {
+	Values  map[string]interface{}
+	Current string
+	Steps   int
+	Visits  map[string]int
+	Trace   []string
+}
+
+func NewGraphState() *GraphState {
+	return &GraphState{
+		Values : make(map[string]interface{}),
+		Visits : make(map[string]int),
+	}
+}
+
+func (s *GraphState) Get(key string) interface{} {
+	if s.Values == nil {
+		return nil
+	}
+	return s.Values[key]
+}
+
+func (s *GraphState) Set(key string, value interface{}) {
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+	s.Values[key] = value
+}
+
+type GraphNode struct {
+	Name string
+	// MaxVisits guards loops through this node, 0 means no limit
+	MaxVisits int
+	Do func (a *Agent, state *GraphState) error
+}
+
+func (n *GraphNode) doDo(a *Agent, state *GraphState) error {
+	if n.Do == nil {
+		return nil
+	}
+	return n.Do(a, state)
+}
+
+type GraphEdge struct {
+	To string
+	// Cond selects the edge, a nil Cond always matches
+	Cond func (a *Agent, state *GraphState) bool
+}
+
+func (e *GraphEdge) doCond(a *Agent, state *GraphState) bool {
+	if e.Cond == nil {
+		return true
+	}
+	return e.Cond(a, state)
+}
+
+// Graph runs agent steps as a state machine, after a node is done the
+// edges leaving it are checked in the order they were added, the first
+// matching edge selects the next node, the run ends at GRAPH_END or when
+// no edge matches. A node failing with an LLMError is routed the same way,
+// so WhenStatus edges can recover from a failed call.
+type Graph struct {
+	Start    string
+	MaxSteps int
+	Nodes    map[string]*GraphNode
+	Edges    map[string][]*GraphEdge
+}
+
+func NewGraph(start string) *Graph {
+	return &Graph{
+		Start : start,
+		Nodes : make(map[string]*GraphNode),
+		Edges : make(map[string][]*GraphEdge),
+	}
+}
+
+// AddNode adds a node running a custom function
+func (g *Graph) AddNode(name string, do func (a *Agent, state *GraphState) error) *Graph {
+	g.Nodes[name] = &GraphNode{ Name: name, Do: do }
+	return g
+}
+
+// AddStep adds a node running agent steps, e.g.
+//
+//	g.AddStep("ask", func (a *Agent) *Agent {
+//		return a.AskLLM(llm, true).WaitResponse(nil)
+//	})
+//
+// A failed LLM call is routed by the edges, e.g. WhenStatus, the run stops
+// with the LLMError only when no edge matches. Other errors stop the run.
+func (g *Graph) AddStep(name string, step func (a *Agent) *Agent) *Graph {
+	return g.AddNode(name, func (a *Agent, state *GraphState) error {
+		step(a)
+		return a.Err()
+	})
+}
+
+// SetMaxVisits limits how many times a node can run in one graph run
+func (g *Graph) SetMaxVisits(name string, visits int) *Graph {
+	if node, ok := g.Nodes[name]; ok {
+		node.MaxVisits = visits
+	}
+	return g
+}
+
+// AddEdge adds an edge from one node to another, a nil cond always matches
+func (g *Graph) AddEdge(from, to string, cond func (a *Agent, state *GraphState) bool) *Graph {
+	g.Edges[from] = append(g.Edges[from], &GraphEdge{ To: to, Cond: cond })
+	return g
+}
+
+func (g *Graph) Next(a *Agent, state *GraphState, from string) string {
+	next, _ := g.nextEdge(a, state, from)
+	return next
+}
+
+// nextEdge returns the node of the first matching edge, false if none matches
+func (g *Graph) nextEdge(a *Agent, state *GraphState, from string) (string, bool) {
+	for _, edge := range g.Edges[from] {
+		if edge.doCond(a, state) {
+			return edge.To, true
+		}
+	}
+	return GRAPH_END, false
+}
+
+// Run runs the graph from its start node
+func (g *Graph) Run(a *Agent, state *GraphState) (*GraphState, error) {
+	return g.Resume(a, state, g.Start)
+}
+
+// Resume runs the graph from the named node, a nil state starts a new one
+func (g *Graph) Resume(a *Agent, state *GraphState, from string) (*GraphState, error) {
+	if state == nil {
+		state = NewGraphState()
+	}
+	if state.Visits == nil {
+		state.Visits = make(map[string]int)
+	}
+	maxsteps := defaultGraphMaxSteps
+	if g.MaxSteps > 0 {
+		maxsteps = g.MaxSteps
+	}
+
+	current := from
+	steps   := 0
+	for current != GRAPH_END {
+		state.Current = current
+		node, ok := g.Nodes[current]
+		if !ok {
+			return state, fmt.Errorf("Graph node [%s] not exists!", current)
+		}
+		if steps >= maxsteps {
+			return state, fmt.Errorf("Graph exceeded max steps %d at node [%s]!", maxsteps, current)
+		}
+		if node.MaxVisits > 0 && state.Visits[current] >= node.MaxVisits {
+			return state, fmt.Errorf("Graph node [%s] exceeded max visits %d!", current, node.MaxVisits)
+		}
+		steps++
+		state.Steps++
+		state.Visits[current]++
+		state.Trace = append(state.Trace, current)
+		err := node.doDo(a, state)
+		if _, ok := err.(*LLMError); err != nil && !ok {
+			return state, err
+		}
+		next, ok := g.nextEdge(a, state, current)
+		if err != nil {
+			if !ok {
+				return state, err
+			}
+			// the edge handles the failed call, the next steps run again
+			a.lastErr = nil
+		}
+		current = next
+	}
+	state.Current = GRAPH_END
+	return state, nil
+}
+
+// WhenStatus matches when the last response status is one of status
+func WhenStatus(status ...LLMStatus) func (a *Agent, state *GraphState) bool {
+	return func (a *Agent, state *GraphState) bool {
+		for _, sts := range status {
+			if a.ResponseStatus == sts {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WhenReflection matches when the last action asked for a reflection
+func WhenReflection() func (a *Agent, state *GraphState) bool {
+	return func (a *Agent, state *GraphState) bool {
+		return a.CanDoReflection && len(a.ReflectionContent) > 0
+	}
+}
+
+// WhenNoReflection matches when the last action did not ask for a reflection
+func WhenNoReflection() func (a *Agent, state *GraphState) bool {
+	return func (a *Agent, state *GraphState) bool {
+		return !(a.CanDoReflection && len(a.ReflectionContent) > 0)
+	}
+}
+
+// WhenState matches when the custom state value of key deeply equals value,
+// so slices and maps compare by content
+func WhenState(key string, value interface{}) func (a *Agent, state *GraphState) bool {
+	return func (a *Agent, state *GraphState) bool {
+		return reflect.DeepEqual(state.Get(key), value)
+	}
+}
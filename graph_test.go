@@ -0,0 +1,120 @@
+package autog_test
+
+import (
+	"fmt"
+	"strings"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleGraph() {
+	agent := &autog.Agent{}
+
+	graph := autog.NewGraph("draft").
+		AddNode("draft", func (a *autog.Agent, state *autog.GraphState) error {
+			drafts, _ := state.Get("drafts").(int)
+			state.Set("drafts", drafts + 1)
+			state.Set("done", drafts + 1 >= 3)
+			return nil
+		}).
+		AddNode("publish", func (a *autog.Agent, state *autog.GraphState) error {
+			return nil
+		}).
+		AddEdge("draft", "publish", autog.WhenState("done", true)).
+		AddEdge("draft", "draft", nil).
+		SetMaxVisits("draft", 5)
+
+	state, err := graph.Run(agent, nil)
+	fmt.Println(state.Trace, err)
+
+	state, err = graph.Resume(agent, state, "draft")
+	fmt.Println(state.Trace, err)
+
+	_, err = autog.NewGraph("loop").
+		AddNode("loop", nil).
+		AddEdge("loop", "loop", nil).
+		SetMaxVisits("loop", 5).
+		Run(agent, nil)
+	fmt.Println(err)
+
+	// Slices and maps compare by content
+	state, err = autog.NewGraph("tag").
+		AddNode("tag", func (a *autog.Agent, state *autog.GraphState) error {
+			state.Set("tags", []string{ "go" })
+			return nil
+		}).
+		AddNode("go", nil).
+		AddEdge("tag", "go", autog.WhenState("tags", []string{ "go" })).
+		Run(agent, nil)
+	fmt.Println(state.Trace, err)
+
+	// Output:
+	// [draft draft draft publish] <nil>
+	// [draft draft draft publish draft publish] <nil>
+	// Graph node [loop] exceeded max visits 5!
+	// [tag go] <nil>
+}
+
+func ExampleGraph_steps() {
+	mock := &llm.Mock{}
+	mock.InitLLM()
+	input := &autog.Input{ ReadContent: func() string { return "How is the weather in Paris?" } }
+	check := &autog.DoAction{
+		Do: func (content string) (bool, string) {
+			if !strings.HasPrefix(content, "{") {
+				return false, "Reply a JSON object!"
+			}
+			return true, ""
+		},
+	}
+
+	graph := autog.NewGraph("ask").
+		AddStep("ask", func (a *autog.Agent) *autog.Agent {
+			return a.Prompt().
+			ReadQuestion(nil, input, nil).
+			AskLLM(mock, false).
+			WaitResponse(nil).
+			Action(check)
+		}).
+		AddNode("retry", nil).
+		AddStep("reflect", func (a *autog.Agent) *autog.Agent {
+			return a.AskReflection(a.ReflectionContent).
+			WaitResponse(nil).
+			Action(check)
+		}).
+		AddEdge("ask", "retry", autog.WhenStatus(autog.LLM_STATUS_BED_RESPONSE)).
+		AddEdge("ask", "reflect", autog.WhenReflection()).
+		AddEdge("retry", "ask", nil).
+		AddEdge("reflect", "reflect", autog.WhenReflection()).
+		SetMaxVisits("reflect", 2)
+
+	run := func (responses ...llm.MockResponse) {
+		mock.Reset()
+		mock.Responses = responses
+		state, err := graph.Run(&autog.Agent{}, nil)
+		fmt.Println(state.Trace, err)
+	}
+
+	// A failed call is routed to the recovery node, a bad reply is reflected
+	run(
+		llm.MockResponse{ Status: autog.LLM_STATUS_BED_RESPONSE, Content: "Server down!" },
+		llm.MockResponse{ Content: "Sunny" },
+		llm.MockResponse{ Content: "Sunny!" },
+		llm.MockResponse{ Content: `{"weather":"sunny"}` },
+	)
+
+	// The reflection loop is guarded by its max visits
+	run(
+		llm.MockResponse{ Content: "Sunny" },
+		llm.MockResponse{ Content: "Sunny!" },
+		llm.MockResponse{ Content: "Sunny!!" },
+	)
+
+	// A failure no edge handles stops the run
+	run(llm.MockResponse{ Status: autog.LLM_STATUS_BED_MESSAGE, Content: "Broken stream!" })
+
+	// Output:
+	// [ask retry ask reflect reflect] <nil>
+	// [ask reflect reflect] Graph node [reflect] exceeded max visits 2!
+	// [ask] LLM ERROR (BED_MESSAGE): Broken stream!
+}
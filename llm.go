@@ -28,24 +28,77 @@ const (
 	ROLE_SYSTEM    string = "system"
 	ROLE_USER      string = "user"
 	ROLE_ASSISTANT string = "assistant"
+	ROLE_TOOL      string = "tool"
 )
 
 func IsValidRole(role string) bool {
-	if role == ROLE_SYSTEM || role == ROLE_USER || role == ROLE_ASSISTANT {
+	if role == ROLE_SYSTEM || role == ROLE_USER || role == ROLE_ASSISTANT || role == ROLE_TOOL {
 		return true
 	}
 	return false
 }
 
+// Tool describes a function the model may call, Parameters is a JSON schema object
+type Tool struct {
+	Name       string      `json:"name"`
+	Desc       string      `json:"description,omitempty"`
+	Parameters interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall is a function call requested by the model, Arguments is a JSON encoded object
+type ToolCall struct {
+	Id        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
 type ChatMessage struct {
 	Role string    `json:"role"`
 	Content string `json:"content"`
+	// Parts are the multimodal content parts, sent after Content
+	Parts []ContentPart `json:"parts,omitempty"`
+	// ToolCalls are the function calls requested by an assistant message
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallId links a tool message to the ToolCall it answers
+	ToolCallId string `json:"tool_call_id,omitempty"`
+	// Name is the function name of a tool message
+	Name string `json:"name,omitempty"`
+	// Err is the error behind a failed call, Content holds its text
+	Err error `json:"-"`
+	// Usage is the token usage of the call that returned the message
+	Usage *Usage `json:"-"`
 }
 
 func (cm *ChatMessage) String() string {
+	if len(cm.ToolCalls) > 0 {
+		return fmt.Sprintf("{Role:%s, Content:%s, ToolCalls:%v}", cm.Role, cm.Content, cm.ToolCalls)
+	}
+	if len(cm.ToolCallId) > 0 {
+		return fmt.Sprintf("{Role:%s, Content:%s, ToolCallId:%s}", cm.Role, cm.Content, cm.ToolCallId)
+	}
 	return fmt.Sprintf("{Role:%s, Content:%s}", cm.Role, cm.Content)
 }
 
+type toolsContextKey struct{}
+
+// WithTools returns a copy of cxt carrying the tool definitions that
+// SendMessages and SendMessagesStream will offer to the model.
+func WithTools(cxt context.Context, tools ...Tool) context.Context {
+	if cxt == nil {
+		cxt = context.Background()
+	}
+	return context.WithValue(cxt, toolsContextKey{}, tools)
+}
+
+// ToolsFromContext returns the tool definitions attached by WithTools
+func ToolsFromContext(cxt context.Context) []Tool {
+	if cxt == nil {
+		return nil
+	}
+	tools, _ := cxt.Value(toolsContextKey{}).([]Tool)
+	return tools
+}
+
 type StreamReader interface {
 	StreamStart() *strings.Builder
 	StreamDelta(contentbuf *strings.Builder, delta string)
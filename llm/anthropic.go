@@ -0,0 +1,617 @@
+package llm
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"errors"
+	"strings"
+	"context"
+	"net/http"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+const (
+	anthropicDefaultBaseURL    = "https://api.anthropic.com"
+	anthropicDefaultVendor     = "anthropic"
+	anthropicDefaultVersion    = "2023-06-01"
+	anthropicDefaultModel      = "claude-3-5-sonnet-latest"
+	anthropicDefaultModelWeak  = "claude-3-5-haiku-latest"
+	anthropicDefaultMaxTokens  = 4096
+)
+
+// AnthropicAPIError represents an error returned by the Messages API
+type AnthropicAPIError struct {
+	StatusCode int    `json:"status_code"`
+	Type       string `json:"type"`
+	Message    string `json:"message"`
+}
+
+func (e AnthropicAPIError) Error() string {
+	return fmt.Sprintf("[%d:%s] %s", e.StatusCode, e.Type, e.Message)
+}
+
+type AnthropicAPIErrorResponse struct {
+	Type  string            `json:"type"`
+	Error AnthropicAPIError `json:"error"`
+}
+
+type AnthropicImageSource struct {
+	// Type is "base64" or "url"
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+// AnthropicContentBlock is a block of text, image, tool_use or tool_result
+type AnthropicContentBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *AnthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+type AnthropicTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+}
+
+type AnthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type AnthropicMessagesRequest struct {
+	Model         string             `json:"model"`
+	// System is hoisted from the system messages, the API has no system role
+	System        string             `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	// MaxTokens is required by the API
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	TopK          *int               `json:"top_k,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Tools         []AnthropicTool    `json:"tools,omitempty"`
+	Metadata      *AnthropicMetadata `json:"metadata,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicMessagesResponse struct {
+	ID         string                  `json:"id"`
+	Type       string                  `json:"type"`
+	Role       string                  `json:"role"`
+	Model      string                  `json:"model"`
+	Content    []AnthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      AnthropicUsage          `json:"usage"`
+}
+
+type AnthropicStreamDelta struct {
+	// Type is "text_delta" or "input_json_delta" in a content_block_delta
+	Type        string `json:"type"`
+	Text        string `json:"text,omitempty"`
+	PartialJson string `json:"partial_json,omitempty"`
+	StopReason  string `json:"stop_reason,omitempty"`
+}
+
+// AnthropicStreamEvent is the data of a server sent event, its Type is one
+// of message_start, content_block_start, content_block_delta,
+// content_block_stop, message_delta, message_stop, ping and error.
+type AnthropicStreamEvent struct {
+	Type         string                     `json:"type"`
+	Index        int                        `json:"index"`
+	Message      *AnthropicMessagesResponse `json:"message,omitempty"`
+	ContentBlock *AnthropicContentBlock     `json:"content_block,omitempty"`
+	Delta        *AnthropicStreamDelta      `json:"delta,omitempty"`
+	Usage        *AnthropicUsage            `json:"usage,omitempty"`
+	Error        *AnthropicAPIError         `json:"error,omitempty"`
+}
+
+type Anthropic struct {
+	ApiKey      string
+	ApiBase     string
+	ApiVendor   string
+	// ApiVersion is sent as the anthropic-version header
+	ApiVersion  string
+	Model       string
+	ModelWeak   string
+	Temperature     int
+	TemperatureWeak int
+	TimeOut       int
+	TimeOutWeak   int
+	MaxTokens     int
+	MaxTokensWeak int
+	Verbose       int
+	VerboseLog    func(log string)
+	// Retry retries failed requests, no retry if nil
+	Retry         *RetryPolicy
+	// Tokenizer counts the tokens of Model, an estimator if not set
+	Tokenizer     tokenizer.Tokenizer
+	TokenizerWeak tokenizer.Tokenizer
+	// Transport replaces the http transport of the clients, e.g. a Cassette
+	Transport     http.RoundTripper
+	// HttpClient and HttpClientWeak replace the clients of the main and weak
+	// calls, TimeOut and Transport are not applied
+	HttpClient     *http.Client
+	HttpClientWeak *http.Client
+	// TLS configures the default transport, certificates are verified by default,
+	// InitLLM fails when Transport is set too
+	TLS           *TLSOptions
+	// Headers are added to every request, e.g. anthropic-beta
+	Headers       map[string]string
+
+	modelInfo     autog.ModelInfo
+	modelInfoWeak autog.ModelInfo
+	httpClients
+}
+
+func (gpt *Anthropic) InitLLM() error {
+	if len(gpt.ApiKey) <= 0 {
+		return fmt.Errorf("API Key is needed!")
+	}
+	if len(gpt.ApiBase) <= 0 {
+		gpt.ApiBase = anthropicDefaultBaseURL
+	}
+	if len(gpt.ApiVendor) <= 0 {
+		gpt.ApiVendor = anthropicDefaultVendor
+	}
+	if len(gpt.ApiVersion) <= 0 {
+		gpt.ApiVersion = anthropicDefaultVersion
+	}
+	if len(gpt.Model) <= 0 {
+		gpt.Model = anthropicDefaultModel
+	}
+	if len(gpt.ModelWeak) <= 0 {
+		gpt.ModelWeak = anthropicDefaultModelWeak
+	}
+	if gpt.MaxTokens <= 0 {
+		gpt.MaxTokens = anthropicDefaultMaxTokens
+	}
+	if gpt.MaxTokensWeak <= 0 {
+		gpt.MaxTokensWeak = anthropicDefaultMaxTokens
+	}
+	gpt.modelInfo, _     = autog.LookupModelInfo(gpt.Model)
+	gpt.modelInfoWeak, _ = autog.LookupModelInfo(gpt.ModelWeak)
+	if gpt.Tokenizer == nil {
+		gpt.Tokenizer = modelTokenizer(gpt.modelInfo)
+	}
+	if gpt.TokenizerWeak == nil {
+		gpt.TokenizerWeak = modelTokenizer(gpt.modelInfoWeak)
+	}
+
+	return gpt.initHttpClients(gpt.Transport, gpt.TLS, &gpt.TimeOut, &gpt.TimeOutWeak,
+		gpt.HttpClient, gpt.HttpClientWeak)
+}
+
+func (gpt *Anthropic) ConvertContent(msg autog.ChatMessage) ([]AnthropicContentBlock, error) {
+	var blocks []AnthropicContentBlock
+	if msg.Role == autog.ROLE_TOOL {
+		return []AnthropicContentBlock{
+			{ Type: "tool_result", ToolUseID: msg.ToolCallId, Content: msg.Text() },
+		}, nil
+	}
+	if len(msg.Content) > 0 {
+		blocks = append(blocks, AnthropicContentBlock{ Type: "text", Text: msg.Content })
+	}
+	for _, part := range msg.Parts {
+		switch part.Type {
+		case autog.PART_TEXT:
+			blocks = append(blocks, AnthropicContentBlock{ Type: "text", Text: part.Text })
+		case autog.PART_IMAGE:
+			source := &AnthropicImageSource{}
+			if strings.HasPrefix(part.ImageUrl, "http://") || strings.HasPrefix(part.ImageUrl, "https://") {
+				source.Type = "url"
+				source.Url  = part.ImageUrl
+			} else {
+				mimetype, data, err := part.LoadImage()
+				if err != nil {
+					return nil, err
+				}
+				source.Type      = "base64"
+				source.MediaType = mimetype
+				source.Data      = data
+			}
+			blocks = append(blocks, AnthropicContentBlock{ Type: "image", Source: source })
+		default:
+			return nil, fmt.Errorf("Content part type %s is not supported!", part.Type)
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		input := json.RawMessage(call.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		blocks = append(blocks, AnthropicContentBlock{ Type: "tool_use", ID: call.Id, Name: call.Name, Input: input })
+	}
+	return blocks, nil
+}
+
+// ConvertMessages returns the system prompt and the messages, tool results
+// are sent as user messages, and consecutive messages of a role are merged.
+func (gpt *Anthropic) ConvertMessages(msgs []autog.ChatMessage) (string, []AnthropicMessage, error) {
+	var systems []string
+	var reqmsgs []AnthropicMessage
+	for _, msg := range msgs {
+		if msg.Role == autog.ROLE_SYSTEM {
+			if text := msg.Text(); len(text) > 0 {
+				systems = append(systems, text)
+			}
+			continue
+		}
+		role := msg.Role
+		if role == autog.ROLE_TOOL {
+			role = autog.ROLE_USER
+		}
+		blocks, err := gpt.ConvertContent(msg)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(blocks) <= 0 {
+			continue
+		}
+		if n := len(reqmsgs); n > 0 && reqmsgs[n-1].Role == role {
+			reqmsgs[n-1].Content = append(reqmsgs[n-1].Content, blocks...)
+			continue
+		}
+		reqmsgs = append(reqmsgs, AnthropicMessage{ Role: role, Content: blocks })
+	}
+	return strings.Join(systems, "\n\n"), reqmsgs, nil
+}
+
+func (gpt *Anthropic) ConvertTools(tools []autog.Tool) []AnthropicTool {
+	if len(tools) <= 0 {
+		return nil
+	}
+	reqtools := make([]AnthropicTool, len(tools))
+	for i, tool := range tools {
+		schema := tool.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{ "type": "object" }
+		}
+		reqtools[i] = AnthropicTool{ Name: tool.Name, Description: tool.Desc, InputSchema: schema }
+	}
+	return reqtools
+}
+
+func (gpt *Anthropic) ConvertResponse(response *AnthropicMessagesResponse) (string, []autog.ToolCall) {
+	buf := strings.Builder{}
+	var calls []autog.ToolCall
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			buf.WriteString(block.Text)
+		case "tool_use":
+			args := string(block.Input)
+			if len(args) <= 0 {
+				args = "{}"
+			}
+			calls = append(calls, autog.ToolCall{ Id: block.ID, Name: block.Name, Arguments: args })
+		}
+	}
+	return buf.String(), calls
+}
+
+func (gpt *Anthropic) ConvertUsage(model string, usage AnthropicUsage) autog.Usage {
+	return autog.Usage{
+		Model            : model,
+		Kind             : autog.UsageKindChat,
+		PromptTokens     : usage.InputTokens,
+		CompletionTokens : usage.OutputTokens,
+		TotalTokens      : usage.InputTokens + usage.OutputTokens,
+	}
+}
+
+// ErrorStatus maps an error of a call to a status, an overloaded server and a
+// prompt too long report LLM_STATUS_EXCEED_CONTEXT, so a Router can fall back.
+func (gpt *Anthropic) ErrorStatus(err error) autog.LLMStatus {
+	var apiErr AnthropicAPIError
+	if !errors.As(err, &apiErr) {
+		return autog.LLM_STATUS_BED_RESPONSE
+	}
+	switch apiErr.Type {
+	case "overloaded_error", "request_too_large":
+		return autog.LLM_STATUS_EXCEED_CONTEXT
+	case "invalid_request_error":
+		if strings.Contains(strings.ToLower(apiErr.Message), "prompt is too long") {
+			return autog.LLM_STATUS_EXCEED_CONTEXT
+		}
+		return autog.LLM_STATUS_BED_REQUEST
+	case "authentication_error", "permission_error", "not_found_error":
+		return autog.LLM_STATUS_BED_REQUEST
+	}
+	return autog.LLM_STATUS_BED_RESPONSE
+}
+
+// ApplyGenerationOptions sets the fields of request given in opts, other
+// options are not supported and ignored.
+func (gpt *Anthropic) ApplyGenerationOptions(request *AnthropicMessagesRequest, opts *autog.GenerationOptions) {
+	if opts == nil {
+		return
+	}
+	if opts.Temperature != nil {
+		request.Temperature = opts.Temperature
+	}
+	if opts.TopP != nil {
+		request.TopP = opts.TopP
+	}
+	if opts.TopK != nil {
+		request.TopK = opts.TopK
+	}
+	if opts.MaxTokens != nil {
+		request.MaxTokens = *opts.MaxTokens
+	}
+	if opts.Stop != nil {
+		request.StopSequences = opts.Stop
+	}
+	if len(opts.User) > 0 {
+		request.Metadata = &AnthropicMetadata{ UserID: opts.User }
+	}
+}
+
+func (gpt *Anthropic) CreateMessagesRequest(msgs []autog.ChatMessage, ropts ChatRequestOptions) (*AnthropicMessagesRequest, error) {
+	model       := gpt.Model
+	temperature := gpt.Temperature
+	maxtokens   := gpt.MaxTokens
+
+	if ropts.Weak {
+		model       = gpt.ModelWeak
+		temperature = gpt.TemperatureWeak
+		maxtokens   = gpt.MaxTokensWeak
+	}
+	if maxtokens <= 0 {
+		maxtokens = anthropicDefaultMaxTokens
+	}
+
+	system, reqmsgs, err := gpt.ConvertMessages(msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &AnthropicMessagesRequest{
+		Model     : model,
+		System    : system,
+		Messages  : reqmsgs,
+		MaxTokens : maxtokens,
+		Stream    : ropts.Stream,
+		Tools     : gpt.ConvertTools(ropts.Tools),
+	}
+	if temperature > 0 {
+		request.Temperature = autog.Ptr(float32(temperature) / float32(100))
+	}
+	gpt.ApplyGenerationOptions(request, ropts.Options)
+	return request, nil
+}
+
+func (gpt *Anthropic) CreateHttpRequest(cxt context.Context, method, path string, payload interface{}) (*http.Request, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	url := gpt.ApiBase + path
+	req, err := http.NewRequestWithContext(cxt, method, url, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-type", "application/json")
+	req.Header.Set("x-api-key", gpt.ApiKey)
+	req.Header.Set("anthropic-version", gpt.ApiVersion)
+	setHttpHeaders(req, gpt.Headers)
+	return req, nil
+}
+
+func (gpt *Anthropic) CheckHttpResponseSuccess(httpRsp *http.Response) error {
+	return checkHttpResponse(httpRsp, func (code int, data []byte) error {
+		var result AnthropicAPIErrorResponse
+		if err := json.Unmarshal(data, &result); err != nil || len(result.Error.Type) <= 0 {
+			return AnthropicAPIError{
+				StatusCode: code,
+				Type:       "Unexpected",
+				Message:    string(data),
+			}
+		}
+		result.Error.StatusCode = code
+		return result.Error
+	})
+}
+
+func (gpt *Anthropic) SendMessagesInner(cxt context.Context, msgs []autog.ChatMessage, weak bool) (autog.LLMStatus, autog.ChatMessage) {
+	request, err := gpt.CreateMessagesRequest(msgs, chatRequestOptions(cxt, weak, false))
+	if err != nil {
+		return requestError(nil, err)
+	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", "/v1/messages", request)
+	if err != nil {
+		return requestError(nil, err)
+	}
+	httpRsp, err := getHttpResponse(gpt.chatClient(weak), httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if err != nil {
+		return streamError(nil, nil, gpt.ErrorStatus(err), err)
+	}
+	response := AnthropicMessagesResponse{}
+	if err := getHttpBodyObject(httpRsp, &response); err != nil {
+		return streamError(nil, nil, autog.LLM_STATUS_BED_MESSAGE, err)
+	}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	usage := gpt.ConvertUsage(request.Model, response.Usage)
+	autog.RecordUsage(cxt, usage)
+
+	content, toolcalls := gpt.ConvertResponse(&response)
+	revMsg := autog.ChatMessage{
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : content,
+		ToolCalls : toolcalls,
+		Usage     : &usage,
+	}
+
+	return autog.LLM_STATUS_OK, revMsg
+}
+
+func (gpt *Anthropic) SendMessagesStreamInner(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader, weak bool) (autog.LLMStatus, autog.ChatMessage) {
+	request, err := gpt.CreateMessagesRequest(msgs, chatRequestOptions(cxt, weak, true))
+	if err != nil {
+		return requestError(reader, err)
+	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	contentbuf := startStream(reader)
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", "/v1/messages", request)
+	if err != nil {
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_REQUEST, err)
+	}
+	httpRsp, err := getHttpResponse(gpt.chatClient(weak), httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if err != nil {
+		return streamError(reader, contentbuf, gpt.ErrorStatus(err), err)
+	}
+	defer httpRsp.Body.Close()
+
+	status := autog.LLM_STATUS_BED_MESSAGE
+	usage := AnthropicUsage{}
+	// The tool_use blocks by index, their input is streamed as JSON fragments
+	blocks := map[int]*AnthropicContentBlock{}
+	inputs := map[int]*strings.Builder{}
+	var order []int
+	readErr := readServerEvents(httpRsp.Body, func (data []byte) (bool, error) {
+		event := AnthropicStreamEvent{}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return false, fmt.Errorf("Invalid json stream data: %v", err)
+		}
+
+		var delta string
+		switch event.Type {
+		case "message_stop":
+			return true, nil
+		case "error":
+			if event.Error != nil {
+				status = gpt.ErrorStatus(*event.Error)
+				return false, *event.Error
+			}
+		case "message_start":
+			if event.Message != nil {
+				usage.InputTokens = event.Message.Usage.InputTokens
+			}
+		case "content_block_start":
+			if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+				block := *event.ContentBlock
+				blocks[event.Index] = &block
+				inputs[event.Index] = &strings.Builder{}
+				order = append(order, event.Index)
+			}
+		case "content_block_delta":
+			if event.Delta == nil {
+				break
+			}
+			if event.Delta.Type == "input_json_delta" {
+				if input, ok := inputs[event.Index]; ok {
+					input.WriteString(event.Delta.PartialJson)
+				}
+			} else {
+				delta = event.Delta.Text
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		}
+
+		if len(delta) > 0 {
+			contentbuf.WriteString(delta)
+			if reader != nil {
+				reader.StreamDelta(contentbuf, delta)
+			}
+		}
+		return false, nil
+	})
+	if readErr == io.EOF {
+		readErr = fmt.Errorf("Stream ended before message_stop!")
+	}
+	if readErr != nil {
+		return streamError(reader, contentbuf, status, readErr)
+	}
+	if reader != nil {
+		reader.StreamEnd(contentbuf)
+	}
+
+	var toolcalls []autog.ToolCall
+	for _, index := range order {
+		args := inputs[index].String()
+		if len(args) <= 0 {
+			args = "{}"
+		}
+		toolcalls = append(toolcalls, autog.ToolCall{ Id: blocks[index].ID, Name: blocks[index].Name, Arguments: args })
+	}
+
+	revUsage := gpt.ConvertUsage(request.Model, usage)
+	autog.RecordUsage(cxt, revUsage)
+
+	revMsg := autog.ChatMessage{
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : contentbuf.String(),
+		ToolCalls : toolcalls,
+		Usage     : &revUsage,
+	}
+
+	return autog.LLM_STATUS_OK, revMsg
+}
+
+func (gpt *Anthropic) CalcTokens(cxt context.Context, content string) int {
+	if gpt.Tokenizer == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.Tokenizer.Count(content)
+}
+
+func (gpt *Anthropic) SendMessages(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.SendMessagesInner(cxt, msgs, false)
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
+	return status, msg
+}
+
+func (gpt *Anthropic) SendMessagesStream(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.SendMessagesStreamInner(cxt, msgs, reader, false)
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
+	return status, msg
+}
+
+func (gpt *Anthropic) CalcTokensByWeakModel(cxt context.Context, content string) int {
+	if gpt.TokenizerWeak == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.TokenizerWeak.Count(content)
+}
+
+func (gpt *Anthropic) SendMessagesByWeakModel(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesInner(cxt, msgs, true)
+}
+
+func (gpt *Anthropic) SendMessagesStreamByWeakModel(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesStreamInner(cxt, msgs, reader, true)
+}
+
+func (gpt *Anthropic) ModelInfo() autog.ModelInfo {
+	return gpt.modelInfo
+}
+
+func (gpt *Anthropic) ModelInfoByWeakModel() autog.ModelInfo {
+	return gpt.modelInfoWeak
+}
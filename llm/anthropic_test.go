@@ -0,0 +1,109 @@
+package llm_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"context"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+const anthropicStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-sonnet-latest","content":[],"usage":{"input_tokens":25,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func ExampleAnthropic_SendMessagesStream() {
+	overloaded := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if overloaded {
+			w.WriteHeader(529)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		var request struct {
+			System   string            `json:"system"`
+			Messages []json.RawMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Println(r.URL.Path, r.Header.Get("x-api-key"), r.Header.Get("anthropic-version"))
+		fmt.Println(request.System, len(request.Messages))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, anthropicStream)
+	}))
+	defer server.Close()
+
+	claude := &llm.Anthropic{ ApiBase: server.URL, ApiKey: "test" }
+	err := claude.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	cxt := autog.WithTools(context.Background(), autog.Tool{
+		Name: "get_weather",
+		Desc: "Get the weather of a city",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"city": map[string]interface{}{ "type": "string" },
+			},
+		},
+	})
+	msgs := []autog.ChatMessage{
+		{ Role: autog.ROLE_SYSTEM, Content: "You are a weather bot." },
+		{ Role: autog.ROLE_USER, Content: "How is the weather in Paris?" },
+	}
+	status, msg := claude.SendMessagesStream(cxt, msgs, printReader{})
+
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content)
+	for _, call := range msg.ToolCalls {
+		fmt.Printf("%s %s %s\n", call.Id, call.Name, call.Arguments)
+	}
+	fmt.Println(msg.Usage.PromptTokens, msg.Usage.CompletionTokens)
+
+	overloaded = true
+	status, msg = claude.SendMessages(cxt, msgs)
+	fmt.Println(status == autog.LLM_STATUS_EXCEED_CONTEXT, msg.Err)
+
+	// Output:
+	// /v1/messages test 2023-06-01
+	// You are a weather bot. 1
+	// [Let me ][check.]
+	// true Let me check.
+	// toolu_1 get_weather {"city":"Paris"}
+	// 25 12
+	// true [529:overloaded_error] Overloaded
+}
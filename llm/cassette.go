@@ -0,0 +1,261 @@
+package llm
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sync"
+	"bytes"
+	"net/url"
+	"net/http"
+	"encoding/json"
+)
+
+type CassetteMode int
+
+const (
+	// CassetteReplay serves the recorded responses, without network
+	CassetteReplay CassetteMode = iota
+	// CassetteRecord sends the requests and records the responses
+	CassetteRecord
+)
+
+var (
+	// Query parameters dropped from the recorded paths, they may carry keys
+	cassetteSecretParams = []string{ "key", "api-key", "api_key" }
+	// Response headers not recorded
+	cassetteSecretHeaders = []string{ "Set-Cookie" }
+)
+
+// CassetteInteraction is a recorded request and response pair, Chunks are
+// the reads of the response body, so streams replay with their chunking.
+type CassetteInteraction struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Body   string      `json:"body,omitempty"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Chunks []string    `json:"chunks"`
+}
+
+type cassetteFile struct {
+	Interactions []*CassetteInteraction `json:"interactions"`
+}
+
+// Cassette is an http.RoundTripper recording the http traffic of providers
+// to a file, and replaying it later without network. Set it as Transport of
+// a provider. Request headers are never recorded, so no credential is saved.
+// A request matches an interaction by method, path and body, JSON bodies are
+// compared canonically, and each interaction is replayed once in order.
+type Cassette struct {
+	File string
+	Mode CassetteMode
+	// Transport sends the requests when recording, http.DefaultTransport if nil
+	Transport http.RoundTripper
+
+	mutex        sync.Mutex
+	interactions []*CassetteInteraction
+	used         []bool
+}
+
+// NewCassette returns a cassette on file, the interactions of file are loaded
+// in replay mode.
+func NewCassette(file string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{ File: file, Mode: mode }
+	if mode == CassetteReplay {
+		if err := c.Load(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Cassette) Load() error {
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	cf := cassetteFile{}
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return fmt.Errorf("Invalid cassette file %s: %w", c.File, err)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.interactions = cf.Interactions
+	c.used = make([]bool, len(cf.Interactions))
+	return nil
+}
+
+// Save writes the recorded interactions to File, a stream is recorded once
+// its body is read to the end or closed.
+func (c *Cassette) Save() error {
+	c.mutex.Lock()
+	cf := cassetteFile{ Interactions: c.interactions }
+	data, err := json.MarshalIndent(cf, "", "  ")
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.File, data, 0644)
+}
+
+func (c *Cassette) Interactions() []*CassetteInteraction {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*CassetteInteraction{}, c.interactions...)
+}
+
+func cassettePath(u *url.URL) string {
+	query := u.Query()
+	for _, param := range cassetteSecretParams {
+		query.Del(param)
+	}
+	path := u.EscapedPath()
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+func cassetteBody(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			return string(canonical)
+		}
+	}
+	return string(body)
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	it := &CassetteInteraction{
+		Method : req.Method,
+		Path   : cassettePath(req.URL),
+		Body   : cassetteBody(body),
+	}
+	if c.Mode == CassetteRecord {
+		return c.record(req, it)
+	}
+	return c.replay(req, it)
+}
+
+func (c *Cassette) replay(req *http.Request, it *CassetteInteraction) (*http.Response, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, rec := range c.interactions {
+		if c.used[i] || rec.Method != it.Method || rec.Path != it.Path || rec.Body != it.Body {
+			continue
+		}
+		c.used[i] = true
+		header := rec.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status        : fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+			StatusCode    : rec.Status,
+			Proto         : "HTTP/1.1",
+			ProtoMajor    : 1,
+			ProtoMinor    : 1,
+			Header        : header,
+			Body          : &cassetteReplayBody{ chunks: rec.Chunks },
+			ContentLength : -1,
+			Request       : req,
+		}, nil
+	}
+	return nil, fmt.Errorf("No cassette interaction matches %s %s!", it.Method, it.Path)
+}
+
+func (c *Cassette) record(req *http.Request, it *CassetteInteraction) (*http.Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	httpRsp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	it.Status = httpRsp.StatusCode
+	it.Header = httpRsp.Header.Clone()
+	for _, key := range cassetteSecretHeaders {
+		it.Header.Del(key)
+	}
+	it.Chunks = []string{}
+	httpRsp.Body = &cassetteRecordBody{ body: httpRsp.Body, cassette: c, it: it }
+	return httpRsp, nil
+}
+
+func (c *Cassette) add(it *CassetteInteraction) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.interactions = append(c.interactions, it)
+	c.used = append(c.used, false)
+}
+
+type cassetteRecordBody struct {
+	body     io.ReadCloser
+	cassette *Cassette
+	it       *CassetteInteraction
+	done     bool
+}
+
+func (b *cassetteRecordBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.it.Chunks = append(b.it.Chunks, string(p[:n]))
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *cassetteRecordBody) Close() error {
+	b.finish()
+	return b.body.Close()
+}
+
+func (b *cassetteRecordBody) finish() {
+	if !b.done {
+		b.done = true
+		b.cassette.add(b.it)
+	}
+}
+
+type cassetteReplayBody struct {
+	chunks []string
+	rest   string
+}
+
+func (b *cassetteReplayBody) Read(p []byte) (int, error) {
+	for len(b.rest) <= 0 {
+		if len(b.chunks) <= 0 {
+			return 0, io.EOF
+		}
+		b.rest, b.chunks = b.chunks[0], b.chunks[1:]
+	}
+	n := copy(p, b.rest)
+	b.rest = b.rest[n:]
+	return n, nil
+}
+
+func (b *cassetteReplayBody) Close() error {
+	return nil
+}
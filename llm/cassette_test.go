@@ -0,0 +1,88 @@
+package llm_test
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+type printReader struct{}
+
+func (p printReader) StreamStart() *strings.Builder {
+	return &strings.Builder{}
+}
+
+func (p printReader) StreamDelta(contentbuf *strings.Builder, delta string) {
+	fmt.Printf("[%s]", delta)
+}
+
+func (p printReader) StreamError(contentbuf *strings.Builder, status autog.LLMStatus, errstr string) {
+	fmt.Print(errstr)
+}
+
+func (p printReader) StreamEnd(contentbuf *strings.Builder) {
+	fmt.Println()
+}
+
+func ExampleCassette() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, delta := range []string{ "Hello", ", ", "world!" } {
+			fmt.Fprintf(w, `{"model":"gemma:2b","message":{"role":"assistant","content":"%s"},"done":false}`+"\n", delta)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, `{"model":"gemma:2b","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":5,"eval_count":3}`+"\n")
+	}))
+
+	dir, err := os.MkdirTemp("", "cassette")
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "chat.json")
+
+	msgs := []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Hello!" },
+	}
+
+	// Record the stream from the server
+	recorder, _ := llm.NewCassette(file, llm.CassetteRecord)
+	ollama := &llm.Ollama{ ApiBase: server.URL, Transport: recorder }
+	ollama.InitLLM()
+	ollama.SendMessagesStream(context.Background(), msgs, printReader{})
+	server.Close()
+	if err := recorder.Save(); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	it := recorder.Interactions()[0]
+	fmt.Println(it.Method, it.Path, it.Status)
+
+	// Replay it without the server
+	player, err := llm.NewCassette(file, llm.CassetteReplay)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	ollama = &llm.Ollama{ ApiBase: server.URL, Transport: player }
+	ollama.InitLLM()
+	status, msg := ollama.SendMessagesStream(context.Background(), msgs, printReader{})
+	fmt.Println(status, msg.Content, msg.Usage.TotalTokens)
+
+	// The non-stream request was not recorded
+	status, _ = ollama.SendMessages(context.Background(), msgs)
+	fmt.Println(status)
+
+	// Output:
+	// [Hello][, ][world!][]
+	// POST /api/chat 200
+	// [Hello][, ][world!][]
+	// OK Hello, world! 8
+	// BED_RESPONSE
+}
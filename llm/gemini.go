@@ -0,0 +1,696 @@
+package llm
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"errors"
+	"strings"
+	"context"
+	"net/url"
+	"net/http"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+const (
+	geminiDefaultBaseURL        = "https://generativelanguage.googleapis.com/v1beta"
+	geminiDefaultVendor         = "google"
+	geminiDefaultModel          = "gemini-1.5-pro"
+	geminiDefaultModelWeak      = "gemini-1.5-flash"
+	geminiDefaultModelEmbedding = "text-embedding-004"
+)
+
+// GeminiAPIError represents an error returned by the Gemini API
+type GeminiAPIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e GeminiAPIError) Error() string {
+	return fmt.Sprintf("[%d:%s] %s", e.Code, e.Status, e.Message)
+}
+
+type GeminiAPIErrorResponse struct {
+	Error GeminiAPIError `json:"error"`
+}
+
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileUri  string `json:"fileUri"`
+}
+
+type GeminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+// GeminiPart is one of text, inline data, file data, a function call or a
+// function response.
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiContent struct {
+	// Role is "user" or "model", empty for the system instruction
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GeminiGenerationConfig struct {
+	Temperature        *float32    `json:"temperature,omitempty"`
+	TopP               *float32    `json:"topP,omitempty"`
+	TopK               *int        `json:"topK,omitempty"`
+	MaxOutputTokens    int         `json:"maxOutputTokens,omitempty"`
+	StopSequences      []string    `json:"stopSequences,omitempty"`
+	CandidateCount     *int        `json:"candidateCount,omitempty"`
+	Seed               *int        `json:"seed,omitempty"`
+	PresencePenalty    *float32    `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   *float32    `json:"frequencyPenalty,omitempty"`
+	ResponseMimeType   string      `json:"responseMimeType,omitempty"`
+	ResponseJsonSchema interface{} `json:"responseJsonSchema,omitempty"`
+}
+
+type GeminiGenerateContentRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+type GeminiGenerateContentResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+}
+
+type GeminiEmbedContentRequest struct {
+	Model                string        `json:"model"`
+	Content              GeminiContent `json:"content"`
+	OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+}
+
+type GeminiBatchEmbedContentsRequest struct {
+	Requests []GeminiEmbedContentRequest `json:"requests"`
+}
+
+type GeminiContentEmbedding struct {
+	Values []float64 `json:"values"`
+}
+
+type GeminiBatchEmbedContentsResponse struct {
+	Embeddings []GeminiContentEmbedding `json:"embeddings"`
+}
+
+type Gemini struct {
+	ApiKey      string
+	ApiBase     string
+	ApiVendor   string
+	Model       string
+	ModelWeak   string
+	ModelEmbedding  string
+	Temperature     int
+	TemperatureWeak int
+	TimeOut       int
+	TimeOutWeak   int
+	MaxTokens     int
+	MaxTokensWeak int
+	Verbose       int
+	VerboseLog    func(log string)
+	// Retry retries failed requests, no retry if nil
+	Retry         *RetryPolicy
+	// Tokenizer counts the tokens of Model, an estimator if not set
+	Tokenizer     tokenizer.Tokenizer
+	TokenizerWeak tokenizer.Tokenizer
+	// Transport replaces the http transport of the clients, e.g. a Cassette
+	Transport     http.RoundTripper
+	// HttpClient, HttpClientWeak and HttpClientEmbed replace the clients of
+	// the main, weak and embedding calls, TimeOut and Transport are not applied
+	HttpClient      *http.Client
+	HttpClientWeak  *http.Client
+	HttpClientEmbed *http.Client
+	// TLS configures the default transport, certificates are verified by default,
+	// InitLLM fails when Transport is set too
+	TLS           *TLSOptions
+	// Headers are added to every request, e.g. for gateway auth
+	Headers       map[string]string
+
+	modelInfo     autog.ModelInfo
+	modelInfoWeak autog.ModelInfo
+	httpClients
+}
+
+func (gpt *Gemini) InitLLM() error {
+	if len(gpt.ApiKey) <= 0 {
+		return fmt.Errorf("API Key is needed!")
+	}
+	if len(gpt.ApiBase) <= 0 {
+		gpt.ApiBase = geminiDefaultBaseURL
+	}
+	if len(gpt.ApiVendor) <= 0 {
+		gpt.ApiVendor = geminiDefaultVendor
+	}
+	if len(gpt.Model) <= 0 {
+		gpt.Model = geminiDefaultModel
+	}
+	if len(gpt.ModelWeak) <= 0 {
+		gpt.ModelWeak = geminiDefaultModelWeak
+	}
+	if len(gpt.ModelEmbedding) <= 0 {
+		gpt.ModelEmbedding = geminiDefaultModelEmbedding
+	}
+	gpt.modelInfo, _     = autog.LookupModelInfo(gpt.Model)
+	gpt.modelInfoWeak, _ = autog.LookupModelInfo(gpt.ModelWeak)
+	if gpt.Tokenizer == nil {
+		gpt.Tokenizer = modelTokenizer(gpt.modelInfo)
+	}
+	if gpt.TokenizerWeak == nil {
+		gpt.TokenizerWeak = modelTokenizer(gpt.modelInfoWeak)
+	}
+
+	return gpt.initHttpClients(gpt.Transport, gpt.TLS, &gpt.TimeOut, &gpt.TimeOutWeak,
+		gpt.HttpClient, gpt.HttpClientWeak, gpt.HttpClientEmbed)
+}
+
+// ConvertFunctionResponse wraps the result of a tool, the response must be a
+// JSON object.
+func (gpt *Gemini) ConvertFunctionResponse(result string) json.RawMessage {
+	trimmed := strings.TrimSpace(result)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	raw, _ := json.Marshal(map[string]string{ "result": result })
+	return json.RawMessage(raw)
+}
+
+func (gpt *Gemini) ConvertParts(msg autog.ChatMessage, names map[string]string) ([]GeminiPart, error) {
+	if msg.Role == autog.ROLE_TOOL {
+		name := msg.Name
+		if len(name) <= 0 {
+			name = names[msg.ToolCallId]
+		}
+		return []GeminiPart{
+			{ FunctionResponse: &GeminiFunctionResponse{ ID: msg.ToolCallId, Name: name, Response: gpt.ConvertFunctionResponse(msg.Text()) } },
+		}, nil
+	}
+	var parts []GeminiPart
+	if len(msg.Content) > 0 {
+		parts = append(parts, GeminiPart{ Text: msg.Content })
+	}
+	for _, part := range msg.Parts {
+		switch part.Type {
+		case autog.PART_TEXT:
+			parts = append(parts, GeminiPart{ Text: part.Text })
+		case autog.PART_IMAGE:
+			if strings.HasPrefix(part.ImageUrl, "http://") || strings.HasPrefix(part.ImageUrl, "https://") ||
+				strings.HasPrefix(part.ImageUrl, "gs://") {
+				parts = append(parts, GeminiPart{ FileData: &GeminiFileData{ MimeType: part.ImageMime, FileUri: part.ImageUrl } })
+				continue
+			}
+			mimetype, data, err := part.LoadImage()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, GeminiPart{ InlineData: &GeminiBlob{ MimeType: mimetype, Data: data } })
+		default:
+			return nil, fmt.Errorf("Content part type %s is not supported!", part.Type)
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		args := json.RawMessage(call.Arguments)
+		if !json.Valid(args) {
+			args = json.RawMessage("{}")
+		}
+		parts = append(parts, GeminiPart{ FunctionCall: &GeminiFunctionCall{ ID: call.Id, Name: call.Name, Args: args } })
+	}
+	return parts, nil
+}
+
+// ConvertMessages returns the system instruction and the contents, tool
+// results are sent as user contents answering the function call by name.
+func (gpt *Gemini) ConvertMessages(msgs []autog.ChatMessage) (*GeminiContent, []GeminiContent, error) {
+	var system *GeminiContent
+	var contents []GeminiContent
+	names := map[string]string{}
+	for _, msg := range msgs {
+		for _, call := range msg.ToolCalls {
+			names[call.Id] = call.Name
+		}
+		if msg.Role == autog.ROLE_SYSTEM {
+			if text := msg.Text(); len(text) > 0 {
+				if system == nil {
+					system = &GeminiContent{}
+				}
+				system.Parts = append(system.Parts, GeminiPart{ Text: text })
+			}
+			continue
+		}
+		role := "user"
+		if msg.Role == autog.ROLE_ASSISTANT {
+			role = "model"
+		}
+		parts, err := gpt.ConvertParts(msg, names)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(parts) <= 0 {
+			continue
+		}
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			continue
+		}
+		contents = append(contents, GeminiContent{ Role: role, Parts: parts })
+	}
+	return system, contents, nil
+}
+
+func (gpt *Gemini) ConvertTools(tools []autog.Tool) []GeminiTool {
+	if len(tools) <= 0 {
+		return nil
+	}
+	decls := make([]GeminiFunctionDeclaration, len(tools))
+	for i, tool := range tools {
+		decls[i] = GeminiFunctionDeclaration{ Name: tool.Name, Description: tool.Desc, Parameters: tool.Parameters }
+	}
+	return []GeminiTool{ { FunctionDeclarations: decls } }
+}
+
+// ConvertResponse returns the text and function calls of the first
+// candidate, a function call without id is given one by its position.
+func (gpt *Gemini) ConvertResponse(response *GeminiGenerateContentResponse, ncalls int) (string, []autog.ToolCall) {
+	if len(response.Candidates) <= 0 {
+		return "", nil
+	}
+	buf := strings.Builder{}
+	var calls []autog.ToolCall
+	for _, part := range response.Candidates[0].Content.Parts {
+		if part.FunctionCall != nil {
+			id := part.FunctionCall.ID
+			if len(id) <= 0 {
+				id = fmt.Sprintf("call_%d", ncalls + len(calls))
+			}
+			args := string(part.FunctionCall.Args)
+			if len(args) <= 0 {
+				args = "{}"
+			}
+			calls = append(calls, autog.ToolCall{ Id: id, Name: part.FunctionCall.Name, Arguments: args })
+			continue
+		}
+		buf.WriteString(part.Text)
+	}
+	return buf.String(), calls
+}
+
+func (gpt *Gemini) ConvertUsage(model string, kind autog.UsageKind, usage *GeminiUsageMetadata) autog.Usage {
+	result := autog.Usage{ Model: model, Kind: kind }
+	if usage != nil {
+		result.PromptTokens     = usage.PromptTokenCount
+		result.CompletionTokens = usage.CandidatesTokenCount
+		result.TotalTokens      = usage.TotalTokenCount
+	}
+	return result
+}
+
+// ErrorStatus maps an error of a call to a status, a prompt over the token
+// limit of the model reports LLM_STATUS_EXCEED_CONTEXT.
+func (gpt *Gemini) ErrorStatus(err error) autog.LLMStatus {
+	var apiErr GeminiAPIError
+	if !errors.As(err, &apiErr) {
+		return autog.LLM_STATUS_BED_RESPONSE
+	}
+	if apiErr.Status == "INVALID_ARGUMENT" {
+		if strings.Contains(strings.ToLower(apiErr.Message), "token") {
+			return autog.LLM_STATUS_EXCEED_CONTEXT
+		}
+		return autog.LLM_STATUS_BED_REQUEST
+	}
+	switch apiErr.Status {
+	case "UNAUTHENTICATED", "PERMISSION_DENIED", "NOT_FOUND", "FAILED_PRECONDITION":
+		return autog.LLM_STATUS_BED_REQUEST
+	}
+	return autog.LLM_STATUS_BED_RESPONSE
+}
+
+func (gpt *Gemini) ConvertResponseFormat(config *GeminiGenerationConfig, format *autog.ResponseFormat) {
+	if format == nil {
+		return
+	}
+	switch format.Type {
+	case autog.RESPONSE_FORMAT_JSON:
+		config.ResponseMimeType = "application/json"
+	case autog.RESPONSE_FORMAT_JSON_SCHEMA:
+		config.ResponseMimeType   = "application/json"
+		config.ResponseJsonSchema = format.Schema
+	}
+}
+
+// ApplyGenerationOptions sets the fields of config given in opts, other
+// options are not supported and ignored.
+func (gpt *Gemini) ApplyGenerationOptions(config *GeminiGenerationConfig, opts *autog.GenerationOptions) {
+	if opts == nil {
+		return
+	}
+	if opts.Temperature != nil {
+		config.Temperature = opts.Temperature
+	}
+	if opts.TopP != nil {
+		config.TopP = opts.TopP
+	}
+	if opts.TopK != nil {
+		config.TopK = opts.TopK
+	}
+	if opts.MaxTokens != nil {
+		config.MaxOutputTokens = *opts.MaxTokens
+	}
+	if opts.Stop != nil {
+		config.StopSequences = opts.Stop
+	}
+	if opts.Seed != nil {
+		config.Seed = opts.Seed
+	}
+	if opts.N != nil {
+		config.CandidateCount = opts.N
+	}
+	if opts.PresencePenalty != nil {
+		config.PresencePenalty = opts.PresencePenalty
+	}
+	if opts.FrequencyPenalty != nil {
+		config.FrequencyPenalty = opts.FrequencyPenalty
+	}
+}
+
+func (gpt *Gemini) CreateGenerateContentRequest(msgs []autog.ChatMessage, ropts ChatRequestOptions) (string, *GeminiGenerateContentRequest, error) {
+	model       := gpt.Model
+	temperature := gpt.Temperature
+	maxtokens   := gpt.MaxTokens
+
+	if ropts.Weak {
+		model       = gpt.ModelWeak
+		temperature = gpt.TemperatureWeak
+		maxtokens   = gpt.MaxTokensWeak
+	}
+
+	system, contents, err := gpt.ConvertMessages(msgs)
+	if err != nil {
+		return model, nil, err
+	}
+
+	config := &GeminiGenerationConfig{}
+	if temperature > 0 {
+		config.Temperature = autog.Ptr(float32(temperature) / float32(100))
+	}
+	if maxtokens > 0 {
+		config.MaxOutputTokens = maxtokens
+	}
+	gpt.ConvertResponseFormat(config, ropts.Format)
+	gpt.ApplyGenerationOptions(config, ropts.Options)
+
+	request := &GeminiGenerateContentRequest{
+		Contents          : contents,
+		SystemInstruction : system,
+		Tools             : gpt.ConvertTools(ropts.Tools),
+		GenerationConfig  : config,
+	}
+	return model, request, nil
+}
+
+// ModelPath returns the path of a method of model, e.g. /models/gemini-1.5-pro:generateContent
+func (gpt *Gemini) ModelPath(model, method string) string {
+	model = strings.TrimPrefix(model, "models/")
+	return "/models/" + url.PathEscape(model) + ":" + method
+}
+
+func (gpt *Gemini) CreateHttpRequest(cxt context.Context, method, path string, payload interface{}) (*http.Request, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	url := gpt.ApiBase + path
+	req, err := http.NewRequestWithContext(cxt, method, url, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-type", "application/json")
+	req.Header.Set("x-goog-api-key", gpt.ApiKey)
+	setHttpHeaders(req, gpt.Headers)
+	return req, nil
+}
+
+func (gpt *Gemini) CheckHttpResponseSuccess(httpRsp *http.Response) error {
+	return checkHttpResponse(httpRsp, func (code int, data []byte) error {
+		var result GeminiAPIErrorResponse
+		if err := json.Unmarshal(data, &result); err != nil || len(result.Error.Message) <= 0 {
+			return GeminiAPIError{
+				Code:    code,
+				Status:  "Unexpected",
+				Message: string(data),
+			}
+		}
+		result.Error.Code = code
+		return result.Error
+	})
+}
+
+func (gpt *Gemini) SendMessagesInner(cxt context.Context, msgs []autog.ChatMessage, weak bool) (autog.LLMStatus, autog.ChatMessage) {
+	model, request, err := gpt.CreateGenerateContentRequest(msgs, chatRequestOptions(cxt, weak, false))
+	if err != nil {
+		return requestError(nil, err)
+	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", gpt.ModelPath(model, "generateContent"), request)
+	if err != nil {
+		return requestError(nil, err)
+	}
+	httpRsp, err := getHttpResponse(gpt.chatClient(weak), httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if err != nil {
+		return streamError(nil, nil, gpt.ErrorStatus(err), err)
+	}
+	response := GeminiGenerateContentResponse{}
+	if err := getHttpBodyObject(httpRsp, &response); err != nil {
+		return streamError(nil, nil, autog.LLM_STATUS_BED_MESSAGE, err)
+	}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	if len(response.Candidates) <= 0 {
+		return streamError(nil, nil, autog.LLM_STATUS_BED_MESSAGE, fmt.Errorf("No candidate in response!"))
+	}
+
+	usage := gpt.ConvertUsage(model, autog.UsageKindChat, response.UsageMetadata)
+	autog.RecordUsage(cxt, usage)
+
+	content, toolcalls := gpt.ConvertResponse(&response, 0)
+	revMsg := autog.ChatMessage{
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : content,
+		ToolCalls : toolcalls,
+		Usage     : &usage,
+	}
+
+	return autog.LLM_STATUS_OK, revMsg
+}
+
+func (gpt *Gemini) SendMessagesStreamInner(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader, weak bool) (autog.LLMStatus, autog.ChatMessage) {
+	model, request, err := gpt.CreateGenerateContentRequest(msgs, chatRequestOptions(cxt, weak, true))
+	if err != nil {
+		return requestError(reader, err)
+	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	contentbuf := startStream(reader)
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", gpt.ModelPath(model, "streamGenerateContent") + "?alt=sse", request)
+	if err != nil {
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_REQUEST, err)
+	}
+	httpRsp, err := getHttpResponse(gpt.chatClient(weak), httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if err != nil {
+		return streamError(reader, contentbuf, gpt.ErrorStatus(err), err)
+	}
+	defer httpRsp.Body.Close()
+
+	var usage *GeminiUsageMetadata
+	var toolcalls []autog.ToolCall
+	candidates := false
+	readErr := readServerEvents(httpRsp.Body, func (data []byte) (bool, error) {
+		response := GeminiGenerateContentResponse{}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return false, fmt.Errorf("Invalid json stream data: %v", err)
+		}
+		if response.UsageMetadata != nil {
+			usage = response.UsageMetadata
+		}
+		candidates = candidates || len(response.Candidates) > 0
+
+		delta, calls := gpt.ConvertResponse(&response, len(toolcalls))
+		toolcalls = append(toolcalls, calls...)
+		if len(delta) > 0 {
+			contentbuf.WriteString(delta)
+			if reader != nil {
+				reader.StreamDelta(contentbuf, delta)
+			}
+		}
+		return false, nil
+	})
+	// The stream of server sent events ends with the body
+	if readErr != nil && readErr != io.EOF {
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_MESSAGE, readErr)
+	}
+	// e.g. a blocked prompt gets only the prompt feedback
+	if !candidates {
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_MESSAGE, fmt.Errorf("No candidate in response!"))
+	}
+	if reader != nil {
+		reader.StreamEnd(contentbuf)
+	}
+
+	revUsage := gpt.ConvertUsage(model, autog.UsageKindChat, usage)
+	autog.RecordUsage(cxt, revUsage)
+
+	revMsg := autog.ChatMessage{
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : contentbuf.String(),
+		ToolCalls : toolcalls,
+		Usage     : &revUsage,
+	}
+
+	return autog.LLM_STATUS_OK, revMsg
+}
+
+func (gpt *Gemini) CalcTokens(cxt context.Context, content string) int {
+	if gpt.Tokenizer == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.Tokenizer.Count(content)
+}
+
+func (gpt *Gemini) SendMessages(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.SendMessagesInner(cxt, msgs, false)
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
+	return status, msg
+}
+
+func (gpt *Gemini) SendMessagesStream(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.SendMessagesStreamInner(cxt, msgs, reader, false)
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
+	return status, msg
+}
+
+func (gpt *Gemini) CalcTokensByWeakModel(cxt context.Context, content string) int {
+	if gpt.TokenizerWeak == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.TokenizerWeak.Count(content)
+}
+
+func (gpt *Gemini) SendMessagesByWeakModel(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesInner(cxt, msgs, true)
+}
+
+func (gpt *Gemini) SendMessagesStreamByWeakModel(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesStreamInner(cxt, msgs, reader, true)
+}
+
+// Embeddings embeds texts in one batchEmbedContents call, the API reports no
+// usage so the tokens of texts are estimated.
+func (gpt *Gemini) Embeddings(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
+	var embeds []autog.Embedding
+	model := "models/" + strings.TrimPrefix(gpt.ModelEmbedding, "models/")
+	request := GeminiBatchEmbedContentsRequest{}
+	for _, text := range texts {
+		embeddingReq := GeminiEmbedContentRequest{
+			Model   : model,
+			Content : GeminiContent{ Parts: []GeminiPart{ { Text: text } } },
+		}
+		if dimensions > 0 {
+			embeddingReq.OutputDimensionality = dimensions
+		}
+		request.Requests = append(request.Requests, embeddingReq)
+	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	httpReq, cerr := gpt.CreateHttpRequest(cxt, "POST", gpt.ModelPath(gpt.ModelEmbedding, "batchEmbedContents"), request)
+	if cerr != nil {
+		return embeds, cerr
+	}
+
+	httpRsp, gerr := getHttpResponse(gpt.httpEmbed, httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if gerr != nil {
+		return embeds, gerr
+	}
+
+	response := GeminiBatchEmbedContentsResponse{}
+	if err := getHttpBodyObject(httpRsp, &response); err != nil {
+		return embeds, err
+	}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	if len(response.Embeddings) != len(texts) {
+		return embeds, fmt.Errorf("Got %d embeddings for %d texts!", len(response.Embeddings), len(texts))
+	}
+
+	tokens := 0
+	for _, text := range texts {
+		tokens += tokenizer.Estimator{}.Count(text)
+	}
+	autog.RecordUsage(cxt, autog.Usage{
+		Model        : gpt.ModelEmbedding,
+		Kind         : autog.UsageKindEmbedding,
+		PromptTokens : tokens,
+		TotalTokens  : tokens,
+	})
+
+	for _, e := range response.Embeddings {
+		embeds = append(embeds, autog.Embedding(e.Values))
+	}
+
+	return embeds, nil
+}
+
+func (gpt *Gemini) ModelInfo() autog.ModelInfo {
+	return gpt.modelInfo
+}
+
+func (gpt *Gemini) ModelInfoByWeakModel() autog.ModelInfo {
+	return gpt.modelInfoWeak
+}
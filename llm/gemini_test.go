@@ -0,0 +1,122 @@
+package llm_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"context"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+const geminiStream = `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]},"index":0}]}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"text":", world!"}]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":9,"candidatesTokenCount":4,"totalTokenCount":13}}
+
+`
+
+func ExampleGemini() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.URL.Path, r.URL.RawQuery, r.Header.Get("x-goog-api-key"))
+		if r.URL.Path == "/models/text-embedding-004:batchEmbedContents" {
+			var request llm.GeminiBatchEmbedContentsRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			response := llm.GeminiBatchEmbedContentsResponse{}
+			for _, req := range request.Requests {
+				values := make([]float64, req.OutputDimensionality)
+				values[0] = 1
+				response.Embeddings = append(response.Embeddings, llm.GeminiContentEmbedding{ Values: values })
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		var request llm.GeminiGenerateContentRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Println(request.SystemInstruction.Parts[0].Text)
+		for _, content := range request.Contents {
+			fmt.Println(content.Role, len(content.Parts))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, geminiStream)
+	}))
+	defer server.Close()
+
+	gemini := &llm.Gemini{ ApiBase: server.URL, ApiKey: "test" }
+	err := gemini.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	msgs := []autog.ChatMessage{
+		{ Role: autog.ROLE_SYSTEM, Content: "You are a friendly bot." },
+		{ Role: autog.ROLE_USER, Content: "Hi!" },
+		{ Role: autog.ROLE_ASSISTANT, Content: "Hi, how can I help?" },
+		{ Role: autog.ROLE_USER, Content: "Say hello." },
+	}
+	status, msg := gemini.SendMessagesStream(context.Background(), msgs, printReader{})
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content, msg.Usage.TotalTokens)
+
+	embeds, err := gemini.Embeddings(context.Background(), 8, []string{ "hello", "world" })
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	fmt.Println(len(embeds), len(embeds[0]))
+
+	// Output:
+	// /models/gemini-1.5-pro:streamGenerateContent alt=sse test
+	// You are a friendly bot.
+	// user 1
+	// model 1
+	// user 1
+	// [Hello][, world!]
+	// true Hello, world! 13
+	// /models/text-embedding-004:batchEmbedContents  test
+	// 2 8
+}
+
+func ExampleGemini_toolCalls() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request llm.GeminiGenerateContentRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		for _, content := range request.Contents {
+			for _, part := range content.Parts {
+				if part.FunctionCall != nil {
+					fmt.Println(content.Role, part.FunctionCall.ID, part.FunctionCall.Name)
+				}
+				if part.FunctionResponse != nil {
+					fmt.Println(content.Role, part.FunctionResponse.ID, part.FunctionResponse.Name)
+				}
+			}
+		}
+		// A blocked prompt gets no candidate
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"promptFeedback\":{\"blockReason\":\"SAFETY\"}}\n\n")
+	}))
+	defer server.Close()
+
+	gemini := &llm.Gemini{ ApiBase: server.URL, ApiKey: "test" }
+	gemini.InitLLM()
+
+	// Parallel calls of one function are told apart by their ids
+	msgs := []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Weather of Paris and Rome?" },
+		{ Role: autog.ROLE_ASSISTANT, ToolCalls: []autog.ToolCall{
+			{ Id: "call_paris", Name: "get_weather", Arguments: `{"city":"Paris"}` },
+			{ Id: "call_rome", Name: "get_weather", Arguments: `{"city":"Rome"}` },
+		} },
+		{ Role: autog.ROLE_TOOL, ToolCallId: "call_paris", Content: "Sunny" },
+		{ Role: autog.ROLE_TOOL, ToolCallId: "call_rome", Content: "Rainy" },
+	}
+	status, msg := gemini.SendMessagesStream(context.Background(), msgs, nil)
+	fmt.Println(status, msg.Err)
+
+	// Output:
+	// model call_paris get_weather
+	// model call_rome get_weather
+	// user call_paris get_weather
+	// user call_rome get_weather
+	// BED_MESSAGE No candidate in response!
+}
@@ -0,0 +1,263 @@
+package llm
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"time"
+	"bytes"
+	"bufio"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/autogorg/autog"
+)
+
+// TLSOptions configures the TLS of the default transport of a provider,
+// certificates are verified against the system roots unless RootCAs or
+// RootCAFile are given.
+type TLSOptions struct {
+	// RootCAFile is a PEM file of the certificate authorities to trust
+	RootCAFile string
+	RootCAs    *x509.CertPool
+	// CertFile and KeyFile are the PEM client certificate and key for mTLS
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	// InsecureSkipVerify disables the verification, for local testing only
+	InsecureSkipVerify bool
+}
+
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{ MinVersion: tls.VersionTLS12 }
+	if o == nil {
+		return config, nil
+	}
+	config.ServerName = o.ServerName
+	config.InsecureSkipVerify = o.InsecureSkipVerify
+	config.RootCAs = o.RootCAs
+	if len(o.RootCAFile) > 0 {
+		pem, err := os.ReadFile(o.RootCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read root CA file: %w", err)
+		}
+		if config.RootCAs == nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in root CA file %s!", o.RootCAFile)
+		}
+	}
+	if len(o.CertFile) > 0 || len(o.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{ cert }
+	}
+	return config, nil
+}
+
+// NewHttpTransport returns a transport using the proxy from the environment
+// and the TLS configured by opts.
+func NewHttpTransport(opts *TLSOptions) (*http.Transport, error) {
+	config, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+// newHttpClients returns the clients of the main, weak and embedding calls,
+// a client given by the user is used as is. The TLS options only apply to
+// the default transport, so they fail with a transport of the user.
+func newHttpClients(transport http.RoundTripper, opts *TLSOptions, timeout, timeoutWeak int, clients ...*http.Client) ([]*http.Client, error) {
+	if transport != nil && opts != nil {
+		return nil, fmt.Errorf("TLS options are not applied to a given Transport, configure its TLS instead!")
+	}
+	timeouts := []int{ timeout, timeoutWeak, timeoutWeak }
+	result := make([]*http.Client, len(timeouts))
+	for i := range timeouts {
+		if i < len(clients) && clients[i] != nil {
+			result[i] = clients[i]
+			continue
+		}
+		if transport == nil {
+			t, err := NewHttpTransport(opts)
+			if err != nil {
+				return nil, err
+			}
+			transport = t
+		}
+		result[i] = &http.Client{
+			Timeout: time.Duration(timeouts[i]) * time.Second,
+			Transport: transport,
+		}
+	}
+	return result, nil
+}
+
+const defaultHttpTimeOut = 300
+
+// httpClients are the clients of the main, weak and embedding calls of a
+// provider, set by initHttpClients in InitLLM.
+type httpClients struct {
+	httpMain  *http.Client
+	httpWeak  *http.Client
+	httpEmbed *http.Client
+}
+
+// initHttpClients defaults the timeouts and sets the clients by newHttpClients
+func (c *httpClients) initHttpClients(transport http.RoundTripper, opts *TLSOptions, timeout, timeoutWeak *int, clients ...*http.Client) error {
+	if *timeout <= 0 {
+		*timeout = defaultHttpTimeOut
+	}
+	if *timeoutWeak <= 0 {
+		*timeoutWeak = defaultHttpTimeOut
+	}
+	result, err := newHttpClients(transport, opts, *timeout, *timeoutWeak, clients...)
+	if err != nil {
+		return err
+	}
+	c.httpMain  = result[0]
+	c.httpWeak  = result[1]
+	c.httpEmbed = result[2]
+	return nil
+}
+
+func (c *httpClients) chatClient(weak bool) *http.Client {
+	if weak {
+		return c.httpWeak
+	}
+	return c.httpMain
+}
+
+func setHttpHeaders(req *http.Request, headers map[string]string) {
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// checkHttpResponse returns nil for a successful response, else the error
+// decode returns for the status code and the body of the response.
+func checkHttpResponse(httpRsp *http.Response, decode func (code int, data []byte) error) error {
+	if httpRsp.StatusCode >= 200 && httpRsp.StatusCode < 300 {
+		return nil
+	}
+	defer httpRsp.Body.Close()
+	data, err := io.ReadAll(httpRsp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read from body: %w", err)
+	}
+	return decode(httpRsp.StatusCode, data)
+}
+
+// getHttpResponse sends httpReq by retry, a failed response is the error
+// returned by check.
+func getHttpResponse(httpClient *http.Client, httpReq *http.Request, retry *RetryPolicy, check func (*http.Response) error) (*http.Response, error) {
+	httpRsp, err := DoHttpRequest(httpClient, httpReq, retry)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(httpRsp); err != nil {
+		return nil, err
+	}
+	return httpRsp, nil
+}
+
+// getHttpBodyObject decodes the json body of httpRsp into v and closes it
+func getHttpBodyObject(httpRsp *http.Response, v interface{}) error {
+	defer httpRsp.Body.Close()
+	if err := json.NewDecoder(httpRsp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Invalid json response: %w", err)
+	}
+	return nil
+}
+
+// readServerEvents calls handle with the data of each server sent event of
+// body until handle is done or fails, io.EOF if the body ends before.
+func readServerEvents(body io.Reader, handle func (data []byte) (bool, error)) error {
+	bufreader := bufio.NewReader(body)
+	for {
+		line, err := bufreader.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimSpace(line)
+		if !bytes.HasPrefix(line, dataPrefix) {
+			continue
+		}
+		done, err := handle(bytes.TrimPrefix(line, dataPrefix))
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// logSending logs the request sent when verbose is at least VerboseShowSending
+func logSending(verbose int, log func (string), request interface{}) {
+	if verbose < autog.VerboseShowSending || log == nil {
+		return
+	}
+	if reqstr, err := json.Marshal(request); err == nil {
+		log(fmt.Sprintf("SEND_TO_LLVM:\n %s \n", reqstr))
+	}
+}
+
+// logReceiving logs the response received when verbose is at least
+// VerboseShowReceiving
+func logReceiving(verbose int, log func (string), response interface{}) {
+	if verbose < autog.VerboseShowReceiving || log == nil {
+		return
+	}
+	if repstr, err := json.Marshal(response); err == nil {
+		log(fmt.Sprintf("RECV_FROM_LLVM:\n %s \n", repstr))
+	}
+}
+
+// logReceived logs the message of a call and its status
+func logReceived(verbose int, log func (string), status autog.LLMStatus, msg autog.ChatMessage) {
+	if verbose < autog.VerboseShowReceiving || log == nil {
+		return
+	}
+	if msgstr, err := json.Marshal(msg); err == nil {
+		log(fmt.Sprintf("RECEIVE_FROM_LLVM STATUS(%d)\n %s \n", status, msgstr))
+	}
+}
+
+// startStream returns the content buffer of reader, a new one if reader has none
+func startStream(reader autog.StreamReader) *strings.Builder {
+	var contentbuf *strings.Builder
+	if reader != nil {
+		contentbuf = reader.StreamStart()
+	}
+	if contentbuf == nil {
+		contentbuf = &strings.Builder{}
+	}
+	return contentbuf
+}
+
+// streamError fails a call by status and err, the reader receives the error
+// and the end of the stream.
+func streamError(reader autog.StreamReader, contentbuf *strings.Builder, status autog.LLMStatus, err error) (autog.LLMStatus, autog.ChatMessage) {
+	if reader != nil {
+		reader.StreamError(contentbuf, status, err.Error())
+		reader.StreamEnd(contentbuf)
+	}
+	return status, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error(), Err: err}
+}
+
+// requestError fails a call whose request can not be built, the reader
+// receives the error like any failed stream.
+func requestError(reader autog.StreamReader, err error) (autog.LLMStatus, autog.ChatMessage) {
+	var contentbuf *strings.Builder
+	if reader != nil {
+		contentbuf = startStream(reader)
+	}
+	return streamError(reader, contentbuf, autog.LLM_STATUS_BED_REQUEST, err)
+}
@@ -0,0 +1,52 @@
+package llm_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"crypto/x509"
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleTLSOptions() {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"model":"gemma:2b","message":{"role":"assistant","content":"%s"},"done":true}`, r.Header.Get("X-Gateway-Key"))
+	}))
+	defer server.Close()
+
+	msgs := []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Hello!" },
+	}
+
+	// The self-signed certificate of the server is rejected by default
+	ollama := &llm.Ollama{ ApiBase: server.URL }
+	ollama.InitLLM()
+	status, _ := ollama.SendMessages(context.Background(), msgs)
+	fmt.Println(status)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	ollama = &llm.Ollama{
+		ApiBase: server.URL,
+		TLS: &llm.TLSOptions{ RootCAs: roots },
+		Headers: map[string]string{ "X-Gateway-Key": "secret" },
+	}
+	ollama.InitLLM()
+	status, msg := ollama.SendMessages(context.Background(), msgs)
+	fmt.Println(status, msg.Content)
+
+	// TLS is not applied to a given transport
+	ollama = &llm.Ollama{
+		ApiBase: server.URL,
+		Transport: http.DefaultTransport,
+		TLS: &llm.TLSOptions{ RootCAs: roots },
+	}
+	fmt.Println(ollama.InitLLM())
+
+	// Output:
+	// BED_RESPONSE
+	// OK secret
+	// TLS options are not applied to a given Transport, configure its TLS instead!
+}
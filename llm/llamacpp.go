@@ -0,0 +1,690 @@
+package llm
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"errors"
+	"strings"
+	"context"
+	"sync"
+	"net/http"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+const (
+	llamacppDefaultBaseURL        = "http://localhost:8080"
+	llamacppDefaultVendor         = "llamacpp"
+	llamacppDefaultModel          = "default"
+	llamacppDefaultModelWeak      = "default"
+	llamacppDefaultModelEmbedding = "default"
+	// llamacppNoApiKey is sent when ApiKey is empty, the server ignores it
+	// unless started with --api-key
+	llamacppNoApiKey              = "no-key"
+)
+
+// LlamaCppAPIError represents an error returned by the llama.cpp server
+type LlamaCppAPIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func (e LlamaCppAPIError) Error() string {
+	return fmt.Sprintf("[%d:%s] %s", e.Code, e.Type, e.Message)
+}
+
+type LlamaCppAPIErrorResponse struct {
+	Error LlamaCppAPIError `json:"error"`
+}
+
+type LlamaCppTokenizeRequest struct {
+	Content    string `json:"content"`
+	AddSpecial bool   `json:"add_special"`
+}
+
+type LlamaCppTokenizeResponse struct {
+	Tokens []int `json:"tokens"`
+}
+
+// LlamaCppCompletionRequest is the request of the native /completion
+// endpoint, which completes a raw prompt without a chat template.
+type LlamaCppCompletionRequest struct {
+	Prompt           string      `json:"prompt"`
+	// NPredict is the maximum number of tokens to predict, -1 for no limit
+	NPredict         int         `json:"n_predict,omitempty"`
+	Temperature      *float32    `json:"temperature,omitempty"`
+	TopK             *int        `json:"top_k,omitempty"`
+	TopP             *float32    `json:"top_p,omitempty"`
+	Stop             []string    `json:"stop,omitempty"`
+	Seed             *int        `json:"seed,omitempty"`
+	PresencePenalty  *float32    `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32    `json:"frequency_penalty,omitempty"`
+	// Grammar is a GBNF grammar constraining the output
+	Grammar          string      `json:"grammar,omitempty"`
+	// JsonSchema constrains the output to a JSON schema, {} for any JSON
+	JsonSchema       interface{} `json:"json_schema,omitempty"`
+	Stream           bool        `json:"stream,omitempty"`
+	CachePrompt      bool        `json:"cache_prompt,omitempty"`
+}
+
+type LlamaCppCompletionResponse struct {
+	Content         string `json:"content"`
+	Model           string `json:"model,omitempty"`
+	// Stop is true in the last chunk of a stream
+	Stop            bool   `json:"stop"`
+	StoppedLimit    bool   `json:"stopped_limit,omitempty"`
+	TokensPredicted int    `json:"tokens_predicted,omitempty"`
+	TokensEvaluated int    `json:"tokens_evaluated,omitempty"`
+}
+
+type LlamaCppGenerationSettings struct {
+	NCtx int `json:"n_ctx"`
+}
+
+// LlamaCppProps is the response of /props, the settings of the server
+type LlamaCppProps struct {
+	DefaultGenerationSettings LlamaCppGenerationSettings `json:"default_generation_settings"`
+	ModelPath                 string                     `json:"model_path,omitempty"`
+}
+
+type LlamaCppEmbeddingRequest struct {
+	// Content is a string or a list of strings
+	Content interface{} `json:"content"`
+}
+
+// LlamaCppEmbedding is one embedding of an /embedding response, Embedding is
+// a vector, or a list of vectors when the server does no pooling.
+type LlamaCppEmbedding struct {
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"`
+}
+
+// LlamaCpp talks to the HTTP server of llama.cpp, chats go to its OpenAI
+// compatible /v1/chat/completions, tokens are counted by its /tokenize and
+// raw prompts are completed by /completion. The server runs a single model,
+// so the weak model and the embedding model may be served by other servers.
+type LlamaCpp struct {
+	ApiKey      string
+	ApiBase     string
+	// ApiBaseWeak and ApiBaseEmbedding are the servers of the weak and the
+	// embedding model, ApiBase if not set
+	ApiBaseWeak      string
+	ApiBaseEmbedding string
+	ApiVendor   string
+	Model       string
+	ModelWeak   string
+	ModelEmbedding  string
+	Temperature     int
+	TemperatureWeak int
+	TimeOut       int
+	TimeOutWeak   int
+	MaxTokens     int
+	MaxTokensWeak int
+	Verbose       int
+	VerboseLog    func(log string)
+	// DisableStreamUsage stops asking for usage in streams, for old servers
+	DisableStreamUsage bool
+	// Retry retries failed requests, no retry if nil
+	Retry         *RetryPolicy
+	// Tokenizer and TokenizerWeak count tokens when /tokenize fails, an
+	// estimator if not set
+	Tokenizer     tokenizer.Tokenizer
+	TokenizerWeak tokenizer.Tokenizer
+	// Transport replaces the http transport of the clients, e.g. a Cassette
+	Transport     http.RoundTripper
+	// HttpClient, HttpClientWeak and HttpClientEmbed replace the clients of
+	// the main, weak and embedding calls, TimeOut and Transport are not applied
+	HttpClient      *http.Client
+	HttpClientWeak  *http.Client
+	HttpClientEmbed *http.Client
+	// TLS configures the default transport, certificates are verified by default,
+	// InitLLM fails when Transport is set too
+	TLS           *TLSOptions
+	// Headers are added to every request, e.g. for gateway auth
+	Headers       map[string]string
+	// DiscoverModels asks the servers for their context size in InitLLM
+	DiscoverModels bool
+
+	chat      *OpenAi
+	chatWeak  *OpenAi
+	mutex     sync.Mutex
+	// tokenizeFailed are the servers whose /tokenize failed, they are not
+	// asked again until InitLLM
+	tokenizeFailed map[string]bool
+	modelInfo     autog.ModelInfo
+	modelInfoWeak autog.ModelInfo
+	httpClients
+}
+
+func (gpt *LlamaCpp) InitLLM() error {
+	if len(gpt.ApiBase) <= 0 {
+		gpt.ApiBase = llamacppDefaultBaseURL
+	}
+	gpt.ApiBase = strings.TrimSuffix(gpt.ApiBase, "/")
+	if len(gpt.ApiBaseWeak) <= 0 {
+		gpt.ApiBaseWeak = gpt.ApiBase
+	}
+	gpt.ApiBaseWeak = strings.TrimSuffix(gpt.ApiBaseWeak, "/")
+	if len(gpt.ApiBaseEmbedding) <= 0 {
+		gpt.ApiBaseEmbedding = gpt.ApiBase
+	}
+	gpt.ApiBaseEmbedding = strings.TrimSuffix(gpt.ApiBaseEmbedding, "/")
+	if len(gpt.ApiVendor) <= 0 {
+		gpt.ApiVendor = llamacppDefaultVendor
+	}
+	if len(gpt.Model) <= 0 {
+		gpt.Model = llamacppDefaultModel
+	}
+	if len(gpt.ModelWeak) <= 0 {
+		gpt.ModelWeak = llamacppDefaultModelWeak
+	}
+	if len(gpt.ModelEmbedding) <= 0 {
+		gpt.ModelEmbedding = llamacppDefaultModelEmbedding
+	}
+	gpt.modelInfo, _     = autog.LookupModelInfo(gpt.Model)
+	gpt.modelInfoWeak, _ = autog.LookupModelInfo(gpt.ModelWeak)
+	if gpt.Tokenizer == nil {
+		gpt.Tokenizer = modelTokenizer(gpt.modelInfo)
+	}
+	if gpt.TokenizerWeak == nil {
+		gpt.TokenizerWeak = modelTokenizer(gpt.modelInfoWeak)
+	}
+
+	gpt.mutex.Lock()
+	gpt.tokenizeFailed = nil
+	gpt.mutex.Unlock()
+
+	err := gpt.initHttpClients(gpt.Transport, gpt.TLS, &gpt.TimeOut, &gpt.TimeOutWeak,
+		gpt.HttpClient, gpt.HttpClientWeak, gpt.HttpClientEmbed)
+	if err != nil {
+		return err
+	}
+
+	gpt.chat, err = gpt.NewChatClient(gpt.ApiBase)
+	if err != nil {
+		return err
+	}
+	gpt.chatWeak = gpt.chat
+	if gpt.ApiBaseWeak != gpt.ApiBase {
+		gpt.chatWeak, err = gpt.NewChatClient(gpt.ApiBaseWeak)
+		if err != nil {
+			return err
+		}
+	}
+
+	if gpt.DiscoverModels {
+		info, err := gpt.Props(context.Background(), false)
+		if err != nil {
+			return fmt.Errorf("Failed to get props of %s: %w", gpt.ApiBase, err)
+		}
+		gpt.modelInfo = gpt.modelInfo.Merge(info)
+		info, err = gpt.Props(context.Background(), true)
+		if err != nil {
+			return fmt.Errorf("Failed to get props of %s: %w", gpt.ApiBaseWeak, err)
+		}
+		gpt.modelInfoWeak = gpt.modelInfoWeak.Merge(info)
+	}
+
+	return nil
+}
+
+// NewChatClient returns the OpenAi client of the /v1 endpoints of the server
+// at base, sharing the settings and http clients of gpt.
+func (gpt *LlamaCpp) NewChatClient(base string) (*OpenAi, error) {
+	apikey := gpt.ApiKey
+	if len(apikey) <= 0 {
+		apikey = llamacppNoApiKey
+	}
+	chat := &OpenAi{
+		ApiKey          : apikey,
+		ApiBase         : base + "/v1",
+		ApiVendor       : gpt.ApiVendor,
+		Model           : gpt.Model,
+		ModelWeak       : gpt.ModelWeak,
+		ModelEmbedding  : gpt.ModelEmbedding,
+		Temperature     : gpt.Temperature,
+		TemperatureWeak : gpt.TemperatureWeak,
+		TimeOut         : gpt.TimeOut,
+		TimeOutWeak     : gpt.TimeOutWeak,
+		MaxTokens       : gpt.MaxTokens,
+		MaxTokensWeak   : gpt.MaxTokensWeak,
+		Verbose         : gpt.Verbose,
+		VerboseLog      : gpt.VerboseLog,
+		DisableStreamUsage : gpt.DisableStreamUsage,
+		Retry           : gpt.Retry,
+		Tokenizer       : gpt.Tokenizer,
+		TokenizerWeak   : gpt.TokenizerWeak,
+		HttpClient      : gpt.httpMain,
+		HttpClientWeak  : gpt.httpWeak,
+		HttpClientEmbed : gpt.httpEmbed,
+		Headers         : gpt.Headers,
+	}
+	if err := chat.InitLLM(); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// ErrorStatus maps the status of a failed chat, a prompt over the context
+// size of the server reports LLM_STATUS_EXCEED_CONTEXT.
+func (gpt *LlamaCpp) ErrorStatus(status autog.LLMStatus, err error) autog.LLMStatus {
+	var apiErr OpanaiAPIError
+	if errors.As(err, &apiErr) && apiErr.Type == "exceed_context_size_error" {
+		return autog.LLM_STATUS_EXCEED_CONTEXT
+	}
+	var nativeErr LlamaCppAPIError
+	if errors.As(err, &nativeErr) && nativeErr.Type == "exceed_context_size_error" {
+		return autog.LLM_STATUS_EXCEED_CONTEXT
+	}
+	return status
+}
+
+func (gpt *LlamaCpp) CreateHttpRequest(cxt context.Context, method, url string, payload interface{}) (*http.Request, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Failed encoding json: %w", err)
+	}
+	req, err := http.NewRequestWithContext(cxt, method, url, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-type", "application/json")
+	if len(gpt.ApiKey) > 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gpt.ApiKey))
+	}
+	setHttpHeaders(req, gpt.Headers)
+	return req, nil
+}
+
+func (gpt *LlamaCpp) CheckHttpResponseSuccess(httpRsp *http.Response) error {
+	return checkHttpResponse(httpRsp, func (code int, data []byte) error {
+		var result LlamaCppAPIErrorResponse
+		if err := json.Unmarshal(data, &result); err != nil || len(result.Error.Message) <= 0 {
+			return LlamaCppAPIError{
+				Code:    code,
+				Type:    "Unexpected",
+				Message: string(data),
+			}
+		}
+		result.Error.Code = code
+		return result.Error
+	})
+}
+
+// Tokenize returns the tokens of content by the tokenizer of the main or weak
+// server, without the special tokens.
+func (gpt *LlamaCpp) Tokenize(cxt context.Context, content string, weak bool) ([]int, error) {
+	base := gpt.ApiBase
+	if weak {
+		base = gpt.ApiBaseWeak
+	}
+	return gpt.tokenize(cxt, base, gpt.chatClient(weak), content)
+}
+
+// tokenize asks the /tokenize of base without Retry, a failed count falls
+// back to the local tokenizer instead of waiting.
+func (gpt *LlamaCpp) tokenize(cxt context.Context, base string, client *http.Client, content string) ([]int, error) {
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", base + "/tokenize", LlamaCppTokenizeRequest{ Content: content })
+	if err != nil {
+		return nil, err
+	}
+	httpRsp, err := getHttpResponse(client, httpReq, nil, gpt.CheckHttpResponseSuccess)
+	if err != nil {
+		return nil, err
+	}
+	response := LlamaCppTokenizeResponse{}
+	if err := getHttpBodyObject(httpRsp, &response); err != nil {
+		return nil, err
+	}
+	return response.Tokens, nil
+}
+
+// CreateCompletionRequest builds a /completion request of prompt, the
+// generation options and the response format of the call are applied.
+func (gpt *LlamaCpp) CreateCompletionRequest(prompt string, ropts ChatRequestOptions) *LlamaCppCompletionRequest {
+	temperature := gpt.Temperature
+	maxtokens   := gpt.MaxTokens
+	if ropts.Weak {
+		temperature = gpt.TemperatureWeak
+		maxtokens   = gpt.MaxTokensWeak
+	}
+
+	request := &LlamaCppCompletionRequest{
+		Prompt      : prompt,
+		Stream      : ropts.Stream,
+		CachePrompt : true,
+	}
+	if temperature > 0 {
+		request.Temperature = autog.Ptr(float32(temperature) / float32(100))
+	}
+	if maxtokens > 0 {
+		request.NPredict = maxtokens
+	}
+	if ropts.Format != nil {
+		switch ropts.Format.Type {
+		case autog.RESPONSE_FORMAT_JSON:
+			request.JsonSchema = map[string]interface{}{}
+		case autog.RESPONSE_FORMAT_JSON_SCHEMA:
+			request.JsonSchema = ropts.Format.Schema
+		}
+	}
+	opts := ropts.Options
+	if opts == nil {
+		return request
+	}
+	if opts.Temperature != nil {
+		request.Temperature = opts.Temperature
+	}
+	if opts.TopK != nil {
+		request.TopK = opts.TopK
+	}
+	if opts.TopP != nil {
+		request.TopP = opts.TopP
+	}
+	if opts.MaxTokens != nil {
+		request.NPredict = *opts.MaxTokens
+	}
+	if opts.Stop != nil {
+		request.Stop = opts.Stop
+	}
+	if opts.Seed != nil {
+		request.Seed = opts.Seed
+	}
+	if opts.PresencePenalty != nil {
+		request.PresencePenalty = opts.PresencePenalty
+	}
+	if opts.FrequencyPenalty != nil {
+		request.FrequencyPenalty = opts.FrequencyPenalty
+	}
+	if len(opts.Grammar) > 0 {
+		request.Grammar = opts.Grammar
+	}
+	return request
+}
+
+func (gpt *LlamaCpp) ConvertUsage(model string, response *LlamaCppCompletionResponse) autog.Usage {
+	return autog.Usage{
+		Model            : model,
+		Kind             : autog.UsageKindChat,
+		PromptTokens     : response.TokensEvaluated,
+		CompletionTokens : response.TokensPredicted,
+		TotalTokens      : response.TokensEvaluated + response.TokensPredicted,
+	}
+}
+
+func (gpt *LlamaCpp) CompleteInner(cxt context.Context, prompt string, reader autog.StreamReader, weak, stream bool) (autog.LLMStatus, autog.ChatMessage) {
+	request := gpt.CreateCompletionRequest(prompt, chatRequestOptions(cxt, weak, stream))
+	model := gpt.Model
+	base  := gpt.ApiBase
+	if weak {
+		model = gpt.ModelWeak
+		base  = gpt.ApiBaseWeak
+	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	contentbuf := startStream(reader)
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", base + "/completion", request)
+	if err != nil {
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_REQUEST, err)
+	}
+	httpRsp, err := getHttpResponse(gpt.chatClient(weak), httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if err != nil {
+		return streamError(reader, contentbuf, gpt.ErrorStatus(autog.LLM_STATUS_BED_RESPONSE, err), err)
+	}
+
+	var last LlamaCppCompletionResponse
+	if !stream {
+		if err := getHttpBodyObject(httpRsp, &last); err != nil {
+			return streamError(reader, contentbuf, autog.LLM_STATUS_BED_MESSAGE, err)
+		}
+		contentbuf.WriteString(last.Content)
+		if reader != nil {
+			reader.StreamDelta(contentbuf, last.Content)
+		}
+	} else {
+		defer httpRsp.Body.Close()
+		readErr := readServerEvents(httpRsp.Body, func (data []byte) (bool, error) {
+			last = LlamaCppCompletionResponse{}
+			if err := json.Unmarshal(data, &last); err != nil {
+				return false, fmt.Errorf("Invalid json stream data: %v", err)
+			}
+			if len(last.Content) > 0 {
+				contentbuf.WriteString(last.Content)
+				if reader != nil {
+					reader.StreamDelta(contentbuf, last.Content)
+				}
+			}
+			return last.Stop, nil
+		})
+		if readErr == io.EOF {
+			readErr = fmt.Errorf("Stream ended before stop: %w", readErr)
+		}
+		if readErr != nil {
+			return streamError(reader, contentbuf, autog.LLM_STATUS_BED_MESSAGE, readErr)
+		}
+	}
+	if reader != nil {
+		reader.StreamEnd(contentbuf)
+	}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, last)
+
+	usage := gpt.ConvertUsage(model, &last)
+	autog.RecordUsage(cxt, usage)
+
+	revMsg := autog.ChatMessage{
+		Role    : autog.ROLE_ASSISTANT,
+		Content : contentbuf.String(),
+		Usage   : &usage,
+	}
+
+	return autog.LLM_STATUS_OK, revMsg
+}
+
+// Complete completes the raw prompt by the /completion endpoint of the main
+// server, no chat template is applied.
+func (gpt *LlamaCpp) Complete(cxt context.Context, prompt string) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.CompleteInner(cxt, prompt, nil, false, false)
+}
+
+func (gpt *LlamaCpp) CompleteStream(cxt context.Context, prompt string, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.CompleteInner(cxt, prompt, reader, false, true)
+}
+
+func (gpt *LlamaCpp) CompleteByWeakModel(cxt context.Context, prompt string) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.CompleteInner(cxt, prompt, nil, true, false)
+}
+
+func (gpt *LlamaCpp) CompleteStreamByWeakModel(cxt context.Context, prompt string, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.CompleteInner(cxt, prompt, reader, true, true)
+}
+
+// countTokens counts content by the /tokenize of base, once it failed the
+// server is not asked again and fallback counts instead.
+func (gpt *LlamaCpp) countTokens(cxt context.Context, base string, client *http.Client, content string, fallback tokenizer.Tokenizer) int {
+	gpt.mutex.Lock()
+	failed := gpt.tokenizeFailed[base]
+	gpt.mutex.Unlock()
+	if !failed {
+		tokens, err := gpt.tokenize(cxt, base, client, content)
+		if err == nil {
+			return len(tokens)
+		}
+		// a canceled call says nothing of the server
+		if cxt == nil || cxt.Err() == nil {
+			gpt.mutex.Lock()
+			if gpt.tokenizeFailed == nil {
+				gpt.tokenizeFailed = map[string]bool{}
+			}
+			gpt.tokenizeFailed[base] = true
+			gpt.mutex.Unlock()
+		}
+	}
+	if fallback == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return fallback.Count(content)
+}
+
+// CalcTokens counts the tokens of content by the tokenizer of the server,
+// Tokenizer is only used once the server failed to tokenize.
+func (gpt *LlamaCpp) CalcTokens(cxt context.Context, content string) int {
+	return gpt.countTokens(cxt, gpt.ApiBase, gpt.httpMain, content, gpt.Tokenizer)
+}
+
+func (gpt *LlamaCpp) SendMessages(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.chat.SendMessages(cxt, msgs)
+	return gpt.ErrorStatus(status, msg.Err), msg
+}
+
+func (gpt *LlamaCpp) SendMessagesStream(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.chat.SendMessagesStream(cxt, msgs, reader)
+	return gpt.ErrorStatus(status, msg.Err), msg
+}
+
+func (gpt *LlamaCpp) CalcTokensByWeakModel(cxt context.Context, content string) int {
+	return gpt.countTokens(cxt, gpt.ApiBaseWeak, gpt.httpWeak, content, gpt.TokenizerWeak)
+}
+
+func (gpt *LlamaCpp) SendMessagesByWeakModel(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.chatWeak.SendMessagesByWeakModel(cxt, msgs)
+	return gpt.ErrorStatus(status, msg.Err), msg
+}
+
+func (gpt *LlamaCpp) SendMessagesStreamByWeakModel(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	status, msg := gpt.chatWeak.SendMessagesStreamByWeakModel(cxt, msgs, reader)
+	return gpt.ErrorStatus(status, msg.Err), msg
+}
+
+// DecodeEmbedding returns the vector of e, a server without pooling returns
+// one vector per token and can not be used.
+func (gpt *LlamaCpp) DecodeEmbedding(e json.RawMessage) (autog.Embedding, error) {
+	var embed autog.Embedding
+	if err := json.Unmarshal(e, &embed); err == nil {
+		return embed, nil
+	}
+	var rows []autog.Embedding
+	if err := json.Unmarshal(e, &rows); err != nil {
+		return nil, fmt.Errorf("Invalid embedding: %w", err)
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("Got %d token embeddings, the server needs a pooling type!", len(rows))
+	}
+	return rows[0], nil
+}
+
+// Embeddings embeds texts in one /embedding call of the embedding server,
+// which has no option of dimensions, so dimensions must match the model.
+func (gpt *LlamaCpp) Embeddings(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
+	var embeds []autog.Embedding
+	request := LlamaCppEmbeddingRequest{ Content: texts }
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	httpReq, cerr := gpt.CreateHttpRequest(cxt, "POST", gpt.ApiBaseEmbedding + "/embedding", request)
+	if cerr != nil {
+		return embeds, cerr
+	}
+
+	httpRsp, gerr := getHttpResponse(gpt.httpEmbed, httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if gerr != nil {
+		return embeds, gerr
+	}
+
+	var raw json.RawMessage
+	if err := getHttpBodyObject(httpRsp, &raw); err != nil {
+		return embeds, err
+	}
+
+	logReceiving(gpt.Verbose, gpt.VerboseLog, raw)
+
+	// Old servers answer an object for a single content
+	var response []LlamaCppEmbedding
+	if err := json.Unmarshal(raw, &response); err != nil {
+		var single LlamaCppEmbedding
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return embeds, fmt.Errorf("Invalid json response: %w", err)
+		}
+		response = []LlamaCppEmbedding{ single }
+	}
+
+	if len(response) != len(texts) {
+		return embeds, fmt.Errorf("Got %d embeddings for %d texts!", len(response), len(texts))
+	}
+
+	embeds = make([]autog.Embedding, len(texts))
+	for i, e := range response {
+		embed, err := gpt.DecodeEmbedding(e.Embedding)
+		if err != nil {
+			return nil, err
+		}
+		if dimensions > 0 && len(embed) != dimensions {
+			return nil, fmt.Errorf("Embedding has %d dimensions, not %d!", len(embed), dimensions)
+		}
+		index := e.Index
+		if index < 0 || index >= len(embeds) {
+			index = i
+		}
+		embeds[index] = embed
+	}
+
+	// /embedding reports no usage, the texts are counted by the embedding server
+	tokens := 0
+	for _, text := range texts {
+		tokens += gpt.countTokens(cxt, gpt.ApiBaseEmbedding, gpt.httpEmbed, text, gpt.Tokenizer)
+	}
+	autog.RecordUsage(cxt, autog.Usage{
+		Model        : gpt.ModelEmbedding,
+		Kind         : autog.UsageKindEmbedding,
+		PromptTokens : tokens,
+		TotalTokens  : tokens,
+	})
+
+	return embeds, nil
+}
+
+// Props returns the context size of the main or weak server as the context
+// window of its model, the server may run with less than the model supports.
+func (gpt *LlamaCpp) Props(cxt context.Context, weak bool) (autog.ModelInfo, error) {
+	model := gpt.Model
+	base  := gpt.ApiBase
+	if weak {
+		model = gpt.ModelWeak
+		base  = gpt.ApiBaseWeak
+	}
+	httpReq, err := http.NewRequestWithContext(cxt, "GET", base + "/props", nil)
+	if err != nil {
+		return autog.ModelInfo{}, err
+	}
+	if len(gpt.ApiKey) > 0 {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gpt.ApiKey))
+	}
+	setHttpHeaders(httpReq, gpt.Headers)
+	httpRsp, err := getHttpResponse(gpt.chatClient(weak), httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
+	if err != nil {
+		return autog.ModelInfo{}, err
+	}
+	response := LlamaCppProps{}
+	if err := getHttpBodyObject(httpRsp, &response); err != nil {
+		return autog.ModelInfo{}, err
+	}
+	return autog.ModelInfo{
+		Name          : model,
+		Vendor        : gpt.ApiVendor,
+		ContextWindow : response.DefaultGenerationSettings.NCtx,
+	}, nil
+}
+
+// ListModels returns the models of /v1/models of the main server
+func (gpt *LlamaCpp) ListModels(cxt context.Context) ([]autog.ModelInfo, error) {
+	return gpt.chat.ListModels(cxt)
+}
+
+func (gpt *LlamaCpp) ModelInfo() autog.ModelInfo {
+	return gpt.modelInfo
+}
+
+func (gpt *LlamaCpp) ModelInfoByWeakModel() autog.ModelInfo {
+	return gpt.modelInfoWeak
+}
@@ -0,0 +1,111 @@
+package llm_test
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"net/http"
+	"net/http/httptest"
+	"context"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+const llamacppStream = `data: {"content":"yes","stop":false}
+
+data: {"content":"","stop":true,"tokens_predicted":1,"tokens_evaluated":6}
+
+`
+
+func ExampleLlamaCpp() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.URL.Path)
+		switch r.URL.Path {
+		case "/tokenize":
+			var request llm.LlamaCppTokenizeRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			tokens := make([]int, len(strings.Fields(request.Content)))
+			json.NewEncoder(w).Encode(llm.LlamaCppTokenizeResponse{ Tokens: tokens })
+		case "/v1/chat/completions":
+			var request llm.OpenaiChatCompletionRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			fmt.Println(request.Grammar)
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"no"}}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+		case "/completion":
+			var request llm.LlamaCppCompletionRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			fmt.Println(request.Prompt, request.Grammar)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, llamacppStream)
+		case "/embedding":
+			fmt.Fprint(w, `[{"index":1,"embedding":[[0,1,0]]},{"index":0,"embedding":[[1,0,0]]}]`)
+		}
+	}))
+	defer server.Close()
+
+	llamacpp := &llm.LlamaCpp{ ApiBase: server.URL }
+	err := llamacpp.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	fmt.Println(llamacpp.CalcTokens(context.Background(), "how many tokens here"))
+
+	cxt := autog.WithGenerationOptions(context.Background(), autog.GenerationOptions{
+		Grammar: `root ::= "yes" | "no"`,
+	})
+	status, msg := llamacpp.SendMessages(cxt, []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Is the sky green?" },
+	})
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content)
+
+	status, msg = llamacpp.CompleteStream(cxt, "Is the sky blue?", printReader{})
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content, msg.Usage.TotalTokens)
+
+	meter := &autog.UsageMeter{}
+	embeds, err := llamacpp.Embeddings(autog.WithUsageRecorder(context.Background(), meter), 0, []string{ "hello", "world" })
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	fmt.Println(embeds, meter.Total().PromptTokens)
+
+	// Output:
+	// /tokenize
+	// 4
+	// /v1/chat/completions
+	// root ::= "yes" | "no"
+	// true no
+	// /completion
+	// Is the sky blue? root ::= "yes" | "no"
+	// [yes]
+	// true yes 7
+	// /embedding
+	// /tokenize
+	// /tokenize
+	// [[1 0 0] [0 1 0]] 2
+}
+
+func ExampleLlamaCpp_tokenizeDown() {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// /tokenize is not retried, once failed the counts use the Tokenizer
+	llamacpp := &llm.LlamaCpp{
+		ApiBase: server.URL,
+		Retry: &llm.RetryPolicy{ MaxRetries: 3, BaseDelay: time.Minute },
+	}
+	llamacpp.InitLLM()
+	first  := llamacpp.CalcTokens(context.Background(), "how many tokens here")
+	second := llamacpp.CalcTokens(context.Background(), "how many tokens here")
+	fmt.Println(calls, first > 0, first == second)
+
+	// Output:
+	// 1 true true
+}
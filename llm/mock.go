@@ -0,0 +1,409 @@
+package llm
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"strings"
+	"context"
+	"unicode"
+	"hash/fnv"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+const (
+	defaultMockModel      = "mock"
+	defaultMockChunkSize  = 4
+	defaultMockDimensions = 64
+)
+
+// MockResponse is a reply of Mock, a Status other than LLM_STATUS_OK fails
+// the call with Content as the error text.
+type MockResponse struct {
+	Status    autog.LLMStatus
+	Content   string
+	ToolCalls []autog.ToolCall
+	// Deltas overrides the chunking of Content when streaming, for a failed
+	// call they are streamed before the error.
+	Deltas    []string
+}
+
+// MockRule replies Response to the calls matched by Match
+type MockRule struct {
+	Match    func (msgs []autog.ChatMessage) bool
+	Response MockResponse
+	// Times limits how often the rule replies, 0 means no limit
+	Times    int
+
+	used int
+}
+
+// MockContains returns a rule matching calls whose last message contains substr
+func MockContains(substr string, rsp MockResponse) *MockRule {
+	return &MockRule{
+		Match : func (msgs []autog.ChatMessage) bool {
+			return len(msgs) > 0 && strings.Contains(msgs[len(msgs)-1].Content, substr)
+		},
+		Response : rsp,
+	}
+}
+
+// MockCall is a call received by Mock
+type MockCall struct {
+	Weak     bool
+	Stream   bool
+	Tools    []autog.Tool
+	Format   *autog.ResponseFormat
+	Options  *autog.GenerationOptions
+	Messages []autog.ChatMessage
+}
+
+// Mock is an offline LLM and EmbeddingModel for tests. A call is answered by
+// the next scripted response of Responses, else by the first matching rule,
+// else by Reply, which echoes the last message by default. Embeddings are
+// deterministic hashed bag-of-words vectors, so texts sharing words are close.
+type Mock struct {
+	Model     string
+	Responses []MockResponse
+	Rules     []*MockRule
+	Reply     func (msgs []autog.ChatMessage) MockResponse
+	// ChunkSize is the number of runes per streamed delta
+	ChunkSize int
+	// Dimensions of the embeddings when the call passes 0
+	Dimensions int
+	// EmbeddingError fails the embedding calls when it is not nil
+	EmbeddingError error
+	Tokenizer tokenizer.Tokenizer
+	// Info are the capabilities reported for the main and weak model
+	Info      autog.ModelInfo
+	Verbose   int
+	VerboseLog func (log string)
+
+	mutex  sync.Mutex
+	next   int
+	calls  []MockCall
+	embeds [][]string
+}
+
+func (gpt *Mock) InitLLM() error {
+	if len(gpt.Model) <= 0 {
+		gpt.Model = defaultMockModel
+	}
+	if gpt.ChunkSize <= 0 {
+		gpt.ChunkSize = defaultMockChunkSize
+	}
+	if gpt.Dimensions <= 0 {
+		gpt.Dimensions = defaultMockDimensions
+	}
+	if len(gpt.Info.Name) <= 0 {
+		gpt.Info.Name = gpt.Model
+	}
+	return nil
+}
+
+func (gpt *Mock) ModelInfo() autog.ModelInfo {
+	return gpt.Info
+}
+
+func (gpt *Mock) ModelInfoByWeakModel() autog.ModelInfo {
+	return gpt.Info
+}
+
+// Calls returns the chat calls received so far
+func (gpt *Mock) Calls() []MockCall {
+	gpt.mutex.Lock()
+	defer gpt.mutex.Unlock()
+	return append([]MockCall{}, gpt.calls...)
+}
+
+// EmbeddingCalls returns the texts of the embedding calls received so far
+func (gpt *Mock) EmbeddingCalls() [][]string {
+	gpt.mutex.Lock()
+	defer gpt.mutex.Unlock()
+	return append([][]string{}, gpt.embeds...)
+}
+
+func (gpt *Mock) Reset() {
+	gpt.mutex.Lock()
+	defer gpt.mutex.Unlock()
+	gpt.next   = 0
+	gpt.calls  = nil
+	gpt.embeds = nil
+	for _, rule := range gpt.Rules {
+		rule.used = 0
+	}
+}
+
+func (gpt *Mock) respond(cxt context.Context, msgs []autog.ChatMessage, weak, stream bool) MockResponse {
+	if rsp, ok := gpt.scripted(cxt, msgs, weak, stream); ok {
+		return rsp
+	}
+	// Reply runs unlocked, it may call Calls
+	if gpt.Reply != nil {
+		return gpt.Reply(msgs)
+	}
+	if len(msgs) <= 0 {
+		return MockResponse{}
+	}
+	return MockResponse{ Content: msgs[len(msgs)-1].Content }
+}
+
+// scripted records the call and returns the next scripted or matching response
+func (gpt *Mock) scripted(cxt context.Context, msgs []autog.ChatMessage, weak, stream bool) (MockResponse, bool) {
+	gpt.mutex.Lock()
+	defer gpt.mutex.Unlock()
+	gpt.calls = append(gpt.calls, MockCall{
+		Weak     : weak,
+		Stream   : stream,
+		Tools    : autog.ToolsFromContext(cxt),
+		Format   : autog.ResponseFormatFromContext(cxt),
+		Options  : autog.GenerationOptionsFromContext(cxt),
+		Messages : append([]autog.ChatMessage{}, msgs...),
+	})
+	if gpt.next < len(gpt.Responses) {
+		gpt.next++
+		return gpt.Responses[gpt.next-1], true
+	}
+	for _, rule := range gpt.Rules {
+		if rule.Match == nil || (rule.Times > 0 && rule.used >= rule.Times) {
+			continue
+		}
+		if rule.Match(msgs) {
+			rule.used++
+			return rule.Response, true
+		}
+	}
+	return MockResponse{}, false
+}
+
+func (gpt *Mock) chunks(rsp MockResponse) []string {
+	if len(rsp.Deltas) > 0 || rsp.Status != autog.LLM_STATUS_OK {
+		return rsp.Deltas
+	}
+	size := gpt.ChunkSize
+	if size <= 0 {
+		size = defaultMockChunkSize
+	}
+	var deltas []string
+	runes := []rune(rsp.Content)
+	for i := 0; i < len(runes); i += size {
+		j := i + size
+		if j > len(runes) {
+			j = len(runes)
+		}
+		deltas = append(deltas, string(runes[i:j]))
+	}
+	return deltas
+}
+
+func (gpt *Mock) usage(msgs []autog.ChatMessage, content string) autog.Usage {
+	usage := autog.Usage{ Model: gpt.Model, Kind: autog.UsageKindChat }
+	for _, msg := range msgs {
+		usage.PromptTokens += gpt.count(msg.Content)
+	}
+	usage.CompletionTokens = gpt.count(content)
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+func (gpt *Mock) count(content string) int {
+	if gpt.Tokenizer == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.Tokenizer.Count(content)
+}
+
+func mockError(rsp MockResponse) autog.ChatMessage {
+	errstr := rsp.Content
+	if len(errstr) <= 0 {
+		errstr = fmt.Sprintf("Mock %s!", rsp.Status)
+	}
+	return autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: errstr, Err: fmt.Errorf("%s", errstr)}
+}
+
+func (gpt *Mock) log(prefix string, v interface{}) {
+	if gpt.VerboseLog == nil {
+		return
+	}
+	str, err := json.Marshal(v)
+	if err == nil {
+		gpt.VerboseLog(fmt.Sprintf("%s:\n %s \n", prefix, str))
+	}
+}
+
+func (gpt *Mock) SendMessagesInner(cxt context.Context, msgs []autog.ChatMessage, weak bool) (autog.LLMStatus, autog.ChatMessage) {
+	if gpt.Verbose >= autog.VerboseShowSending {
+		gpt.log("SEND_TO_LLVM", msgs)
+	}
+	rsp := gpt.respond(cxt, msgs, weak, false)
+	if rsp.Status != autog.LLM_STATUS_OK {
+		return rsp.Status, mockError(rsp)
+	}
+	usage := gpt.usage(msgs, rsp.Content)
+	autog.RecordUsage(cxt, usage)
+	revMsg := autog.ChatMessage{
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : rsp.Content,
+		ToolCalls : rsp.ToolCalls,
+		Usage     : &usage,
+	}
+	if gpt.Verbose >= autog.VerboseShowReceiving {
+		gpt.log("RECV_FROM_LLVM", revMsg)
+	}
+	return autog.LLM_STATUS_OK, revMsg
+}
+
+func (gpt *Mock) SendMessagesStreamInner(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader, weak bool) (autog.LLMStatus, autog.ChatMessage) {
+	if gpt.Verbose >= autog.VerboseShowSending {
+		gpt.log("SEND_TO_LLVM", msgs)
+	}
+	rsp := gpt.respond(cxt, msgs, weak, true)
+
+	var contentbuf *strings.Builder
+	if reader != nil {
+		contentbuf = reader.StreamStart()
+	}
+	if contentbuf == nil {
+		contentbuf = &strings.Builder{}
+	}
+
+	for _, delta := range gpt.chunks(rsp) {
+		if cxt != nil && cxt.Err() != nil {
+			rsp = MockResponse{ Status: autog.LLM_STATUS_USER_CANCELED, Content: cxt.Err().Error() }
+			break
+		}
+		contentbuf.WriteString(delta)
+		if reader != nil {
+			reader.StreamDelta(contentbuf, delta)
+		}
+	}
+
+	if rsp.Status != autog.LLM_STATUS_OK {
+		if reader != nil {
+			reader.StreamError(contentbuf, rsp.Status, mockError(rsp).Content)
+			reader.StreamEnd(contentbuf)
+		}
+		return rsp.Status, mockError(rsp)
+	}
+	if reader != nil {
+		reader.StreamEnd(contentbuf)
+	}
+
+	usage := gpt.usage(msgs, contentbuf.String())
+	autog.RecordUsage(cxt, usage)
+	revMsg := autog.ChatMessage{
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : contentbuf.String(),
+		ToolCalls : rsp.ToolCalls,
+		Usage     : &usage,
+	}
+	if gpt.Verbose >= autog.VerboseShowReceiving {
+		gpt.log("RECV_FROM_LLVM", revMsg)
+	}
+	return autog.LLM_STATUS_OK, revMsg
+}
+
+func (gpt *Mock) CalcTokens(cxt context.Context, content string) int {
+	return gpt.count(content)
+}
+
+func (gpt *Mock) SendMessages(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesInner(cxt, msgs, false)
+}
+
+func (gpt *Mock) SendMessagesStream(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesStreamInner(cxt, msgs, reader, false)
+}
+
+func (gpt *Mock) CalcTokensByWeakModel(cxt context.Context, content string) int {
+	return gpt.count(content)
+}
+
+func (gpt *Mock) SendMessagesByWeakModel(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesInner(cxt, msgs, true)
+}
+
+func (gpt *Mock) SendMessagesStreamByWeakModel(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return gpt.SendMessagesStreamInner(cxt, msgs, reader, true)
+}
+
+// mockTerms splits text into lower case words, each CJK rune is a word
+func mockTerms(text string) []string {
+	var terms []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			terms = append(terms, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flush()
+			terms = append(terms, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+// Embedding returns the hashed bag-of-words vector of text, normalized to length 1
+func (gpt *Mock) Embedding(dimensions int, text string) autog.Embedding {
+	embed := make(autog.Embedding, dimensions)
+	for _, term := range mockTerms(text) {
+		h := fnv.New64a()
+		h.Write([]byte(term))
+		sum := h.Sum64()
+		sign := 1.0
+		if sum & (1 << 63) != 0 {
+			sign = -1.0
+		}
+		embed[sum % uint64(dimensions)] += sign
+	}
+	norm := 0.0
+	for _, f := range embed {
+		norm += f * f
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range embed {
+			embed[i] /= norm
+		}
+	}
+	return embed
+}
+
+func (gpt *Mock) Embeddings(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
+	gpt.mutex.Lock()
+	gpt.embeds = append(gpt.embeds, append([]string{}, texts...))
+	gpt.mutex.Unlock()
+	if gpt.EmbeddingError != nil {
+		return nil, gpt.EmbeddingError
+	}
+	if dimensions <= 0 {
+		dimensions = gpt.Dimensions
+	}
+	if dimensions <= 0 {
+		dimensions = defaultMockDimensions
+	}
+	embeds := make([]autog.Embedding, len(texts))
+	tokens := 0
+	for i, text := range texts {
+		embeds[i] = gpt.Embedding(dimensions, text)
+		tokens += gpt.count(text)
+	}
+	autog.RecordUsage(cxt, autog.Usage{
+		Model        : gpt.Model,
+		Kind         : autog.UsageKindEmbedding,
+		PromptTokens : tokens,
+		TotalTokens  : tokens,
+	})
+	return embeds, nil
+}
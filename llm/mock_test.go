@@ -0,0 +1,166 @@
+package llm_test
+
+import (
+	"fmt"
+	"strings"
+	"context"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+	"github.com/autogorg/autog/rag"
+)
+
+func ExampleMock_agent() {
+	mock := &llm.Mock{
+		Responses: []llm.MockResponse{
+			{ Content: `{"city":` },
+			{ Content: `{"city":"Paris"}` },
+		},
+	}
+	err := mock.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	agent := &autog.Agent{}
+	agent.RegisterActions(&autog.Action{
+		Name: "weather",
+		Desc: "Reply a JSON object with the city",
+		NeedRun: func (content string) bool {
+			return strings.HasPrefix(content, "{")
+		},
+		Check: func (content string) (bool, string, interface{}) {
+			var args struct{ City string `json:"city"` }
+			if err := json.Unmarshal([]byte(content), &args); err != nil {
+				return false, "Invalid JSON!", nil
+			}
+			return true, "", args.City
+		},
+		Run: func (content string, payload interface{}) (bool, string) {
+			fmt.Println("Weather of", payload)
+			return true, ""
+		},
+	})
+
+	input := &autog.Input{
+		ReadContent: func() string {
+			return "How is the weather in Paris?"
+		},
+	}
+
+	output := &autog.Output{
+		WriteContent: func(stage autog.AgentStage, stream autog.StreamStage, buf *strings.Builder, str string) {
+			if stage == autog.AsWaitResponse && stream == autog.StreamStageEnd {
+				fmt.Println(buf.String())
+			}
+		},
+	}
+
+	agent.Prompt(agent.ActionPrompt()).
+	ReadQuestion(nil, input, output).
+	AskLLM(mock, true).
+	WaitResponse(nil).
+	Action(nil).
+	Reflection(nil, 3)
+
+	calls := mock.Calls()
+	fmt.Println(agent.Err(), len(calls), calls[1].Messages[len(calls[1].Messages)-1].Content)
+	fmt.Println(agent.Usage.Calls(), agent.Usage.Total().TotalTokens > 0)
+
+	// Output:
+	// {"city":
+	// {"city":"Paris"}
+	// Weather of Paris
+	// <nil> 2 Action [weather] check failed: Invalid JSON!
+	// 2 true
+}
+
+func ExampleMock_errors() {
+	mock := &llm.Mock{
+		Rules: []*llm.MockRule{
+			llm.MockContains("long", llm.MockResponse{ Status: autog.LLM_STATUS_EXCEED_CONTEXT }),
+			llm.MockContains("flaky", llm.MockResponse{ Status: autog.LLM_STATUS_BED_MESSAGE, Content: "Broken stream!", Deltas: []string{ "Hel", "lo" } }),
+		},
+	}
+	mock.InitLLM()
+
+	status, msg := mock.SendMessages(context.Background(), []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "A long question" },
+	})
+	fmt.Println(status, msg.Err)
+
+	agent := &autog.Agent{}
+	agent.Prompt().
+	ReadQuestion(nil, &autog.Input{ ReadContent: func() string { return "A flaky question" } }, nil).
+	AskLLM(mock, true).
+	WaitResponse(nil)
+	fmt.Println(agent.Err())
+
+	status, msg = mock.SendMessages(context.Background(), []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Echo me" },
+	})
+	fmt.Println(status, msg.Content)
+
+	// Reply may inspect the calls received so far
+	mock.Reply = func (msgs []autog.ChatMessage) llm.MockResponse {
+		return llm.MockResponse{ Content: fmt.Sprintf("Call %d", len(mock.Calls())) }
+	}
+	status, msg = mock.SendMessages(context.Background(), []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Count me" },
+	})
+	fmt.Println(status, msg.Content)
+
+	// Output:
+	// EXCEED_CONTEXT Mock EXCEED_CONTEXT!
+	// LLM ERROR (BED_MESSAGE): Broken stream!
+	// OK Echo me
+	// OK Call 4
+}
+
+func ExampleMock_rag() {
+	cxt := context.Background()
+
+	mock := &llm.Mock{}
+	mock.InitLLM()
+
+	memDB, err := rag.NewMemDatabase()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	memRag := &autog.Rag{
+		Database: memDB,
+		EmbeddingModel: mock,
+	}
+
+	// One sentence per chunk
+	doc := ""
+	for _, sentence := range []string{
+		"Go is a programming language designed at Google.",
+		"The Eiffel Tower is a landmark in Paris.",
+		"Bread is baked from flour, water and yeast.",
+	} {
+		doc += fmt.Sprintf("%-59s\n", sentence)
+	}
+	err = memRag.Indexing(cxt, "/doc", doc, &rag.TextSplitter{ ChunkSize: 60 }, false)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	scoredss, err := memRag.Retrieval(cxt, "/doc", []string{ "Where is the Eiffel Tower?" }, 1)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	for _, scoreds := range scoredss {
+		for _, scored := range scoreds {
+			fmt.Println(strings.TrimSpace(scored.Chunk.GetContent()))
+		}
+	}
+
+	// Output:
+	// The Eiffel Tower is a landmark in Paris.
+}
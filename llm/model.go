@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+// ChatRequestOptions are the settings of a request besides its messages,
+// each provider uses the ones it supports.
+type ChatRequestOptions struct {
+	// Weak selects the weak model
+	Weak    bool
+	Stream  bool
+	Tools   []autog.Tool
+	Format  *autog.ResponseFormat
+	Options *autog.GenerationOptions
+}
+
+// chatRequestOptions returns the request options of a call, the tools,
+// response format and generation options are those of cxt.
+func chatRequestOptions(cxt context.Context, weak, stream bool) ChatRequestOptions {
+	return ChatRequestOptions{
+		Weak    : weak,
+		Stream  : stream,
+		Tools   : autog.ToolsFromContext(cxt),
+		Format  : autog.ResponseFormatFromContext(cxt),
+		Options : autog.GenerationOptionsFromContext(cxt),
+	}
+}
+
+// modelTokenizer returns the encoding recorded for the model, or the one
+// selected by its name, an estimator if no vocab is available.
+func modelTokenizer(info autog.ModelInfo) tokenizer.Tokenizer {
+	if len(info.Tokenizer) > 0 {
+		if enc, err := tokenizer.Get(info.Tokenizer); err == nil {
+			return enc
+		}
+	}
+	return tokenizer.ForModel(info.Name)
+}
+
+// modelMaxTokens limits maxtokens to the max output of the model, 0 keeps
+// the default of the server.
+func modelMaxTokens(maxtokens int, info autog.ModelInfo) int {
+	if maxtokens <= 0 {
+		return 0
+	}
+	if info.MaxOutputTokens > 0 && maxtokens > info.MaxOutputTokens {
+		return info.MaxOutputTokens
+	}
+	return maxtokens
+}
@@ -3,15 +3,16 @@ package llm
 import (
 	"io"
 	"fmt"
-	"time"
 	"bytes"
 	"bufio"
+	"sync"
+	"errors"
 	"strings"
 	"context"
 	"net/http"
-	"crypto/tls"
 	"encoding/json"
 	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
 )
 
 const (
@@ -39,22 +40,53 @@ type OllamaAPIErrorResponse struct {
 	Error OllamaAPIError `json:"error"`
 }
 
+type OllamaFunctionDefinition struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	// Parameters is a JSON schema object describing the function arguments
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type OllamaTool struct {
+	Type     string                   `json:"type"`
+	Function OllamaFunctionDefinition `json:"function"`
+}
+
+type OllamaFunctionCall struct {
+	Name      string          `json:"name"`
+	// Arguments is a JSON object, not an encoded string as in OpenAI
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type OllamaToolCall struct {
+	Function OllamaFunctionCall `json:"function"`
+}
+
 type OllamaChatCompletionRequestMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	// Images are base64 encoded, for multimodal models
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
 }
 
 type OllamaChatCompletionResponseMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
 }
 
 type OllamaOptions struct {
-	//NumCtx      int     `json:num_ctx,omitempty`
-	//Seed        int     `json:seed,omitempty`
-	//TopP        float32 `json:top_p,omitempty`
-	//MaxTokens   int     `json:num_predict,omitempty`
-	Temperature float32 `json:"temperature,omitempty"`
+	NumCtx           int      `json:"num_ctx,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	MaxTokens        int      `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Temperature      *float32 `json:"temperature,omitempty"`
 }
 
 type OllamaChatCompletionRequest struct {
@@ -62,12 +94,14 @@ type OllamaChatCompletionRequest struct {
 	Model string `json:"model"`
 	// Messages is a list of messages to use as the context for the chat completion.
 	Messages []OllamaChatCompletionRequestMessage `json:"messages"`
-	// the format to return a response in. Currently the only accepted value is json
-	Format string `json:format,omitempty`
+	// the format to return a response in, "json" or a JSON schema object
+	Format interface{} `json:"format,omitempty"`
 	// additional model parameters listed in the documentation for the Modelfile such as temperature
 	Options OllamaOptions `json:"options,omitempty"`
+	// tools the model may call, requires a model that supports tools
+	Tools []OllamaTool `json:"tools,omitempty"`
 	// the prompt template to use (overrides what is defined in the Modelfile)
-	Template string `json:template,omitempty`
+	Template string `json:"template,omitempty"`
 	// if false the response will be returned as a single response object, rather than a stream of objects
 	Stream bool `json:"stream,omitempty"`
 	// controls how long the model will stay loaded into memory following the request (default: 5m)
@@ -111,6 +145,54 @@ type OllamaEmbeddingResponse struct {
 	Embedding []float32 `json:"embedding"`
 }
 
+// OllamaEmbedRequest is the request of the batched /api/embed endpoint
+type OllamaEmbedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	// Truncate cuts the inputs to the context length, too long inputs fail if false
+	Truncate   *bool    `json:"truncate,omitempty"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type OllamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+type OllamaModelDetails struct {
+	Format            string   `json:"format,omitempty"`
+	Family            string   `json:"family,omitempty"`
+	Families          []string `json:"families,omitempty"`
+	ParameterSize     string   `json:"parameter_size,omitempty"`
+	QuantizationLevel string   `json:"quantization_level,omitempty"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at,omitempty"`
+	Size       int64              `json:"size,omitempty"`
+	Digest     string             `json:"digest,omitempty"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaModelList struct {
+	Models []OllamaModel `json:"models"`
+}
+
+type OllamaShowRequest struct {
+	Model string `json:"model"`
+}
+
+type OllamaShowResponse struct {
+	Details      OllamaModelDetails     `json:"details"`
+	// ModelInfo holds the GGUF metadata, e.g. llama.context_length
+	ModelInfo    map[string]interface{} `json:"model_info,omitempty"`
+	// Capabilities are e.g. completion, tools, vision and embedding
+	Capabilities []string               `json:"capabilities,omitempty"`
+}
+
 type Ollama struct {
 	ApiKey      string
 	ApiBase     string
@@ -123,14 +205,41 @@ type Ollama struct {
 	TemperatureWeak int
 	TimeOut       int
 	TimeOutWeak   int
+	// MaxTokens caps the reply by num_predict, 0 leaves it uncapped
 	MaxTokens     int
 	MaxTokensWeak int
 	Verbose       int
 	VerboseLog    func(log string)
+	// Retry retries failed requests, no retry if nil
+	Retry         *RetryPolicy
+	// Tokenizer counts the tokens of Model, selected by model name if not set
+	Tokenizer     tokenizer.Tokenizer
+	TokenizerWeak tokenizer.Tokenizer
+	// Transport replaces the http transport of the clients, e.g. a Cassette
+	Transport     http.RoundTripper
+	// HttpClient, HttpClientWeak and HttpClientEmbed replace the clients of
+	// the main, weak and embedding calls, TimeOut and Transport are not applied
+	HttpClient      *http.Client
+	HttpClientWeak  *http.Client
+	HttpClientEmbed *http.Client
+	// TLS configures the default transport, certificates are verified by default,
+	// InitLLM fails when Transport is set too
+	TLS           *TLSOptions
+	// Headers are added to every request, e.g. for gateway auth
+	Headers       map[string]string
+	// EmbeddingTruncate is the truncate option of /api/embed, true if nil
+	EmbeddingTruncate *bool
+	// DiscoverModels asks the server for the capabilities of Model and
+	// ModelWeak in InitLLM, the ones recorded in autog.Models are used otherwise
+	DiscoverModels bool
 	
-	httpMain  *http.Client
-	httpWeak  *http.Client
-	httpEmbed *http.Client
+	mutex     sync.Mutex
+	// legacyEmbedding embeds each text by /api/embeddings, it is set when
+	// the server has no /api/embed
+	legacyEmbedding bool
+	modelInfo     autog.ModelInfo
+	modelInfoWeak autog.ModelInfo
+	httpClients
 }
 
 
@@ -163,54 +272,118 @@ func (gpt *Ollama) InitLLM() error {
 		// TODO: Changed by model
 		gpt.TemperatureWeak = 0
 	}
-	if gpt.TimeOut <= 0 {
-		// TODO: Changed by model
-		gpt.TimeOut = 300
-	}
-	if gpt.TimeOutWeak <= 0 {
-		// TODO: Changed by model
-		gpt.TimeOutWeak = 300
+	gpt.modelInfo, _     = autog.LookupModelInfo(gpt.Model)
+	gpt.modelInfoWeak, _ = autog.LookupModelInfo(gpt.ModelWeak)
+	// A MaxTokens of 0 lets the server use the max output of the model
+	gpt.MaxTokens     = modelMaxTokens(gpt.MaxTokens, gpt.modelInfo)
+	gpt.MaxTokensWeak = modelMaxTokens(gpt.MaxTokensWeak, gpt.modelInfoWeak)
+	if gpt.Tokenizer == nil {
+		gpt.Tokenizer = modelTokenizer(gpt.modelInfo)
 	}
-	if gpt.MaxTokens <= 0 {
-		// TODO: Changed by model
-		gpt.MaxTokens = 0
-	}
-	if gpt.MaxTokensWeak <= 0 {
-		// TODO: Changed by model
-		gpt.MaxTokensWeak = 0
+	if gpt.TokenizerWeak == nil {
+		gpt.TokenizerWeak = modelTokenizer(gpt.modelInfoWeak)
 	}
 
-	gpt.httpMain = &http.Client{
-		Timeout: time.Duration(gpt.TimeOut) * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
-	gpt.httpWeak = &http.Client{
-		Timeout: time.Duration(gpt.TimeOutWeak) * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	err := gpt.initHttpClients(gpt.Transport, gpt.TLS, &gpt.TimeOut, &gpt.TimeOutWeak,
+		gpt.HttpClient, gpt.HttpClientWeak, gpt.HttpClientEmbed)
+	if err != nil {
+		return err
 	}
-	gpt.httpEmbed = &http.Client{
-		Timeout: time.Duration(gpt.TimeOutWeak) * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+
+	if gpt.DiscoverModels {
+		info, err := gpt.ShowModel(context.Background(), gpt.Model)
+		if err != nil {
+			return fmt.Errorf("Failed to show model %s: %w", gpt.Model, err)
+		}
+		gpt.modelInfo = gpt.modelInfo.Merge(info)
+		gpt.modelInfoWeak = gpt.modelInfo
+		if gpt.ModelWeak != gpt.Model {
+			info, err = gpt.ShowModel(context.Background(), gpt.ModelWeak)
+			if err != nil {
+				return fmt.Errorf("Failed to show model %s: %w", gpt.ModelWeak, err)
+			}
+			gpt.modelInfoWeak = gpt.modelInfoWeak.Merge(info)
+		}
 	}
 
 	return nil
 }
 
-func (gpt *Ollama) ConvertMessages(msgs []autog.ChatMessage) []OllamaChatCompletionRequestMessage {
+func (gpt *Ollama) ConvertMessages(msgs []autog.ChatMessage) ([]OllamaChatCompletionRequestMessage, error) {
 	reqmsgs := make([]OllamaChatCompletionRequestMessage, len(msgs))
 	for i, msg := range msgs {
-		reqmsgs[i] = OllamaChatCompletionRequestMessage{Role: msg.Role, Content: msg.Content}
+		reqmsgs[i] = OllamaChatCompletionRequestMessage{Role: msg.Role, Content: msg.Text(), ToolName: msg.Name}
+		for _, part := range msg.Parts {
+			if part.Type != autog.PART_IMAGE {
+				continue
+			}
+			// Ollama takes the image data only, an http url can not be sent
+			_, data, err := part.LoadImage()
+			if err != nil {
+				return nil, err
+			}
+			reqmsgs[i].Images = append(reqmsgs[i].Images, data)
+		}
+		for _, call := range msg.ToolCalls {
+			args := json.RawMessage(call.Arguments)
+			if !json.Valid(args) {
+				args = json.RawMessage("{}")
+			}
+			reqmsgs[i].ToolCalls = append(reqmsgs[i].ToolCalls, OllamaToolCall{
+				Function : OllamaFunctionCall{Name: call.Name, Arguments: args},
+			})
+		}
+	}
+	return reqmsgs, nil
+}
+
+func (gpt *Ollama) ConvertUsage(model string, promptTokens, completionTokens int) autog.Usage {
+	return autog.Usage{
+		Model            : model,
+		Kind             : autog.UsageKindChat,
+		PromptTokens     : promptTokens,
+		CompletionTokens : completionTokens,
+		TotalTokens      : promptTokens + completionTokens,
 	}
-	return reqmsgs
+}
+
+func (gpt *Ollama) ConvertTools(tools []autog.Tool) []OllamaTool {
+	if len(tools) <= 0 {
+		return nil
+	}
+	reqtools := make([]OllamaTool, len(tools))
+	for i, tool := range tools {
+		reqtools[i] = OllamaTool{
+			Type     : "function",
+			Function : OllamaFunctionDefinition{
+				Name        : tool.Name,
+				Description : tool.Desc,
+				Parameters  : tool.Parameters,
+			},
+		}
+	}
+	return reqtools
+}
+
+// ConvertToolCalls converts Ollama tool calls, which carry no id, so ids are
+// generated from the position of the call starting at offset.
+func (gpt *Ollama) ConvertToolCalls(calls []OllamaToolCall, offset int) []autog.ToolCall {
+	if len(calls) <= 0 {
+		return nil
+	}
+	toolcalls := make([]autog.ToolCall, len(calls))
+	for i, call := range calls {
+		args := string(call.Function.Arguments)
+		if len(args) <= 0 || args == "null" {
+			args = "{}"
+		}
+		toolcalls[i] = autog.ToolCall{
+			Id        : fmt.Sprintf("call_%d", offset + i),
+			Name      : call.Function.Name,
+			Arguments : args,
+		}
+	}
+	return toolcalls
 }
 
 
@@ -225,37 +398,89 @@ func (gpt *Ollama) JsonBodyReader(body interface{}) (io.Reader, error) {
 	return bytes.NewBuffer(raw), nil
 }
 
-func (gpt *Ollama) CreateChatCompletionRequest(weak, stream bool, msgs []autog.ChatMessage) *OllamaChatCompletionRequest{
+func (gpt *Ollama) ConvertResponseFormat(format *autog.ResponseFormat) interface{} {
+	if format == nil {
+		return nil
+	}
+	switch format.Type {
+	case autog.RESPONSE_FORMAT_JSON:
+		return "json"
+	case autog.RESPONSE_FORMAT_JSON_SCHEMA:
+		if format.Schema == nil {
+			return "json"
+		}
+		return format.Schema
+	}
+	return nil
+}
+
+// ApplyGenerationOptions sets the options of request given in opts, N,
+// LogitBias, User and Grammar are not supported and ignored.
+func (gpt *Ollama) ApplyGenerationOptions(request *OllamaChatCompletionRequest, opts *autog.GenerationOptions) {
+	if opts == nil {
+		return
+	}
+	options := &request.Options
+	if opts.Temperature != nil {
+		options.Temperature = opts.Temperature
+	}
+	if opts.TopP != nil {
+		options.TopP = opts.TopP
+	}
+	if opts.TopK != nil {
+		options.TopK = opts.TopK
+	}
+	if opts.MaxTokens != nil {
+		options.MaxTokens = *opts.MaxTokens
+	}
+	if opts.Stop != nil {
+		options.Stop = opts.Stop
+	}
+	if opts.Seed != nil {
+		options.Seed = opts.Seed
+	}
+	if opts.PresencePenalty != nil {
+		options.PresencePenalty = opts.PresencePenalty
+	}
+	if opts.FrequencyPenalty != nil {
+		options.FrequencyPenalty = opts.FrequencyPenalty
+	}
+	if opts.NumCtx != nil {
+		options.NumCtx = *opts.NumCtx
+	}
+}
+
+func (gpt *Ollama) CreateChatCompletionRequest(msgs []autog.ChatMessage, ropts ChatRequestOptions) (*OllamaChatCompletionRequest, error) {
 	model       := gpt.Model
 	temperature := gpt.Temperature
 	maxtokens   := gpt.MaxTokens
 
-	if weak {
+	if ropts.Weak {
 		model       = gpt.ModelWeak
 		temperature = gpt.TemperatureWeak
 		maxtokens   = gpt.MaxTokensWeak
 	}
 
-	if maxtokens > 0 {
-		return &OllamaChatCompletionRequest{
-			Messages    : gpt.ConvertMessages(msgs),
-			Model       : model,
-			Stream      : stream,
-			Options     : OllamaOptions{
-				Temperature : float32(temperature) / float32(100),
-				// MaxTokens   : maxtokens,
-			},
-		}
+	reqmsgs, err := gpt.ConvertMessages(msgs)
+	if err != nil {
+		return nil, err
 	}
 
-	return &OllamaChatCompletionRequest{
-		Messages    : gpt.ConvertMessages(msgs),
+	request := &OllamaChatCompletionRequest{
+		Messages    : reqmsgs,
 		Model       : model,
-		Stream      : stream,
-		Options     : OllamaOptions{
-			Temperature : float32(temperature) / float32(100),
-		},
+		Stream      : ropts.Stream,
+		Tools       : gpt.ConvertTools(ropts.Tools),
+		Format      : gpt.ConvertResponseFormat(ropts.Format),
+	}
+	if temperature > 0 {
+		request.Options.Temperature = autog.Ptr(float32(temperature) / float32(100))
 	}
+	if maxtokens > 0 {
+		request.Options.MaxTokens = maxtokens
+	}
+	gpt.ApplyGenerationOptions(request, ropts.Options)
+	return request, nil
 }
 
 func (gpt *Ollama) CreateHttpRequest(cxt context.Context, method, path string, payload interface{}) (*http.Request, error) {
@@ -277,89 +502,79 @@ func (gpt *Ollama) CreateHttpRequest(cxt context.Context, method, path string, p
 	req.Header.Set("Content-type", "application/json")
 	// No need for Ollama
 	// req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gpt.ApiKey))
+	setHttpHeaders(req, gpt.Headers)
 	return req, nil
 }
 
 func (gpt *Ollama) CheckHttpResponseSuccess(httpRsp *http.Response) error {
-	if httpRsp.StatusCode >= 200 && httpRsp.StatusCode < 300 {
-		return nil
-	}
-	defer httpRsp.Body.Close()
-	data, err := io.ReadAll(httpRsp.Body)
-	if err != nil {
-		return fmt.Errorf("Failed to read from body: %w", err)
-	}
-	var result OllamaAPIErrorResponse
-	if err := json.Unmarshal(data, &result); err != nil {
-		apiError := OllamaAPIError{
-			StatusCode: httpRsp.StatusCode,
-			Type:       "Unexpected",
-			Message:    string(data),
+	return checkHttpResponse(httpRsp, func (code int, data []byte) error {
+		var result OllamaAPIErrorResponse
+		if err := json.Unmarshal(data, &result); err != nil {
+			return OllamaAPIError{
+				StatusCode: code,
+				Type:       "Unexpected",
+				Message:    string(data),
+			}
 		}
-		return apiError
-	}
-	result.Error.StatusCode = httpRsp.StatusCode
-	return result.Error
+		result.Error.StatusCode = code
+		return result.Error
+	})
 }
 
-func (gpt *Ollama) GetHttpResponse(httpClient *http.Client, httpReq *http.Request) (*http.Response, error) {
-	httpRsp, err := httpClient.Do(httpReq)
-	if err != nil {
-		return nil, err
+// ErrorStatus maps an error of a call to a status, a prompt over the
+// context length of the model reports LLM_STATUS_EXCEED_CONTEXT, so a
+// Router can fall back.
+func (gpt *Ollama) ErrorStatus(err error) autog.LLMStatus {
+	var apiErr OllamaAPIError
+	if !errors.As(err, &apiErr) {
+		return autog.LLM_STATUS_BED_RESPONSE
 	}
-
-	if err := gpt.CheckHttpResponseSuccess(httpRsp); err != nil {
-		return nil, err
+	message := strings.ToLower(apiErr.Message)
+	if strings.Contains(message, "context length") || strings.Contains(message, "context window") {
+		return autog.LLM_STATUS_EXCEED_CONTEXT
 	}
+	return autog.LLM_STATUS_BED_RESPONSE
+}
 
-	return httpRsp, nil
+func (gpt *Ollama) GetHttpResponse(httpClient *http.Client, httpReq *http.Request) (*http.Response, error) {
+	return getHttpResponse(httpClient, httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
 }
 
 func (gpt *Ollama) GetHttpBodyObject(httpRsp *http.Response, response interface{}) error {
-	defer httpRsp.Body.Close()
-	if err := json.NewDecoder(httpRsp.Body).Decode(response); err != nil {
-		return fmt.Errorf("Invalid json response: %w", err)
-	}
-	return nil
+	return getHttpBodyObject(httpRsp, response)
 }
 
 func (gpt *Ollama) SendMessagesInner(cxt context.Context, msgs []autog.ChatMessage, weak bool) (autog.LLMStatus, autog.ChatMessage) {
-	request := gpt.CreateChatCompletionRequest(weak, false, msgs)
-
-	if gpt.Verbose >= autog.VerboseShowSending {
-		reqstr, reqerr := json.Marshal(request)
-		if reqerr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("SEND_TO_LLVM:\n %s \n", reqstr))
-		}
+	request, err := gpt.CreateChatCompletionRequest(msgs, chatRequestOptions(cxt, weak, false))
+	if err != nil {
+		return requestError(nil, err)
 	}
 
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
 	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", "/api/chat", request)
 	if err != nil {
-		return autog.LLM_STATUS_BED_REQUEST, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
-	}
-	httpClient   := gpt.httpMain
-	if weak {
-		httpClient = gpt.httpWeak
+		return requestError(nil, err)
 	}
-	httpRsp, err := gpt.GetHttpResponse(httpClient, httpReq)
+	httpRsp, err := gpt.GetHttpResponse(gpt.chatClient(weak), httpReq)
 	if err != nil {
-		return autog.LLM_STATUS_BED_RESPONSE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
+		return streamError(nil, nil, gpt.ErrorStatus(err), err)
 	}
 	response := OllamaChatCompletionResponse{}
 	if err := gpt.GetHttpBodyObject(httpRsp, &response); err != nil {
-		return autog.LLM_STATUS_BED_MESSAGE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
+		return streamError(nil, nil, autog.LLM_STATUS_BED_MESSAGE, err)
 	}
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		repstr, reperr := json.Marshal(response)
-		if reperr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECV_FROM_LLVM:\n %s \n", repstr))
-		}
-	}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	usage := gpt.ConvertUsage(request.Model, response.PromptEvalCount, response.EvalCount)
+	autog.RecordUsage(cxt, usage)
 
 	revMsg := autog.ChatMessage{
-		Role    : response.Message.Role,
-		Content : response.Message.Content,
+		Role      : response.Message.Role,
+		Content   : response.Message.Content,
+		ToolCalls : gpt.ConvertToolCalls(response.Message.ToolCalls, 0),
+		Usage     : &usage,
 	}
 
 	return autog.LLM_STATUS_OK, revMsg
@@ -367,48 +582,30 @@ func (gpt *Ollama) SendMessagesInner(cxt context.Context, msgs []autog.ChatMessa
 
 
 func (gpt *Ollama) SendMessagesStreamInner(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader, weak bool) (autog.LLMStatus, autog.ChatMessage) {
-	request := gpt.CreateChatCompletionRequest(weak, true, msgs)
-
-	if gpt.Verbose >= autog.VerboseShowSending {
-		reqstr, reqerr := json.Marshal(request)
-		if reqerr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("SEND_TO_LLVM:\n %s \n", reqstr))
-		}
+	request, err := gpt.CreateChatCompletionRequest(msgs, chatRequestOptions(cxt, weak, true))
+	if err != nil {
+		return requestError(reader, err)
 	}
 
-	var contentbuf *strings.Builder
-	if reader != nil {
-		contentbuf = reader.StreamStart()
-	}
-	if contentbuf == nil {
-		contentbuf = &strings.Builder{}
-	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
 
+	contentbuf := startStream(reader)
 	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", "/api/chat", request)
 	if err != nil {
-		if reader != nil {
-			reader.StreamError(contentbuf, autog.LLM_STATUS_BED_REQUEST, err.Error())
-			reader.StreamEnd(contentbuf)
-		}
-		return autog.LLM_STATUS_BED_REQUEST, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_REQUEST, err)
 	}
-	httpClient   := gpt.httpMain
-	if weak {
-		httpClient = gpt.httpWeak
-	}
-	httpRsp, err := gpt.GetHttpResponse(httpClient, httpReq)
+	httpRsp, err := gpt.GetHttpResponse(gpt.chatClient(weak), httpReq)
 	if err != nil {
-		if reader != nil {
-			reader.StreamError(contentbuf, autog.LLM_STATUS_BED_REQUEST, err.Error())
-			reader.StreamEnd(contentbuf)
-		}
-		return autog.LLM_STATUS_BED_RESPONSE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
+		return streamError(reader, contentbuf, gpt.ErrorStatus(err), err)
 	}
+	// Ollama streams one json object per line, not server sent events
 	bufreader := bufio.NewReader(httpRsp.Body)
 	defer httpRsp.Body.Close()
 
 	var readErr error
 	var line []byte
+	var toolcalls []autog.ToolCall
+	var streamusage *autog.Usage
 	for {
 		line, readErr = bufreader.ReadBytes('\n')
 		if readErr != nil {
@@ -421,71 +618,67 @@ func (gpt *Ollama) SendMessagesStreamInner(cxt context.Context, msgs []autog.Cha
 			break
 		}
 
+		// Ollama sends each tool call complete in a single chunk
+		toolcalls = append(toolcalls, gpt.ConvertToolCalls(response.Message.ToolCalls, len(toolcalls))...)
+
 		delta := response.Message.Content
-		if contentbuf != nil {
-			contentbuf.WriteString(delta)
-		}
+		contentbuf.WriteString(delta)
 		if reader != nil {
 			reader.StreamDelta(contentbuf, delta)
 		}
 
 		if response.Done {
+			usage := gpt.ConvertUsage(request.Model, response.PromptEvalCount, response.EvalCount)
+			streamusage = &usage
 			break
 		}
 	}
+	if readErr != nil {
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_MESSAGE, readErr)
+	}
 	if reader != nil {
-		if readErr != nil {
-			reader.StreamError(contentbuf, autog.LLM_STATUS_BED_MESSAGE, readErr.Error())
-		}
 		reader.StreamEnd(contentbuf)
 	}
 
-	if readErr != nil {
-		return autog.LLM_STATUS_BED_MESSAGE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: readErr.Error()}
-	}
-
 	revMsg := autog.ChatMessage{
-		Role    : autog.ROLE_ASSISTANT,
-		Content : contentbuf.String(),
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : contentbuf.String(),
+		ToolCalls : toolcalls,
+	}
+	if streamusage != nil {
+		autog.RecordUsage(cxt, *streamusage)
+		revMsg.Usage = streamusage
 	}
 
 	return autog.LLM_STATUS_OK, revMsg
 }
 
 func (gpt *Ollama) CalcTokens(cxt context.Context, content string) int {
-	// TODO: a fake tokens calc, will fix it with actual tokens calc logic
-	return len(content) / 6
+	if gpt.Tokenizer == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.Tokenizer.Count(content)
 }
 
 func (gpt *Ollama) SendMessages(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
 	status, msg := gpt.SendMessagesInner(cxt, msgs, false)
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		msgstr, err := json.Marshal(msg)
-		if err == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECEIVE_FROM_LLVM STATUS(%d)\n %s \n", status, msgstr))
-		}
-	}
-
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
 	return status, msg
 }
 
 func (gpt *Ollama) SendMessagesStream(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
 	status, msg := gpt.SendMessagesStreamInner(cxt, msgs, reader, false)
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		msgstr, err := json.Marshal(msg)
-		if err == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECEIVE_FROM_LLVM STATUS(%d)\n %s \n", status, msgstr))
-		}
-	}
-
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
 	return status, msg
 }
 
 func (gpt *Ollama) CalcTokensByWeakModel(cxt context.Context, content string) int {
-	// TODO: a fake tokens calc, will fix it with actual tokens calc logic
-	return len(content) / 6
+	if gpt.TokenizerWeak == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.TokenizerWeak.Count(content)
 }
 
 func (gpt *Ollama) SendMessagesByWeakModel(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
@@ -507,12 +700,7 @@ func (gpt *Ollama) Embedding(cxt context.Context, dimensions int, text string) (
 	}
 	request := embeddingReq
 
-	if gpt.Verbose >= autog.VerboseShowSending {
-		reqstr, reqerr := json.Marshal(request)
-		if reqerr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("SEND_TO_LLVM:\n %s \n", reqstr))
-		}
-	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
 
 	httpReq, cerr := gpt.CreateHttpRequest(cxt, "POST", "/api/embeddings", request)
 	if cerr != nil {
@@ -529,12 +717,16 @@ func (gpt *Ollama) Embedding(cxt context.Context, dimensions int, text string) (
 		return embed, rerr
 	}
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		repstr, reperr := json.Marshal(response)
-		if reperr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECV_FROM_LLVM:\n %s \n", repstr))
-		}
-	}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	// The legacy endpoint reports no usage, so the prompt tokens are estimated
+	tokens := gpt.CalcTokens(cxt, text)
+	autog.RecordUsage(cxt, autog.Usage{
+		Model        : request.Model,
+		Kind         : autog.UsageKindEmbedding,
+		PromptTokens : tokens,
+		TotalTokens  : tokens,
+	})
 
 	embed = autog.Embedding{}
 	for _, f := range response.Embedding {
@@ -544,15 +736,215 @@ func (gpt *Ollama) Embedding(cxt context.Context, dimensions int, text string) (
 	return embed, nil
 }
 
-func (gpt *Ollama) Embeddings(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
+// IsEmbedMissing reports if err is the 404 of a server without /api/embed,
+// unlike a missing model it has no json body.
+func (gpt *Ollama) IsEmbedMissing(err error) bool {
+	var apiErr OllamaAPIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusNotFound && strings.Contains(apiErr.Message, "page not found")
+}
+
+// IsEmbedRejected reports if err is a server refusing a batch of texts, one
+// of them may be the cause, e.g. an input over the context length. A missing
+// model or a failed auth fails every text alike, so it is not a refusal.
+func (gpt *Ollama) IsEmbedRejected(err error) bool {
+	var apiErr OllamaAPIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusRequestEntityTooLarge
+}
+
+// EmbedBatch embeds texts in one /api/embed call
+func (gpt *Ollama) EmbedBatch(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
 	var embeds []autog.Embedding
-	var err error
-	embeds = make([]autog.Embedding, len(texts))
+	request := OllamaEmbedRequest{
+		Model      : gpt.ModelEmbedding,
+		Input      : texts,
+		Truncate   : gpt.EmbeddingTruncate,
+		Dimensions : dimensions,
+	}
+
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	httpReq, cerr := gpt.CreateHttpRequest(cxt, "POST", "/api/embed", request)
+	if cerr != nil {
+		return embeds, cerr
+	}
+
+	httpRsp, gerr := gpt.GetHttpResponse(gpt.httpEmbed, httpReq)
+	if gerr != nil {
+		return embeds, gerr
+	}
+
+	response := OllamaEmbedResponse{}
+	if rerr := gpt.GetHttpBodyObject(httpRsp, &response); rerr != nil {
+		return embeds, rerr
+	}
+
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	if len(response.Embeddings) != len(texts) {
+		return embeds, fmt.Errorf("Got %d embeddings for %d texts!", len(response.Embeddings), len(texts))
+	}
+
+	autog.RecordUsage(cxt, autog.Usage{
+		Model        : request.Model,
+		Kind         : autog.UsageKindEmbedding,
+		PromptTokens : response.PromptEvalCount,
+		TotalTokens  : response.PromptEvalCount,
+	})
+
+	for _, values := range response.Embeddings {
+		embed := make(autog.Embedding, len(values))
+		for i, f := range values {
+			embed[i] = float64(f)
+		}
+		embeds = append(embeds, embed)
+	}
+
+	return embeds, nil
+}
+
+// embedEach embeds each text by embed, the texts that fail are reported by
+// an *autog.EmbeddingError and the others are kept.
+func embedEach(texts []string, embed func (text string) (autog.Embedding, error)) ([]autog.Embedding, error) {
+	var partial *autog.EmbeddingError
+	embeds := make([]autog.Embedding, len(texts))
 	for i, text := range texts {
-		embeds[i], err = gpt.Embedding(cxt, dimensions, text)
+		e, err := embed(text)
 		if err != nil {
-			break
+			if partial == nil {
+				partial = &autog.EmbeddingError{}
+			}
+			partial.Failed = append(partial.Failed, i)
+			partial.Errs   = append(partial.Errs, err)
+			continue
+		}
+		embeds[i] = e
+	}
+	if partial != nil {
+		return embeds, partial
+	}
+	return embeds, nil
+}
+
+// EmbeddingsLegacy embeds each text by /api/embeddings, the texts that fail
+// are reported by an *autog.EmbeddingError and the others are kept.
+func (gpt *Ollama) EmbeddingsLegacy(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
+	if dimensions > 0 {
+		return nil, fmt.Errorf("dimensions is not support!")
+	}
+	return embedEach(texts, func (text string) (autog.Embedding, error) {
+		return gpt.Embedding(cxt, dimensions, text)
+	})
+}
+
+// LegacyEmbedding reports if the server has no /api/embed, so each text is
+// embedded by /api/embeddings.
+func (gpt *Ollama) LegacyEmbedding() bool {
+	gpt.mutex.Lock()
+	defer gpt.mutex.Unlock()
+	return gpt.legacyEmbedding
+}
+
+// Embeddings embeds texts in one /api/embed call, a batch the server refuses
+// is embedded text by text, so the failing texts are reported by an
+// *autog.EmbeddingError. Servers without /api/embed fall back to one
+// /api/embeddings call per text.
+func (gpt *Ollama) Embeddings(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
+	if !gpt.LegacyEmbedding() {
+		embeds, err := gpt.EmbedBatch(cxt, dimensions, texts)
+		if gpt.IsEmbedRejected(err) && len(texts) > 1 {
+			return embedEach(texts, func (text string) (autog.Embedding, error) {
+				es, err := gpt.EmbedBatch(cxt, dimensions, []string{ text })
+				if err != nil {
+					return nil, err
+				}
+				return es[0], nil
+			})
+		}
+		if !gpt.IsEmbedMissing(err) {
+			return embeds, err
+		}
+		gpt.mutex.Lock()
+		gpt.legacyEmbedding = true
+		gpt.mutex.Unlock()
+	}
+	return gpt.EmbeddingsLegacy(cxt, dimensions, texts)
+}
+
+// ListModels returns the local models of /api/tags, with the capabilities
+// recorded in autog.Models, ShowModel asks the server for them.
+func (gpt *Ollama) ListModels(cxt context.Context) ([]autog.ModelInfo, error) {
+	httpReq, err := gpt.CreateHttpRequest(cxt, "GET", "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	httpRsp, err := gpt.GetHttpResponse(gpt.httpMain, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	response := OllamaModelList{}
+	if err := gpt.GetHttpBodyObject(httpRsp, &response); err != nil {
+		return nil, err
+	}
+	infos := make([]autog.ModelInfo, len(response.Models))
+	for i, model := range response.Models {
+		infos[i], _ = autog.LookupModelInfo(model.Name)
+		infos[i].Vendor = gpt.ApiVendor
+	}
+	return infos, nil
+}
+
+// ConvertShowResponse reads the capabilities of model from the GGUF metadata
+// of /api/show, old servers report no capabilities but the bert family.
+func (gpt *Ollama) ConvertShowResponse(model string, response *OllamaShowResponse) autog.ModelInfo {
+	info := autog.ModelInfo{ Name: model, Vendor: gpt.ApiVendor }
+	arch, _ := response.ModelInfo["general.architecture"].(string)
+	if ctx, ok := response.ModelInfo[arch + ".context_length"].(float64); ok {
+		info.ContextWindow = int(ctx)
+	}
+	embedding := strings.Contains(response.Details.Family, "bert")
+	for _, capability := range response.Capabilities {
+		switch capability {
+		case "tools":
+			info.Tools = true
+		case "vision":
+			info.Vision = true
+		case "embedding":
+			embedding = true
 		}
 	}
-	return embeds, err
+	if dims, ok := response.ModelInfo[arch + ".embedding_length"].(float64); ok && embedding {
+		info.EmbeddingDimensions = int(dims)
+	}
+	return info
+}
+
+// ShowModel returns the capabilities of model reported by /api/show
+func (gpt *Ollama) ShowModel(cxt context.Context, model string) (autog.ModelInfo, error) {
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", "/api/show", OllamaShowRequest{ Model: model })
+	if err != nil {
+		return autog.ModelInfo{}, err
+	}
+	httpRsp, err := gpt.GetHttpResponse(gpt.httpMain, httpReq)
+	if err != nil {
+		return autog.ModelInfo{}, err
+	}
+	response := OllamaShowResponse{}
+	if err := gpt.GetHttpBodyObject(httpRsp, &response); err != nil {
+		return autog.ModelInfo{}, err
+	}
+	return gpt.ConvertShowResponse(model, &response), nil
+}
+
+func (gpt *Ollama) ModelInfo() autog.ModelInfo {
+	return gpt.modelInfo
+}
+
+func (gpt *Ollama) ModelInfoByWeakModel() autog.ModelInfo {
+	return gpt.modelInfoWeak
 }
\ No newline at end of file
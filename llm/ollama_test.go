@@ -0,0 +1,237 @@
+package llm_test
+
+import (
+	"fmt"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"context"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleOllama_Embeddings() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request llm.OllamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Println(r.URL.Path, request.Input, request.Dimensions)
+		response := llm.OllamaEmbedResponse{ PromptEvalCount: 4 }
+		for range request.Input {
+			response.Embeddings = append(response.Embeddings, make([]float32, request.Dimensions))
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	ollama := &llm.Ollama{ ApiBase: server.URL }
+	err := ollama.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	meter := &autog.UsageMeter{}
+	cxt := autog.WithUsageRecorder(context.Background(), meter)
+	embeds, err := ollama.Embeddings(cxt, 3, []string{ "hello", "world" })
+	fmt.Println(len(embeds), len(embeds[0]), err, meter.Total().PromptTokens)
+
+	// Output:
+	// /api/embed [hello world] 3
+	// 2 3 <nil> 4
+}
+
+func ExampleOllama_Embeddings_legacy() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/embed" {
+			fmt.Println(r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var request llm.OllamaEmbeddingRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Printf("%s %q\n", r.URL.Path, request.Prompt)
+		if len(request.Prompt) <= 0 {
+			http.Error(w, `{"error":"empty prompt"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(llm.OllamaEmbeddingResponse{ Embedding: []float32{ 1, 0 } })
+	}))
+	defer server.Close()
+
+	ollama := &llm.Ollama{ ApiBase: server.URL }
+	err := ollama.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	embeds, err := ollama.Embeddings(context.Background(), 0, []string{ "hello", "", "world" })
+	var partial *autog.EmbeddingError
+	if errors.As(err, &partial) {
+		fmt.Println(partial.Failed)
+	}
+	fmt.Println(embeds, ollama.LegacyEmbedding())
+
+	// Output:
+	// /api/embed
+	// /api/embeddings "hello"
+	// /api/embeddings ""
+	// /api/embeddings "world"
+	// [1]
+	// [[1 0] [] [1 0]] true
+}
+
+func ExampleOllama_Embeddings_partial() {
+	// The server refuses an empty input, until it is fixed
+	fixed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request llm.OllamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Printf("%s %q\n", r.URL.Path, request.Input)
+		if request.Model == "missing" {
+			http.Error(w, `{"error":"model \"missing\" not found"}`, http.StatusNotFound)
+			return
+		}
+		response := llm.OllamaEmbedResponse{ PromptEvalCount: len(request.Input) }
+		for _, input := range request.Input {
+			if len(input) <= 0 && !fixed {
+				http.Error(w, `{"error":"empty input"}`, http.StatusBadRequest)
+				return
+			}
+			response.Embeddings = append(response.Embeddings, []float32{ 1, 0 })
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	ollama := &llm.Ollama{ ApiBase: server.URL }
+	err := ollama.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	// A refused batch is embedded text by text, the failing ones are reported
+	rag := &autog.Rag{
+		EmbeddingModel : ollama,
+		EmbeddingBatch : 3,
+		EmbeddingCallback : func (stage autog.EmbeddingStage, texts []string, embeds []autog.Embedding, i, j int, finished, tried int, err error) bool {
+			var partial *autog.EmbeddingError
+			if errors.As(err, &partial) {
+				fmt.Println("failed", partial.Failed, "finished", finished, "tried", tried)
+				fixed = true
+				return tried < 1
+			}
+			fmt.Println("finished", finished, "tried", tried, err)
+			return false
+		},
+	}
+	embeds, err := rag.Embeddings(context.Background(), autog.EmbeddingStageIndexing, []string{ "hello", "", "world" })
+	fmt.Println(embeds, err, ollama.LegacyEmbedding())
+
+	// A missing model fails the batch at once, not text by text
+	ollama.ModelEmbedding = "missing"
+	_, err = ollama.Embeddings(context.Background(), 0, []string{ "hello", "world" })
+	fmt.Println(err)
+
+	// Output:
+	// /api/embed ["hello" "" "world"]
+	// /api/embed ["hello"]
+	// /api/embed [""]
+	// /api/embed ["world"]
+	// failed [1] finished 2 tried 0
+	// /api/embed ["hello" "" "world"]
+	// finished 3 tried 1 <nil>
+	// [[1 0] [1 0] [1 0]] <nil> false
+	// /api/embed ["hello" "world"]
+	// [404:Unexpected] {"error":"model \"missing\" not found"}
+}
+
+func ExampleOllama_SendMessagesStream_toolCalls() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request llm.OllamaChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		for _, msg := range request.Messages {
+			calls, _ := json.Marshal(msg.ToolCalls)
+			fmt.Println(msg.Role, msg.Content, msg.ToolName, string(calls))
+		}
+		// Each tool call comes complete in its own chunk, without id
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_time","arguments":null}}]},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":20,"eval_count":8}`)
+	}))
+	defer server.Close()
+
+	ollama := &llm.Ollama{ ApiBase: server.URL }
+	err := ollama.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	// A previous call and its result are sent back, the arguments as a JSON
+	// object and the result by the tool name
+	status, msg := ollama.SendMessagesStream(context.Background(), []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Weather and time in Paris?" },
+		{ Role: autog.ROLE_ASSISTANT, ToolCalls: []autog.ToolCall{ { Id: "call_0", Name: "get_weather", Arguments: `{"city":"Paris"}` } } },
+		{ Role: autog.ROLE_TOOL, Name: "get_weather", ToolCallId: "call_0", Content: "Sunny" },
+	}, nil)
+	fmt.Println(status == autog.LLM_STATUS_OK)
+	for _, call := range msg.ToolCalls {
+		fmt.Println(call.Id, call.Name, call.Arguments)
+	}
+
+	// Output:
+	// user Weather and time in Paris?  null
+	// assistant   [{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]
+	// tool Sunny get_weather null
+	// true
+	// call_0 get_weather {"city":"Paris"}
+	// call_1 get_time {}
+}
+
+func ExampleOllama_ListModels() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.Method, r.URL.Path)
+		if r.URL.Path == "/api/tags" {
+			fmt.Fprint(w, `{"models":[{"name":"llama3.1:8b","model":"llama3.1:8b"},{"name":"phi3:mini","model":"phi3:mini"}]}`)
+			return
+		}
+		var request llm.OllamaShowRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Println(request.Model)
+		fmt.Fprint(w, `{"details":{"family":"phi3"},"capabilities":["completion","tools"],"model_info":{"general.architecture":"phi3","phi3.context_length":131072,"phi3.embedding_length":3072}}`)
+	}))
+	defer server.Close()
+
+	ollama := &llm.Ollama{ ApiBase: server.URL, Model: "phi3:mini", DiscoverModels: true }
+	err := ollama.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	info := ollama.ModelInfo()
+	fmt.Println(info.Name, info.ContextWindow, info.Tools, info.Vision, info.EmbeddingDimensions)
+	fmt.Println(ollama.ModelInfoByWeakModel().ContextWindow)
+
+	infos, err := ollama.ListModels(context.Background())
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	for _, info := range infos {
+		fmt.Println(info.Name, info.ContextWindow, info.Tools)
+	}
+
+	// Output:
+	// POST /api/show
+	// phi3:mini
+	// POST /api/show
+	// gemma:2b
+	// phi3:mini 131072 true false 0
+	// 131072
+	// GET /api/tags
+	// llama3.1:8b 131072 true
+	// phi3:mini 0 false
+}
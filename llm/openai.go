@@ -3,18 +3,18 @@ package llm
 import (
 	"io"
 	"fmt"
-	"time"
 	"bytes"
-	"bufio"
 	"math"
+	"errors"
 	"strings"
 	"context"
+	"net/url"
 	"net/http"
-	"crypto/tls"
 	"encoding/json"
 	"encoding/base64"
 	"encoding/binary"
 	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
 )
 
 const (
@@ -23,6 +23,8 @@ const (
 	openaiDefaultModel          = "gpt-4-turbo-preview"
 	openaiDefaultModelWeak      = "gpt-4-turbo-preview"
 	openaiDefaultModelEmbed     = "text-embedding-3-large"
+	openaiAzureVendor           = "azure"
+	openaiAzureDefaultVersion   = "2024-10-21"
 )
 
 var (
@@ -35,6 +37,8 @@ type OpanaiAPIError struct {
 	StatusCode int    `json:"status_code"`
 	Message    string `json:"message"`
 	Type       string `json:"type"`
+	// Code is e.g. context_length_exceeded
+	Code       string `json:"code,omitempty"`
 }
 
 // Error returns a string representation of the error
@@ -47,14 +51,83 @@ type OpanaiAPIErrorResponse struct {
 	Error OpanaiAPIError `json:"error"`
 }
 
+type OpenaiFunctionDefinition struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	// Parameters is a JSON schema object describing the function arguments
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type OpenaiTool struct {
+	Type     string                   `json:"type"`
+	Function OpenaiFunctionDefinition `json:"function"`
+}
+
+type OpenaiFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	// Arguments is a JSON encoded object, streamed as fragments
+	Arguments string `json:"arguments"`
+}
+
+type OpenaiToolCall struct {
+	// Index is only set in stream deltas, to reassemble the fragments of a call
+	Index    *int               `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function OpenaiFunctionCall `json:"function"`
+}
+
+type OpenaiImageUrl struct {
+	Url    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type OpenaiContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageUrl *OpenaiImageUrl `json:"image_url,omitempty"`
+}
+
 type OpenaiChatCompletionRequestMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	// Content is a string, or []OpenaiContentPart for multimodal messages
+	Content    interface{}      `json:"content"`
+	ToolCalls  []OpenaiToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+	Name       string           `json:"name,omitempty"`
 }
 
 type OpenaiChatCompletionResponseMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []OpenaiToolCall `json:"tool_calls,omitempty"`
+}
+
+type OpenaiStreamOptions struct {
+	// IncludeUsage asks for a last chunk carrying the usage of the whole request
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type OpenaiJsonSchema struct {
+	Name   string      `json:"name"`
+	Schema interface{} `json:"schema,omitempty"`
+	Strict bool        `json:"strict,omitempty"`
+}
+
+type OpenaiResponseFormat struct {
+	// Type is "text", "json_object" or "json_schema"
+	Type       string            `json:"type"`
+	JsonSchema *OpenaiJsonSchema `json:"json_schema,omitempty"`
+}
+
+type OpenaiToolChoiceFunction struct {
+	Name string `json:"name"`
+}
+
+// OpenaiToolChoice forces the call of a function
+type OpenaiToolChoice struct {
+	Type     string                   `json:"type"`
+	Function OpenaiToolChoiceFunction `json:"function"`
 }
 
 type OpenaiChatCompletionRequest struct {
@@ -64,26 +137,38 @@ type OpenaiChatCompletionRequest struct {
 	Messages []OpenaiChatCompletionRequestMessage `json:"messages"`
 	// Temperature is sampling temperature to use, between 0 and 2. Higher values like 0.8 will make the output more random,
 	// while lower values like 0.2 will make it more focused and deterministic
-	Temperature float32 `json:"temperature,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
 	// TopP is an alternative to sampling with temperature, called nucleus sampling, where the model considers the results of
 	// the tokens with top_p probability mass. So 0.1 means only the tokens comprising the top 10% probability mass are considered.
-	TopP float32 `json:"top_p,omitempty"`
+	TopP *float32 `json:"top_p,omitempty"`
 	// N is number of responses to generate
 	N int `json:"n,omitempty"`
 	// Stream is whether to stream responses back as they are generated
 	Stream bool `json:"stream,omitempty"`
+	// StreamOptions is only set when Stream is true
+	StreamOptions *OpenaiStreamOptions `json:"stream_options,omitempty"`
 	// Stop is up to 4 sequences where the API will stop generating further tokens.
 	Stop []string `json:"stop,omitempty"`
 	// MaxTokens is the maximum number of tokens to return.
 	MaxTokens int `json:"max_tokens,omitempty"`
 	// PresencePenalty (-2, 2) penalize tokens that haven't appeared yet in the history.
-	PresencePenalty float32 `json:"presence_penalty,omitempty"`
+	PresencePenalty *float32 `json:"presence_penalty,omitempty"`
 	// FrequencyPenalty (-2, 2) penalize tokens that appear too frequently in the history.
-	FrequencyPenalty float32 `json:"frequency_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	// Seed makes the sampling deterministic as far as possible
+	Seed *int `json:"seed,omitempty"`
 	// LogitBias modify the probability of specific tokens appearing in the completion.
 	LogitBias map[string]float32 `json:"logit_bias,omitempty"`
 	// User can be used to identify an end-user
 	User string `json:"user,omitempty"`
+	// Tools is a list of functions the model may call
+	Tools []OpenaiTool `json:"tools,omitempty"`
+	// ToolChoice controls which function is called, "none", "auto", "required" or an OpenaiToolChoice
+	ToolChoice interface{} `json:"tool_choice,omitempty"`
+	// ResponseFormat asks for a JSON reply, optionally matching a schema
+	ResponseFormat *OpenaiResponseFormat `json:"response_format,omitempty"`
+	// Grammar is a GBNF grammar, an extension of llama.cpp and compatible servers
+	Grammar string `json:"grammar,omitempty"`
 }
 
 type OpenaiChatCompletionResponseChoice struct {
@@ -289,14 +374,33 @@ func (r OpenaiEmbeddingRequestTokens) Convert() OpenaiEmbeddingRequest {
 	}
 }
 
+type OpenaiModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type OpenaiModelList struct {
+	Object string        `json:"object"`
+	Data   []OpenaiModel `json:"data"`
+}
+
 type OpenAi struct {
 	ApiKey      string
 	ApiBase     string
 	ApiVendor   string
 	ApiOrg      string
+	// ApiVersion is the api-version of Azure OpenAI
+	ApiVersion  string
 	Model       string
 	ModelWeak   string
 	ModelEmbedding  string
+	// Deployment, DeploymentWeak and DeploymentEmbedding are the Azure
+	// deployments of Model, ModelWeak and ModelEmbedding, the model names if not set
+	Deployment          string
+	DeploymentWeak      string
+	DeploymentEmbedding string
 	Temperature     int
 	TemperatureWeak int
 	TimeOut       int
@@ -305,22 +409,44 @@ type OpenAi struct {
 	MaxTokensWeak int
 	Verbose       int
 	VerboseLog    func(log string)
+	// DisableStreamUsage stops asking for usage in streams, for servers not supporting stream_options
+	DisableStreamUsage bool
+	// Retry retries failed requests, no retry if nil
+	Retry         *RetryPolicy
+	// Tokenizer counts the tokens of Model, selected by model name if not set
+	Tokenizer     tokenizer.Tokenizer
+	TokenizerWeak tokenizer.Tokenizer
+	// Transport replaces the http transport of the clients, e.g. a Cassette
+	Transport     http.RoundTripper
+	// HttpClient, HttpClientWeak and HttpClientEmbed replace the clients of
+	// the main, weak and embedding calls, TimeOut and Transport are not applied
+	HttpClient      *http.Client
+	HttpClientWeak  *http.Client
+	HttpClientEmbed *http.Client
+	// TLS configures the default transport, certificates are verified by default,
+	// InitLLM fails when Transport is set too
+	TLS           *TLSOptions
+	// Headers are added to every request, e.g. for gateway auth
+	Headers       map[string]string
 	
-	httpMain  *http.Client
-	httpWeak  *http.Client
-	httpEmbed *http.Client
+	modelInfo     autog.ModelInfo
+	modelInfoWeak autog.ModelInfo
+	httpClients
 }
 
 func (gpt *OpenAi) InitLLM() error {
 	if len(gpt.ApiKey) <= 0 {
 		return fmt.Errorf("API Key is needed!")
 	}
-	if len(gpt.ApiBase) <= 0 {
-		gpt.ApiBase = openaiDefaultBaseURL
-	}
 	if len(gpt.ApiVendor) <= 0 {
 		gpt.ApiVendor = openaiDefaultVendor
 	}
+	if len(gpt.ApiBase) <= 0 {
+		if gpt.IsAzure() {
+			return fmt.Errorf("API Base of the Azure resource is needed!")
+		}
+		gpt.ApiBase = openaiDefaultBaseURL
+	}
 	if len(gpt.Model) <= 0 {
 		gpt.Model = openaiDefaultModel
 	}
@@ -330,6 +456,21 @@ func (gpt *OpenAi) InitLLM() error {
 	if len(gpt.ModelEmbedding) <= 0 {
 		gpt.ModelEmbedding = openaiDefaultModelEmbed
 	}
+	if gpt.IsAzure() {
+		gpt.ApiBase = strings.TrimSuffix(gpt.ApiBase, "/")
+		if len(gpt.ApiVersion) <= 0 {
+			gpt.ApiVersion = openaiAzureDefaultVersion
+		}
+		if len(gpt.Deployment) <= 0 {
+			gpt.Deployment = gpt.Model
+		}
+		if len(gpt.DeploymentWeak) <= 0 {
+			gpt.DeploymentWeak = gpt.ModelWeak
+		}
+		if len(gpt.DeploymentEmbedding) <= 0 {
+			gpt.DeploymentEmbedding = gpt.ModelEmbedding
+		}
+	}
 	if gpt.Temperature <= 0 {
 		// TODO: Changed by model
 		gpt.Temperature = 0
@@ -338,54 +479,141 @@ func (gpt *OpenAi) InitLLM() error {
 		// TODO: Changed by model
 		gpt.TemperatureWeak = 0
 	}
-	if gpt.TimeOut <= 0 {
-		// TODO: Changed by model
-		gpt.TimeOut = 300
+	gpt.modelInfo, _     = autog.LookupModelInfo(gpt.Model)
+	gpt.modelInfoWeak, _ = autog.LookupModelInfo(gpt.ModelWeak)
+	// A MaxTokens of 0 lets the server use the max output of the model
+	gpt.MaxTokens     = modelMaxTokens(gpt.MaxTokens, gpt.modelInfo)
+	gpt.MaxTokensWeak = modelMaxTokens(gpt.MaxTokensWeak, gpt.modelInfoWeak)
+	if gpt.Tokenizer == nil {
+		gpt.Tokenizer = modelTokenizer(gpt.modelInfo)
 	}
-	if gpt.TimeOutWeak <= 0 {
-		// TODO: Changed by model
-		gpt.TimeOutWeak = 300
+	if gpt.TokenizerWeak == nil {
+		gpt.TokenizerWeak = modelTokenizer(gpt.modelInfoWeak)
 	}
-	if gpt.MaxTokens <= 0 {
-		// TODO: Changed by model
-		gpt.MaxTokens = 0
+
+	return gpt.initHttpClients(gpt.Transport, gpt.TLS, &gpt.TimeOut, &gpt.TimeOutWeak,
+		gpt.HttpClient, gpt.HttpClientWeak, gpt.HttpClientEmbed)
+}
+
+func (gpt *OpenAi) ConvertContent(msg autog.ChatMessage) (interface{}, error) {
+	if len(msg.Parts) <= 0 {
+		return msg.Content, nil
 	}
-	if gpt.MaxTokensWeak <= 0 {
-		// TODO: Changed by model
-		gpt.MaxTokensWeak = 0
+	var parts []OpenaiContentPart
+	if len(msg.Content) > 0 {
+		parts = append(parts, OpenaiContentPart{ Type: "text", Text: msg.Content })
+	}
+	for _, part := range msg.Parts {
+		switch part.Type {
+		case autog.PART_TEXT:
+			parts = append(parts, OpenaiContentPart{ Type: "text", Text: part.Text })
+		case autog.PART_IMAGE:
+			url, err := part.Url()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, OpenaiContentPart{
+				Type     : "image_url",
+				ImageUrl : &OpenaiImageUrl{ Url: url, Detail: part.Detail },
+			})
+		default:
+			return nil, fmt.Errorf("Content part type %s is not supported!", part.Type)
+		}
 	}
+	return parts, nil
+}
 
-	gpt.httpMain = &http.Client{
-		Timeout: time.Duration(gpt.TimeOut) * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+func (gpt *OpenAi) ConvertMessages(msgs []autog.ChatMessage) ([]OpenaiChatCompletionRequestMessage, error) {
+	reqmsgs := make([]OpenaiChatCompletionRequestMessage, len(msgs))
+	for i, msg := range msgs {
+		content, err := gpt.ConvertContent(msg)
+		if err != nil {
+			return nil, err
+		}
+		reqmsgs[i] = OpenaiChatCompletionRequestMessage{
+			Role       : msg.Role,
+			Content    : content,
+			ToolCallID : msg.ToolCallId,
+			Name       : msg.Name,
+		}
+		for _, call := range msg.ToolCalls {
+			reqmsgs[i].ToolCalls = append(reqmsgs[i].ToolCalls, OpenaiToolCall{
+				ID       : call.Id,
+				Type     : "function",
+				Function : OpenaiFunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
 	}
-	gpt.httpWeak = &http.Client{
-		Timeout: time.Duration(gpt.TimeOutWeak) * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	return reqmsgs, nil
+}
+
+func (gpt *OpenAi) ConvertTools(tools []autog.Tool) []OpenaiTool {
+	if len(tools) <= 0 {
+		return nil
 	}
-	gpt.httpEmbed = &http.Client{
-		Timeout: time.Duration(gpt.TimeOutWeak) * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	reqtools := make([]OpenaiTool, len(tools))
+	for i, tool := range tools {
+		reqtools[i] = OpenaiTool{
+			Type     : "function",
+			Function : OpenaiFunctionDefinition{
+				Name        : tool.Name,
+				Description : tool.Desc,
+				Parameters  : tool.Parameters,
+			},
+		}
 	}
+	return reqtools
+}
 
-	return nil
+func (gpt *OpenAi) ConvertUsage(model string, kind autog.UsageKind, usage OpenaiUsage) autog.Usage {
+	return autog.Usage{
+		Model            : model,
+		Kind             : kind,
+		PromptTokens     : usage.PromptTokens,
+		CompletionTokens : usage.CompletionTokens,
+		TotalTokens      : usage.TotalTokens,
+	}
 }
 
-func (gpt *OpenAi) ConvertMessages(msgs []autog.ChatMessage) []OpenaiChatCompletionRequestMessage {
-	reqmsgs := make([]OpenaiChatCompletionRequestMessage, len(msgs))
-	for i, msg := range msgs {
-		reqmsgs[i] = OpenaiChatCompletionRequestMessage{Role: msg.Role, Content: msg.Content}
+func (gpt *OpenAi) ConvertToolCalls(calls []OpenaiToolCall) []autog.ToolCall {
+	if len(calls) <= 0 {
+		return nil
+	}
+	toolcalls := make([]autog.ToolCall, len(calls))
+	for i, call := range calls {
+		toolcalls[i] = autog.ToolCall{
+			Id        : call.ID,
+			Name      : call.Function.Name,
+			Arguments : call.Function.Arguments,
+		}
+	}
+	return toolcalls
+}
+
+// MergeToolCallDeltas appends the streamed tool call fragments in deltas to calls,
+// fragments are matched by their index and arguments are concatenated.
+func (gpt *OpenAi) MergeToolCallDeltas(calls []OpenaiToolCall, deltas []OpenaiToolCall) []OpenaiToolCall {
+	for _, delta := range deltas {
+		index := len(calls)
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		for len(calls) <= index {
+			calls = append(calls, OpenaiToolCall{Type: "function"})
+		}
+		call := &calls[index]
+		if len(delta.ID) > 0 {
+			call.ID = delta.ID
+		}
+		if len(delta.Type) > 0 {
+			call.Type = delta.Type
+		}
+		if len(delta.Function.Name) > 0 {
+			call.Function.Name += delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
 	}
-	return reqmsgs
+	return calls
 }
 
 func (gpt *OpenAi) JsonBodyReader(body interface{}) (io.Reader, error) {
@@ -399,33 +627,134 @@ func (gpt *OpenAi) JsonBodyReader(body interface{}) (io.Reader, error) {
 	return bytes.NewBuffer(raw), nil
 }
 
-func (gpt *OpenAi) CreateChatCompletionRequest(weak, stream bool, msgs []autog.ChatMessage) *OpenaiChatCompletionRequest{
+func (gpt *OpenAi) ConvertResponseFormat(format *autog.ResponseFormat) *OpenaiResponseFormat {
+	if format == nil || len(format.Type) <= 0 {
+		return nil
+	}
+	rspformat := &OpenaiResponseFormat{ Type: format.Type }
+	if format.Type == autog.RESPONSE_FORMAT_JSON_SCHEMA {
+		rspformat.JsonSchema = &OpenaiJsonSchema{
+			Name   : format.Name,
+			Schema : format.Schema,
+			Strict : format.Strict,
+		}
+	}
+	return rspformat
+}
+
+// ApplyGenerationOptions sets the fields of request given in opts, TopK and
+// NumCtx are not supported and ignored, Grammar is only sent to compatible
+// servers such as llama.cpp.
+func (gpt *OpenAi) ApplyGenerationOptions(request *OpenaiChatCompletionRequest, opts *autog.GenerationOptions) {
+	if opts == nil {
+		return
+	}
+	if opts.Temperature != nil {
+		request.Temperature = opts.Temperature
+	}
+	if opts.TopP != nil {
+		request.TopP = opts.TopP
+	}
+	if opts.MaxTokens != nil {
+		request.MaxTokens = *opts.MaxTokens
+	}
+	if opts.Stop != nil {
+		request.Stop = opts.Stop
+	}
+	if opts.Seed != nil {
+		request.Seed = opts.Seed
+	}
+	if opts.N != nil {
+		request.N = *opts.N
+	}
+	if opts.PresencePenalty != nil {
+		request.PresencePenalty = opts.PresencePenalty
+	}
+	if opts.FrequencyPenalty != nil {
+		request.FrequencyPenalty = opts.FrequencyPenalty
+	}
+	if opts.LogitBias != nil {
+		request.LogitBias = opts.LogitBias
+	}
+	if len(opts.User) > 0 {
+		request.User = opts.User
+	}
+	if len(opts.Grammar) > 0 && gpt.ApiVendor != openaiDefaultVendor && !gpt.IsAzure() {
+		request.Grammar = opts.Grammar
+	}
+	// The API refuses a tool choice without tools
+	if len(opts.ToolChoice) > 0 && len(request.Tools) > 0 {
+		request.ToolChoice = gpt.ConvertToolChoice(opts.ToolChoice)
+	}
+}
+
+// ConvertToolChoice returns the tool_choice of choice, the name of a tool
+// forces the call of that function.
+func (gpt *OpenAi) ConvertToolChoice(choice string) interface{} {
+	switch choice {
+	case "auto", "none", "required":
+		return choice
+	}
+	return OpenaiToolChoice{ Type: "function", Function: OpenaiToolChoiceFunction{ Name: choice } }
+}
+
+func (gpt *OpenAi) CreateChatCompletionRequest(msgs []autog.ChatMessage, ropts ChatRequestOptions) (*OpenaiChatCompletionRequest, error) {
 	model       := gpt.Model
 	temperature := gpt.Temperature
 	maxtokens   := gpt.MaxTokens
 
-	if weak {
+	if ropts.Weak {
 		model       = gpt.ModelWeak
 		temperature = gpt.TemperatureWeak
 		maxtokens   = gpt.MaxTokensWeak
 	}
 
-	if maxtokens > 0 {
-		return &OpenaiChatCompletionRequest{
-			Messages    : gpt.ConvertMessages(msgs),
-			Model       : model,
-			Temperature : float32(temperature) / float32(100),
-			Stream      : stream,
-			MaxTokens   : maxtokens,
-		}
+	reqmsgs, err := gpt.ConvertMessages(msgs)
+	if err != nil {
+		return nil, err
 	}
 
-	return &OpenaiChatCompletionRequest{
-		Messages    : gpt.ConvertMessages(msgs),
+	request := &OpenaiChatCompletionRequest{
+		Messages    : reqmsgs,
 		Model       : model,
-		Temperature : float32(temperature) / float32(100),
-		Stream      : stream,
+		Stream      : ropts.Stream,
+		Tools       : gpt.ConvertTools(ropts.Tools),
+		ResponseFormat : gpt.ConvertResponseFormat(ropts.Format),
+	}
+	if temperature > 0 {
+		request.Temperature = autog.Ptr(float32(temperature) / float32(100))
 	}
+	if maxtokens > 0 {
+		request.MaxTokens = maxtokens
+	}
+	gpt.ApplyGenerationOptions(request, ropts.Options)
+	if ropts.Stream && !gpt.DisableStreamUsage {
+		request.StreamOptions = &OpenaiStreamOptions{ IncludeUsage: true }
+	}
+	return request, nil
+}
+
+// IsAzure reports if ApiVendor is azure, the calls then go to the deployments
+// of an Azure OpenAI resource at ApiBase.
+func (gpt *OpenAi) IsAzure() bool {
+	return strings.EqualFold(gpt.ApiVendor, openaiAzureVendor)
+}
+
+// ApiPath returns the path of a call to deployment, path itself unless on Azure
+func (gpt *OpenAi) ApiPath(deployment, path string) string {
+	if !gpt.IsAzure() {
+		return path
+	}
+	return fmt.Sprintf("/openai/deployments/%s%s?api-version=%s",
+		url.PathEscape(deployment), path, url.QueryEscape(gpt.ApiVersion))
+}
+
+// ChatPath returns the path of the chat completions of the main or weak model
+func (gpt *OpenAi) ChatPath(weak bool) string {
+	if weak {
+		return gpt.ApiPath(gpt.DeploymentWeak, "/chat/completions")
+	}
+	return gpt.ApiPath(gpt.Deployment, "/chat/completions")
 }
 
 func (gpt *OpenAi) CreateHttpRequest(cxt context.Context, method, path string, payload interface{}) (*http.Request, error) {
@@ -433,8 +762,7 @@ func (gpt *OpenAi) CreateHttpRequest(cxt context.Context, method, path string, p
 	if err != nil {
 		return nil, err
 	}
-	url := gpt.ApiBase + path
-	req, err := http.NewRequestWithContext(cxt, method, url, jsonBody)
+	req, err := http.NewRequestWithContext(cxt, method, gpt.ApiBase + path, jsonBody)
 	if err != nil {
 		return nil, err
 	}
@@ -442,90 +770,87 @@ func (gpt *OpenAi) CreateHttpRequest(cxt context.Context, method, path string, p
 		req.Header.Set("OpenAI-Organization", gpt.ApiOrg)
 	}
 	req.Header.Set("Content-type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gpt.ApiKey))
+	if gpt.IsAzure() {
+		req.Header.Set("api-key", gpt.ApiKey)
+	} else {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gpt.ApiKey))
+	}
+	setHttpHeaders(req, gpt.Headers)
 	return req, nil
 }
 
 func (gpt *OpenAi) CheckHttpResponseSuccess(httpRsp *http.Response) error {
-	if httpRsp.StatusCode >= 200 && httpRsp.StatusCode < 300 {
-		return nil
-	}
-	defer httpRsp.Body.Close()
-	data, err := io.ReadAll(httpRsp.Body)
-	if err != nil {
-		return fmt.Errorf("Failed to read from body: %w", err)
-	}
-	var result OpanaiAPIErrorResponse
-	if err := json.Unmarshal(data, &result); err != nil {
-		aPIError := OpanaiAPIError{
-			StatusCode: httpRsp.StatusCode,
-			Type:       "Unexpected",
-			Message:    string(data),
+	return checkHttpResponse(httpRsp, func (code int, data []byte) error {
+		var result OpanaiAPIErrorResponse
+		if err := json.Unmarshal(data, &result); err != nil {
+			return OpanaiAPIError{
+				StatusCode: code,
+				Type:       "Unexpected",
+				Message:    string(data),
+			}
 		}
-		return aPIError
-	}
-	result.Error.StatusCode = httpRsp.StatusCode
-	return result.Error
+		result.Error.StatusCode = code
+		return result.Error
+	})
 }
 
-func (gpt *OpenAi) GetHttpResponse(httpClient *http.Client, httpReq *http.Request) (*http.Response, error) {
-	httpRsp, err := httpClient.Do(httpReq)
-	if err != nil {
-		return nil, err
+// ErrorStatus maps an error of a call to a status, a prompt over the
+// context length of the model reports LLM_STATUS_EXCEED_CONTEXT, so a
+// Router can fall back.
+func (gpt *OpenAi) ErrorStatus(err error) autog.LLMStatus {
+	var apiErr OpanaiAPIError
+	if !errors.As(err, &apiErr) {
+		return autog.LLM_STATUS_BED_RESPONSE
 	}
-
-	if err := gpt.CheckHttpResponseSuccess(httpRsp); err != nil {
-		return nil, err
+	if apiErr.Code == "context_length_exceeded" || strings.Contains(apiErr.Message, "context_length_exceeded") {
+		return autog.LLM_STATUS_EXCEED_CONTEXT
 	}
+	return autog.LLM_STATUS_BED_RESPONSE
+}
 
-	return httpRsp, nil
+func (gpt *OpenAi) GetHttpResponse(httpClient *http.Client, httpReq *http.Request) (*http.Response, error) {
+	return getHttpResponse(httpClient, httpReq, gpt.Retry, gpt.CheckHttpResponseSuccess)
 }
 
 func (gpt *OpenAi) GetHttpBodyObject(httpRsp *http.Response, response interface{}) error {
-	defer httpRsp.Body.Close()
-	if err := json.NewDecoder(httpRsp.Body).Decode(response); err != nil {
-		return fmt.Errorf("Invalid json response: %w", err)
-	}
-	return nil
+	return getHttpBodyObject(httpRsp, response)
 }
 
 func (gpt *OpenAi) SendMessagesInner(cxt context.Context, msgs []autog.ChatMessage, weak bool) (autog.LLMStatus, autog.ChatMessage) {
-	request := gpt.CreateChatCompletionRequest(weak, false, msgs)
-
-	if gpt.Verbose >= autog.VerboseShowSending {
-		reqstr, reqerr := json.Marshal(request)
-		if reqerr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("SEND_TO_LLVM:\n %s \n", reqstr))
-		}
+	request, err := gpt.CreateChatCompletionRequest(msgs, chatRequestOptions(cxt, weak, false))
+	if err != nil {
+		return requestError(nil, err)
 	}
 
-	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", "/chat/completions", request)
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
+
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", gpt.ChatPath(weak), request)
 	if err != nil {
-		return autog.LLM_STATUS_BED_REQUEST, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
-	}
-	httpClient   := gpt.httpMain
-	if weak {
-		httpClient = gpt.httpWeak
+		return requestError(nil, err)
 	}
-	httpRsp, err := gpt.GetHttpResponse(httpClient, httpReq)
+	httpRsp, err := gpt.GetHttpResponse(gpt.chatClient(weak), httpReq)
 	if err != nil {
-		return autog.LLM_STATUS_BED_RESPONSE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
+		return streamError(nil, nil, gpt.ErrorStatus(err), err)
 	}
 	response := OpenaiChatCompletionResponse{}
 	if err := gpt.GetHttpBodyObject(httpRsp, &response); err != nil {
-		return autog.LLM_STATUS_BED_MESSAGE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
+		return streamError(nil, nil, autog.LLM_STATUS_BED_MESSAGE, err)
 	}
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		repstr, reperr := json.Marshal(response)
-		if reperr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECV_FROM_LLVM:\n %s \n", repstr))
-		}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	if len(response.Choices) <= 0 {
+		return streamError(nil, nil, autog.LLM_STATUS_BED_MESSAGE, fmt.Errorf("Response has no choices!"))
 	}
 
+	usage := gpt.ConvertUsage(request.Model, autog.UsageKindChat, response.Usage)
+	autog.RecordUsage(cxt, usage)
+
 	revMsg := autog.ChatMessage{
-		Role    : response.Choices[0].Message.Role,
-		Content : response.Choices[0].Message.Content,
+		Role      : response.Choices[0].Message.Role,
+		Content   : response.Choices[0].Message.Content,
+		ToolCalls : gpt.ConvertToolCalls(response.Choices[0].Message.ToolCalls),
+		Usage     : &usage,
 	}
 
 	return autog.LLM_STATUS_OK, revMsg
@@ -533,133 +858,103 @@ func (gpt *OpenAi) SendMessagesInner(cxt context.Context, msgs []autog.ChatMessa
 
 
 func (gpt *OpenAi) SendMessagesStreamInner(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader, weak bool) (autog.LLMStatus, autog.ChatMessage) {
-	request := gpt.CreateChatCompletionRequest(weak, true, msgs)
-
-	if gpt.Verbose >= autog.VerboseShowSending {
-		reqstr, reqerr := json.Marshal(request)
-		if reqerr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("SEND_TO_LLVM:\n %s \n", reqstr))
-		}
+	request, err := gpt.CreateChatCompletionRequest(msgs, chatRequestOptions(cxt, weak, true))
+	if err != nil {
+		return requestError(reader, err)
 	}
 
-	var contentbuf *strings.Builder
-	if reader != nil {
-		contentbuf = reader.StreamStart()
-	}
-	if contentbuf == nil {
-		contentbuf = &strings.Builder{}
-	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
 
-	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", "/chat/completions", request)
+	contentbuf := startStream(reader)
+	httpReq, err := gpt.CreateHttpRequest(cxt, "POST", gpt.ChatPath(weak), request)
 	if err != nil {
-		if reader != nil {
-			reader.StreamError(contentbuf, autog.LLM_STATUS_BED_REQUEST, err.Error())
-			reader.StreamEnd(contentbuf)
-		}
-		return autog.LLM_STATUS_BED_REQUEST, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
-	}
-	httpClient   := gpt.httpMain
-	if weak {
-		httpClient = gpt.httpWeak
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_REQUEST, err)
 	}
-	httpRsp, err := gpt.GetHttpResponse(httpClient, httpReq)
+	httpRsp, err := gpt.GetHttpResponse(gpt.chatClient(weak), httpReq)
 	if err != nil {
-		if reader != nil {
-			reader.StreamError(contentbuf, autog.LLM_STATUS_BED_REQUEST, err.Error())
-			reader.StreamEnd(contentbuf)
-		}
-		return autog.LLM_STATUS_BED_RESPONSE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error()}
+		return streamError(reader, contentbuf, gpt.ErrorStatus(err), err)
 	}
-	bufreader := bufio.NewReader(httpRsp.Body)
 	defer httpRsp.Body.Close()
 
-	var readErr error
-	var line []byte
-	for {
-		line, readErr = bufreader.ReadBytes('\n')
-		if readErr != nil {
-			break
+	var toolcalls []OpenaiToolCall
+	var streamusage *OpenaiUsage
+	readErr := readServerEvents(httpRsp.Body, func (data []byte) (bool, error) {
+		if bytes.HasPrefix(data, donePrefix) {
+			return true, nil
 		}
 
-		line = bytes.TrimSpace(line)
-
-		if !bytes.HasPrefix(line, dataPrefix) {
-			continue
+		response := OpenaiChatCompletionStreamResponse{}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return false, fmt.Errorf("Invalid json stream data: %v", err)
 		}
 
-		line = bytes.TrimPrefix(line, dataPrefix)
-
-		if bytes.HasPrefix(line, donePrefix) {
-			break
+		if response.Usage.TotalTokens > 0 {
+			streamusage = &response.Usage
 		}
 
-		response := OpenaiChatCompletionStreamResponse{}
-		if err := json.Unmarshal(line, &response); err != nil {
-			readErr = fmt.Errorf("Invalid json stream data: %v", err)
-			break
+		if len(response.Choices) <= 0 {
+			return false, nil
 		}
 
+		toolcalls = gpt.MergeToolCallDeltas(toolcalls, response.Choices[0].Delta.ToolCalls)
+
 		delta := response.Choices[0].Delta.Content
-		if contentbuf != nil {
-			contentbuf.WriteString(delta)
+		if len(delta) <= 0 {
+			return false, nil
 		}
+		contentbuf.WriteString(delta)
 		if reader != nil {
 			reader.StreamDelta(contentbuf, delta)
 		}
+		return false, nil
+	})
+	if readErr != nil {
+		return streamError(reader, contentbuf, autog.LLM_STATUS_BED_MESSAGE, readErr)
 	}
 	if reader != nil {
-		if readErr != nil {
-			reader.StreamError(contentbuf, autog.LLM_STATUS_BED_MESSAGE, readErr.Error())
-		}
 		reader.StreamEnd(contentbuf)
 	}
 
-	if readErr != nil {
-		return autog.LLM_STATUS_BED_MESSAGE, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: readErr.Error()}
-	}
-
 	revMsg := autog.ChatMessage{
-		Role    : autog.ROLE_ASSISTANT,
-		Content : contentbuf.String(),
+		Role      : autog.ROLE_ASSISTANT,
+		Content   : contentbuf.String(),
+		ToolCalls : gpt.ConvertToolCalls(toolcalls),
+	}
+	if streamusage != nil {
+		usage := gpt.ConvertUsage(request.Model, autog.UsageKindChat, *streamusage)
+		autog.RecordUsage(cxt, usage)
+		revMsg.Usage = &usage
 	}
 
 	return autog.LLM_STATUS_OK, revMsg
 }
 
 func (gpt *OpenAi) CalcTokens(cxt context.Context, content string) int {
-	// TODO: a fake tokens calc, will fix it with actual tokens calc logic
-	return len(content) / 6
+	if gpt.Tokenizer == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.Tokenizer.Count(content)
 }
 
 func (gpt *OpenAi) SendMessages(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
 	status, msg := gpt.SendMessagesInner(cxt, msgs, false)
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		msgstr, err := json.Marshal(msg)
-		if err == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECEIVE_FROM_LLVM STATUS(%d)\n %s \n", status, msgstr))
-		}
-	}
-
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
 	return status, msg
 }
 
 func (gpt *OpenAi) SendMessagesStream(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
 	status, msg := gpt.SendMessagesStreamInner(cxt, msgs, reader, false)
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		msgstr, err := json.Marshal(msg)
-		if err == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECEIVE_FROM_LLVM STATUS(%d)\n %s \n", status, msgstr))
-		}
-	}
-
+	logReceived(gpt.Verbose, gpt.VerboseLog, status, msg)
 	return status, msg
 }
 
 func (gpt *OpenAi) CalcTokensByWeakModel(cxt context.Context, content string) int {
-	// TODO: a fake tokens calc, will fix it with actual tokens calc logic
-	return len(content) / 6
+	if gpt.TokenizerWeak == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return gpt.TokenizerWeak.Count(content)
 }
 
 func (gpt *OpenAi) SendMessagesByWeakModel(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
@@ -681,14 +976,9 @@ func (gpt *OpenAi) Embeddings(cxt context.Context, dimensions int, texts []strin
 	}
 	request := embeddingReq.Convert()
 
-	if gpt.Verbose >= autog.VerboseShowSending {
-		reqstr, reqerr := json.Marshal(request)
-		if reqerr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("SEND_TO_LLVM:\n %s \n", reqstr))
-		}
-	}
+	logSending(gpt.Verbose, gpt.VerboseLog, request)
 
-	httpReq, cerr := gpt.CreateHttpRequest(cxt, "POST", "/embeddings", request)
+	httpReq, cerr := gpt.CreateHttpRequest(cxt, "POST", gpt.ApiPath(gpt.DeploymentEmbedding, "/embeddings"), request)
 	if cerr != nil {
 		return embeds, cerr
 	}
@@ -716,12 +1006,9 @@ func (gpt *OpenAi) Embeddings(cxt context.Context, dimensions int, texts []strin
 		}
 	}
 
-	if gpt.Verbose >= autog.VerboseShowReceiving {
-		repstr, reperr := json.Marshal(response)
-		if reperr == nil && gpt.VerboseLog != nil {
-			gpt.VerboseLog(fmt.Sprintf("RECV_FROM_LLVM:\n %s \n", repstr))
-		}
-	}
+	logReceiving(gpt.Verbose, gpt.VerboseLog, response)
+
+	autog.RecordUsage(cxt, gpt.ConvertUsage(request.Model, autog.UsageKindEmbedding, response.Usage))
 
 	for i, _ := range response.Data {
 		embed := autog.Embedding{}
@@ -732,4 +1019,41 @@ func (gpt *OpenAi) Embeddings(cxt context.Context, dimensions int, texts []strin
 	}
 
 	return embeds, nil
+}
+
+// ListModels returns the models of the server, with the capabilities
+// recorded in autog.Models, the API itself reports none.
+func (gpt *OpenAi) ListModels(cxt context.Context) ([]autog.ModelInfo, error) {
+	path := "/models"
+	if gpt.IsAzure() {
+		path = "/openai/models?api-version=" + url.QueryEscape(gpt.ApiVersion)
+	}
+	httpReq, err := gpt.CreateHttpRequest(cxt, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpRsp, err := gpt.GetHttpResponse(gpt.httpMain, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	response := OpenaiModelList{}
+	if err := gpt.GetHttpBodyObject(httpRsp, &response); err != nil {
+		return nil, err
+	}
+	infos := make([]autog.ModelInfo, len(response.Data))
+	for i, model := range response.Data {
+		infos[i], _ = autog.LookupModelInfo(model.ID)
+		if len(infos[i].Vendor) <= 0 {
+			infos[i].Vendor = gpt.ApiVendor
+		}
+	}
+	return infos, nil
+}
+
+func (gpt *OpenAi) ModelInfo() autog.ModelInfo {
+	return gpt.modelInfo
+}
+
+func (gpt *OpenAi) ModelInfoByWeakModel() autog.ModelInfo {
+	return gpt.modelInfoWeak
 }
\ No newline at end of file
@@ -0,0 +1,174 @@
+package llm_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"context"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+const openaiToolCallStream = `data: {"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: {"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":8,"total_tokens":28}}
+
+data: [DONE]
+
+`
+
+func ExampleOpenAi_SendMessagesStream_toolCalls() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ToolChoice json.RawMessage `json:"tool_choice"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		fmt.Println(string(request.ToolChoice))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, openaiToolCallStream)
+	}))
+	defer server.Close()
+
+	openai := &llm.OpenAi{ ApiBase: server.URL, ApiKey: "test", Model: "gpt-4o" }
+	err := openai.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	meter := &autog.UsageMeter{
+		Prices: autog.PriceTable{ "gpt-4o": { PromptPerMillion: 2.5, CompletionPerMillion: 10 } },
+	}
+	cxt := autog.WithUsageRecorder(context.Background(), meter)
+	cxt  = autog.WithGenerationOptions(cxt, autog.GenerationOptions{ ToolChoice: "get_weather" })
+	cxt  = autog.WithTools(cxt, autog.Tool{
+		Name: "get_weather",
+		Desc: "Get the weather of a city",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"city": map[string]interface{}{ "type": "string" },
+			},
+		},
+	})
+	status, msg := openai.SendMessagesStream(cxt, []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "How is the weather in Paris?" },
+	}, nil)
+
+	fmt.Println(status == autog.LLM_STATUS_OK)
+	for _, call := range msg.ToolCalls {
+		fmt.Printf("%s %s %s\n", call.Id, call.Name, call.Arguments)
+	}
+	fmt.Printf("%d %d %.6f\n", msg.Usage.TotalTokens, meter.Total().TotalTokens, meter.Cost())
+
+	// Output:
+	// {"type":"function","function":{"name":"get_weather"}}
+	// true
+	// call_1 get_weather {"city":"Paris"}
+	// 28 28 0.000130
+}
+
+func ExampleOpenAi_ConvertMessages_images() {
+	msgs := []autog.ChatMessage{
+		{
+			Role: autog.ROLE_USER,
+			Content: "What is in these images?",
+			Parts: []autog.ContentPart{
+				autog.ImageUrlPart("https://example.com/cat.png"),
+				autog.ImageBase64Part("image/png", "iVBORw0KGgo="),
+			},
+		},
+	}
+
+	openai := &llm.OpenAi{}
+	reqmsgs, err := openai.ConvertMessages(msgs)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	data, _ := json.Marshal(reqmsgs[0].Content)
+	fmt.Println(string(data))
+
+	ollama := &llm.Ollama{}
+	_, err = ollama.ConvertMessages(msgs)
+	fmt.Println(err)
+	msgs[0].Parts = msgs[0].Parts[1:]
+	ollmsgs, _ := ollama.ConvertMessages(msgs)
+	fmt.Println(ollmsgs[0].Content, ollmsgs[0].Images)
+
+	// Output:
+	// [{"type":"text","text":"What is in these images?"},{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0KGgo="}}]
+	// Image url https://example.com/cat.png can not be loaded!
+	// What is in these images? [iVBORw0KGgo=]
+}
+
+func ExampleOpenAi_azure() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.URL.Path, r.URL.Query().Get("api-version"), r.Header.Get("api-key"), r.Header.Get("Authorization") == "")
+		fmt.Fprint(w, `{"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"}}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`)
+	}))
+	defer server.Close()
+
+	azure := &llm.OpenAi{
+		ApiVendor      : "azure",
+		ApiBase        : server.URL,
+		ApiKey         : "test",
+		Model          : "gpt-4o",
+		DeploymentWeak : "gpt-4o-mini-eu",
+	}
+	err := azure.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	msgs := []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Hi!" },
+	}
+	status, msg := azure.SendMessages(context.Background(), msgs)
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content)
+	azure.SendMessagesByWeakModel(context.Background(), msgs)
+
+	// Output:
+	// /openai/deployments/gpt-4o/chat/completions 2024-10-21 test true
+	// true Hello!
+	// /openai/deployments/gpt-4o-mini-eu/chat/completions 2024-10-21 test true
+}
+
+func ExampleOpenAi_ListModels() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.Method, r.URL.Path)
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"gpt-4o","object":"model","owned_by":"system"},{"id":"text-embedding-3-small","object":"model","owned_by":"system"}]}`)
+	}))
+	defer server.Close()
+
+	openai := &llm.OpenAi{ ApiBase: server.URL, ApiKey: "test", Model: "gpt-4o", MaxTokens: 100000 }
+	err := openai.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	fmt.Println(openai.MaxTokens, openai.ModelInfo().ContextWindow)
+
+	infos, err := openai.ListModels(context.Background())
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	for _, info := range infos {
+		fmt.Println(info.Name, info.ContextWindow, info.EmbeddingDimensions)
+	}
+
+	// Output:
+	// 16384 128000
+	// GET /models
+	// gpt-4o 128000 0
+	// text-embedding-3-small 8191 1536
+}
@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"io"
+	"time"
+	"errors"
+	"context"
+	"strconv"
+	"net/http"
+	"math/rand"
+)
+
+const (
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 30 * time.Second
+)
+
+var (
+	defaultRetryStatusCodes = []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		529, // Overloaded
+	}
+)
+
+// RetryPolicy retries failed http requests with jittered exponential backoff,
+// a Retry-After header from the server is honored. Requests are never retried
+// once their context is done, and a stream is only retried before its body is
+// read, so no partial output is repeated.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// RetryStatusCodes are the http status codes to retry, 429 and 5xx by default
+	RetryStatusCodes []int
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{ MaxRetries: 3 }
+}
+
+func (p *RetryPolicy) retryStatus(code int) bool {
+	codes := p.RetryStatusCodes
+	if len(codes) <= 0 {
+		codes = defaultRetryStatusCodes
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay before retry attempt, starting at 0
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	maxdelay := p.MaxDelay
+	if maxdelay <= 0 {
+		maxdelay = defaultRetryMaxDelay
+	}
+	delay := base
+	for i := 0; i < attempt && delay < maxdelay; i++ {
+		delay *= 2
+	}
+	if delay > maxdelay {
+		delay = maxdelay
+	}
+	// Equal jitter, half fixed and half random
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half) + 1))
+}
+
+// RetryAfter parses the Retry-After header, in seconds or as an http date
+func RetryAfter(httpRsp *http.Response) time.Duration {
+	if httpRsp == nil {
+		return 0
+	}
+	value := httpRsp.Header.Get("Retry-After")
+	if len(value) <= 0 {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func sleepContext(cxt context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-cxt.Done():
+		return cxt.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// DoHttpRequest sends httpReq with httpClient, retrying by policy when it is
+// not nil. The response of the last attempt is returned even if its status
+// is an error, for the caller to check.
+func DoHttpRequest(httpClient *http.Client, httpReq *http.Request, policy *RetryPolicy) (*http.Response, error) {
+	if policy == nil || policy.MaxRetries <= 0 {
+		return httpClient.Do(httpReq)
+	}
+	cxt := httpReq.Context()
+	req := httpReq
+	for attempt := 0; ; attempt++ {
+		httpRsp, err := httpClient.Do(req)
+		last := attempt >= policy.MaxRetries
+		if err != nil {
+			if last || cxt.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return nil, err
+			}
+		} else if last || !policy.retryStatus(httpRsp.StatusCode) {
+			return httpRsp, nil
+		}
+
+		if httpReq.Body != nil && httpReq.GetBody == nil {
+			// The body can not be sent again
+			if err != nil {
+				return nil, err
+			}
+			return httpRsp, nil
+		}
+
+		delay := policy.Backoff(attempt)
+		if after := RetryAfter(httpRsp); after > delay {
+			delay = after
+		}
+		if httpRsp != nil {
+			io.CopyN(io.Discard, httpRsp.Body, 64 * 1024)
+			httpRsp.Body.Close()
+		}
+		if serr := sleepContext(cxt, delay); serr != nil {
+			return nil, serr
+		}
+
+		req = httpReq.Clone(cxt)
+		if httpReq.GetBody != nil {
+			body, berr := httpReq.GetBody()
+			if berr != nil {
+				return nil, berr
+			}
+			req.Body = body
+		}
+	}
+}
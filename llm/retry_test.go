@@ -0,0 +1,44 @@
+package llm_test
+
+import (
+	"fmt"
+	"time"
+	"net/http"
+	"net/http/httptest"
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleRetryPolicy() {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"Rate limit reached","type":"requests"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"model":"gemma:2b","message":{"role":"assistant","content":"Hi!"},"done":true}`)
+	}))
+	defer server.Close()
+
+	ollama := &llm.Ollama{
+		ApiBase: server.URL,
+		Retry: &llm.RetryPolicy{ MaxRetries: 3, BaseDelay: time.Millisecond },
+	}
+	err := ollama.InitLLM()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	status, msg := ollama.SendMessages(context.Background(), []autog.ChatMessage{
+		{ Role: autog.ROLE_USER, Content: "Hello!" },
+	})
+	fmt.Println(status, msg.Content, attempts)
+
+	// Output:
+	// OK Hi! 3
+}
@@ -0,0 +1,294 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"context"
+	"github.com/autogorg/autog"
+)
+
+type RouteCall int
+
+const (
+	RouteCallChat RouteCall = iota
+	RouteCallChatWeak
+	RouteCallEmbedding
+)
+
+type RouteServe int
+
+const (
+	// RouteServeAll serves both the main and the weak model calls
+	RouteServeAll RouteServe = iota
+	RouteServeStrong
+	RouteServeWeak
+)
+
+// defaultFallbackOn are the statuses falling back when FallbackOn is empty
+var defaultFallbackOn = []autog.LLMStatus{ autog.LLM_STATUS_BED_RESPONSE, autog.LLM_STATUS_EXCEED_CONTEXT }
+
+type RouteBackend struct {
+	Name     string
+	LLM      autog.LLM
+	Embedder autog.EmbeddingModel
+	// Serve selects the chat calls served by this backend
+	Serve    RouteServe
+	// MaxPromptTokens skips this backend for larger prompts, 0 means no limit
+	MaxPromptTokens int
+}
+
+func (b *RouteBackend) accept(call RouteCall, cxt context.Context, msgs []autog.ChatMessage) bool {
+	if call == RouteCallEmbedding {
+		return b.Embedder != nil
+	}
+	if b.LLM == nil {
+		return false
+	}
+	if call == RouteCallChat && b.Serve == RouteServeWeak {
+		return false
+	}
+	if call == RouteCallChatWeak && b.Serve == RouteServeStrong {
+		return false
+	}
+	if b.MaxPromptTokens > 0 {
+		tokens := 0
+		for _, msg := range msgs {
+			if call == RouteCallChatWeak {
+				tokens += b.LLM.CalcTokensByWeakModel(cxt, msg.Text())
+			} else {
+				tokens += b.LLM.CalcTokens(cxt, msg.Text())
+			}
+		}
+		if tokens > b.MaxPromptTokens {
+			return false
+		}
+	}
+	return true
+}
+
+// Router is an LLM and EmbeddingModel composed of several backends, a call
+// is sent to the first accepting backend and falls back to the next one when
+// its status is in FallbackOn. Embeddings fall back on any error, the models
+// of the backends must produce compatible vectors.
+type Router struct {
+	Backends   []*RouteBackend
+	// FallbackOn defaults to LLM_STATUS_BED_RESPONSE and LLM_STATUS_EXCEED_CONTEXT
+	FallbackOn []autog.LLMStatus
+	// Route overrides the order of the backends tried for a call
+	Route      func (call RouteCall, msgs []autog.ChatMessage) []*RouteBackend
+	// OnServed reports which backend served each call
+	OnServed   func (call RouteCall, backend string, status autog.LLMStatus)
+}
+
+// InitLLM checks the router, the backends are initialized by the caller
+func (r *Router) InitLLM() error {
+	if len(r.Backends) <= 0 {
+		return fmt.Errorf("Router has no backends!")
+	}
+	if len(r.FallbackOn) <= 0 {
+		r.FallbackOn = defaultFallbackOn
+	}
+	return nil
+}
+
+func (r *Router) fallback(status autog.LLMStatus) bool {
+	fallbackOn := r.FallbackOn
+	if len(fallbackOn) <= 0 {
+		fallbackOn = defaultFallbackOn
+	}
+	for _, sts := range fallbackOn {
+		if sts == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) served(call RouteCall, backend string, status autog.LLMStatus) {
+	if r.OnServed != nil {
+		r.OnServed(call, backend, status)
+	}
+}
+
+func (r *Router) candidates(cxt context.Context, call RouteCall, msgs []autog.ChatMessage) []*RouteBackend {
+	backends := r.Backends
+	if r.Route != nil {
+		backends = r.Route(call, msgs)
+	}
+	var accepted []*RouteBackend
+	for _, b := range backends {
+		if b != nil && b.accept(call, cxt, msgs) {
+			accepted = append(accepted, b)
+		}
+	}
+	return accepted
+}
+
+func (r *Router) firstLLM() autog.LLM {
+	for _, b := range r.Backends {
+		if b.LLM != nil {
+			return b.LLM
+		}
+	}
+	return nil
+}
+
+func (r *Router) CalcTokens(cxt context.Context, content string) int {
+	if llm := r.firstLLM(); llm != nil {
+		return llm.CalcTokens(cxt, content)
+	}
+	return 0
+}
+
+func (r *Router) CalcTokensByWeakModel(cxt context.Context, content string) int {
+	if llm := r.firstLLM(); llm != nil {
+		return llm.CalcTokensByWeakModel(cxt, content)
+	}
+	return 0
+}
+
+func (r *Router) ModelInfo() autog.ModelInfo {
+	return autog.ModelInfoOf(r.firstLLM(), false)
+}
+
+func (r *Router) ModelInfoByWeakModel() autog.ModelInfo {
+	return autog.ModelInfoOf(r.firstLLM(), true)
+}
+
+func (r *Router) noBackend(call RouteCall) (autog.LLMStatus, autog.ChatMessage) {
+	err := fmt.Errorf("No backend accepts the call!")
+	r.served(call, "", autog.LLM_STATUS_BED_REQUEST)
+	return autog.LLM_STATUS_BED_REQUEST, autog.ChatMessage{Role:autog.ROLE_ASSISTANT, Content: err.Error(), Err: err}
+}
+
+func (r *Router) send(cxt context.Context, call RouteCall, msgs []autog.ChatMessage, send func (llm autog.LLM) (autog.LLMStatus, autog.ChatMessage)) (autog.LLMStatus, autog.ChatMessage) {
+	backends := r.candidates(cxt, call, msgs)
+	if len(backends) <= 0 {
+		return r.noBackend(call)
+	}
+	var status autog.LLMStatus
+	var msg autog.ChatMessage
+	for i, b := range backends {
+		status, msg = send(b.LLM)
+		if status == autog.LLM_STATUS_OK || !r.fallback(status) || i == len(backends) - 1 {
+			r.served(call, b.Name, status)
+			break
+		}
+	}
+	return status, msg
+}
+
+func (r *Router) sendStream(cxt context.Context, call RouteCall, msgs []autog.ChatMessage, reader autog.StreamReader, send func (llm autog.LLM, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage)) (autog.LLMStatus, autog.ChatMessage) {
+	backends := r.candidates(cxt, call, msgs)
+	if len(backends) <= 0 {
+		return r.noBackend(call)
+	}
+	rreader := &routeStreamReader{ reader: reader }
+	var status autog.LLMStatus
+	var msg autog.ChatMessage
+	for i, b := range backends {
+		rreader.pending = false
+		status, msg = send(b.LLM, rreader)
+		// Once a delta reached the reader, another backend would repeat the output
+		if status == autog.LLM_STATUS_OK || !r.fallback(status) || rreader.delivered || i == len(backends) - 1 {
+			r.served(call, b.Name, status)
+			break
+		}
+	}
+	rreader.finish()
+	return status, msg
+}
+
+func (r *Router) SendMessages(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	return r.send(cxt, RouteCallChat, msgs, func (llm autog.LLM) (autog.LLMStatus, autog.ChatMessage) {
+		return llm.SendMessages(cxt, msgs)
+	})
+}
+
+func (r *Router) SendMessagesStream(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return r.sendStream(cxt, RouteCallChat, msgs, reader, func (llm autog.LLM, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+		return llm.SendMessagesStream(cxt, msgs, reader)
+	})
+}
+
+func (r *Router) SendMessagesByWeakModel(cxt context.Context, msgs []autog.ChatMessage) (autog.LLMStatus, autog.ChatMessage) {
+	return r.send(cxt, RouteCallChatWeak, msgs, func (llm autog.LLM) (autog.LLMStatus, autog.ChatMessage) {
+		return llm.SendMessagesByWeakModel(cxt, msgs)
+	})
+}
+
+func (r *Router) SendMessagesStreamByWeakModel(cxt context.Context, msgs []autog.ChatMessage, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+	return r.sendStream(cxt, RouteCallChatWeak, msgs, reader, func (llm autog.LLM, reader autog.StreamReader) (autog.LLMStatus, autog.ChatMessage) {
+		return llm.SendMessagesStreamByWeakModel(cxt, msgs, reader)
+	})
+}
+
+func (r *Router) Embeddings(cxt context.Context, dimensions int, texts []string) ([]autog.Embedding, error) {
+	backends := r.candidates(cxt, RouteCallEmbedding, nil)
+	if len(backends) <= 0 {
+		r.served(RouteCallEmbedding, "", autog.LLM_STATUS_BED_REQUEST)
+		return nil, fmt.Errorf("No backend accepts the call!")
+	}
+	var embeds []autog.Embedding
+	var err error
+	for _, b := range backends {
+		embeds, err = b.Embedder.Embeddings(cxt, dimensions, texts)
+		if err == nil {
+			r.served(RouteCallEmbedding, b.Name, autog.LLM_STATUS_OK)
+			return embeds, nil
+		}
+	}
+	r.served(RouteCallEmbedding, backends[len(backends)-1].Name, autog.LLM_STATUS_BED_RESPONSE)
+	return embeds, err
+}
+
+// routeStreamReader forwards one stream to reader across backends, the
+// start is forwarded once, and the error of a backend is held back until
+// it is known that no other backend takes over.
+type routeStreamReader struct {
+	reader    autog.StreamReader
+	buf       *strings.Builder
+	delivered bool
+	pending   bool
+	status    autog.LLMStatus
+	errstr    string
+}
+
+func (rr *routeStreamReader) StreamStart() *strings.Builder {
+	if rr.buf == nil {
+		if rr.reader != nil {
+			rr.buf = rr.reader.StreamStart()
+		}
+		if rr.buf == nil {
+			rr.buf = &strings.Builder{}
+		}
+	}
+	return rr.buf
+}
+
+func (rr *routeStreamReader) StreamDelta(contentbuf *strings.Builder, delta string) {
+	rr.delivered = true
+	if rr.reader != nil {
+		rr.reader.StreamDelta(contentbuf, delta)
+	}
+}
+
+func (rr *routeStreamReader) StreamError(contentbuf *strings.Builder, status autog.LLMStatus, errstr string) {
+	rr.pending = true
+	rr.status  = status
+	rr.errstr  = errstr
+}
+
+func (rr *routeStreamReader) StreamEnd(contentbuf *strings.Builder) {
+}
+
+func (rr *routeStreamReader) finish() {
+	if rr.reader == nil {
+		return
+	}
+	buf := rr.StreamStart()
+	if rr.pending {
+		rr.reader.StreamError(buf, rr.status, rr.errstr)
+	}
+	rr.reader.StreamEnd(buf)
+}
@@ -0,0 +1,164 @@
+package llm_test
+
+import (
+	"fmt"
+	"errors"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleRouter() {
+	primary := &llm.Mock{ Model: "primary", Responses: []llm.MockResponse{
+		{ Status: autog.LLM_STATUS_EXCEED_CONTEXT, Content: "context length exceeded" },
+		{ Status: autog.LLM_STATUS_BED_REQUEST, Content: "bad request" },
+	} }
+	secondary := &llm.Mock{ Model: "secondary", Reply: func (msgs []autog.ChatMessage) llm.MockResponse {
+		return llm.MockResponse{ Content: "from secondary" }
+	} }
+	primary.InitLLM()
+	secondary.InitLLM()
+
+	// A literal router falls back on the default statuses without InitLLM
+	router := &llm.Router{
+		Backends : []*llm.RouteBackend{
+			{ Name: "primary", LLM: primary },
+			{ Name: "secondary", LLM: secondary },
+		},
+		OnServed : func (call llm.RouteCall, backend string, status autog.LLMStatus) {
+			fmt.Println("served", call, backend, status == autog.LLM_STATUS_OK)
+		},
+	}
+	msgs := []autog.ChatMessage{ { Role: autog.ROLE_USER, Content: "Hi!" } }
+
+	status, msg := router.SendMessages(context.Background(), msgs)
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content)
+
+	// A status out of FallbackOn is returned as is
+	status, msg = router.SendMessages(context.Background(), msgs)
+	fmt.Println(status == autog.LLM_STATUS_BED_REQUEST, msg.Content)
+
+	// Route orders the backends, e.g. the weak calls to the secondary only
+	router.Route = func (call llm.RouteCall, msgs []autog.ChatMessage) []*llm.RouteBackend {
+		if call == llm.RouteCallChatWeak {
+			return router.Backends[1:]
+		}
+		return router.Backends
+	}
+	status, msg = router.SendMessagesByWeakModel(context.Background(), msgs)
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content, len(primary.Calls()))
+
+	// Output:
+	// served 0 secondary true
+	// true from secondary
+	// served 0 primary false
+	// true bad request
+	// served 1 secondary true
+	// true from secondary 2
+}
+
+func ExampleRouter_stream() {
+	primary := &llm.Mock{ Responses: []llm.MockResponse{
+		// Fails before any delta, the secondary takes over
+		{ Status: autog.LLM_STATUS_BED_RESPONSE, Content: "unavailable" },
+		// Fails after a delta, the secondary would repeat the output
+		{ Status: autog.LLM_STATUS_BED_RESPONSE, Content: "broken stream", Deltas: []string{ "par" } },
+	} }
+	secondary := &llm.Mock{ ChunkSize: 4, Reply: func (msgs []autog.ChatMessage) llm.MockResponse {
+		return llm.MockResponse{ Content: "complete" }
+	} }
+	primary.InitLLM()
+	secondary.InitLLM()
+
+	router := &llm.Router{
+		Backends : []*llm.RouteBackend{
+			{ Name: "primary", LLM: primary },
+			{ Name: "secondary", LLM: secondary },
+		},
+		OnServed : func (call llm.RouteCall, backend string, status autog.LLMStatus) {
+			fmt.Println("served", backend)
+		},
+	}
+	msgs := []autog.ChatMessage{ { Role: autog.ROLE_USER, Content: "Hi!" } }
+
+	status, msg := router.SendMessagesStream(context.Background(), msgs, printReader{})
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content)
+
+	status, msg = router.SendMessagesStream(context.Background(), msgs, printReader{})
+	fmt.Println(status == autog.LLM_STATUS_BED_RESPONSE, msg.Content)
+
+	// Output:
+	// [comp][lete]served secondary
+	//
+	// true complete
+	// [par]served primary
+	// broken stream
+	// true broken stream
+}
+
+func ExampleRouter_Embeddings() {
+	failing := &llm.Mock{ EmbeddingError: errors.New("embedding down") }
+	working := &llm.Mock{ Dimensions: 3 }
+	failing.InitLLM()
+	working.InitLLM()
+
+	router := &llm.Router{
+		Backends : []*llm.RouteBackend{
+			{ Name: "chat", LLM: failing },
+			{ Name: "failing", Embedder: failing },
+			{ Name: "working", Embedder: working },
+		},
+		OnServed : func (call llm.RouteCall, backend string, status autog.LLMStatus) {
+			fmt.Println("served", call == llm.RouteCallEmbedding, backend, status == autog.LLM_STATUS_OK)
+		},
+	}
+	embeds, err := router.Embeddings(context.Background(), 0, []string{ "hello", "world" })
+	fmt.Println(len(embeds), len(embeds[0]), err)
+
+	working.EmbeddingError = errors.New("embedding down too")
+	_, err = router.Embeddings(context.Background(), 0, []string{ "hello" })
+	fmt.Println(err)
+
+	// Output:
+	// served true working true
+	// 2 3 <nil>
+	// served true working false
+	// embedding down too
+}
+
+func ExampleRouter_contextLength() {
+	openaiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"This model's maximum context length is 8192 tokens.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`)
+	}))
+	defer openaiServer.Close()
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"model":"llama3.1","message":{"role":"assistant","content":"from ollama"},"done":true}`)
+	}))
+	defer ollamaServer.Close()
+
+	openai := &llm.OpenAi{ ApiBase: openaiServer.URL, ApiKey: "key" }
+	ollama := &llm.Ollama{ ApiBase: ollamaServer.URL, Model: "llama3.1" }
+	openai.InitLLM()
+	ollama.InitLLM()
+
+	// OpenAI reports the long prompt as LLM_STATUS_EXCEED_CONTEXT
+	msgs := []autog.ChatMessage{ { Role: autog.ROLE_USER, Content: "A long prompt" } }
+	status, _ := openai.SendMessages(context.Background(), msgs)
+	fmt.Println(status == autog.LLM_STATUS_EXCEED_CONTEXT)
+
+	router := &llm.Router{
+		Backends : []*llm.RouteBackend{
+			{ Name: "openai", LLM: openai },
+			{ Name: "ollama", LLM: ollama },
+		},
+	}
+	status, msg := router.SendMessages(context.Background(), msgs)
+	fmt.Println(status == autog.LLM_STATUS_OK, msg.Content)
+
+	// Output:
+	// true
+	// true from ollama
+}
@@ -0,0 +1,155 @@
+package autog
+
+import (
+	"sync"
+	"context"
+	"strings"
+)
+
+// ModelInfo are the capabilities of a model, a zero field is unknown
+type ModelInfo struct {
+	Name   string
+	Vendor string
+	// ContextWindow is the tokens of the prompt and the output together
+	ContextWindow       int
+	MaxOutputTokens     int
+	EmbeddingDimensions int
+	Tools  bool
+	Vision bool
+	// Tokenizer is the name of the BPE encoding, e.g. cl100k_base
+	Tokenizer string
+}
+
+// Merge returns a copy of i with the known fields of over replacing its own
+func (i ModelInfo) Merge(over ModelInfo) ModelInfo {
+	if len(over.Name) > 0 {
+		i.Name = over.Name
+	}
+	if len(over.Vendor) > 0 {
+		i.Vendor = over.Vendor
+	}
+	if over.ContextWindow > 0 {
+		i.ContextWindow = over.ContextWindow
+	}
+	if over.MaxOutputTokens > 0 {
+		i.MaxOutputTokens = over.MaxOutputTokens
+	}
+	if over.EmbeddingDimensions > 0 {
+		i.EmbeddingDimensions = over.EmbeddingDimensions
+	}
+	i.Tools  = i.Tools || over.Tools
+	i.Vision = i.Vision || over.Vision
+	if len(over.Tokenizer) > 0 {
+		i.Tokenizer = over.Tokenizer
+	}
+	return i
+}
+
+// ModelLister is implemented by providers that can list the models of their server
+type ModelLister interface {
+	ListModels(cxt context.Context) ([]ModelInfo, error)
+}
+
+// ModelInfoProvider is implemented by LLMs knowing the capabilities of their models
+type ModelInfoProvider interface {
+	ModelInfo() ModelInfo
+	ModelInfoByWeakModel() ModelInfo
+}
+
+// ModelInfoOf returns the capabilities of the main or weak model of llm, a
+// zero ModelInfo if llm does not know them.
+func ModelInfoOf(llm LLM, weak bool) ModelInfo {
+	provider, ok := llm.(ModelInfoProvider)
+	if !ok {
+		return ModelInfo{}
+	}
+	if weak {
+		return provider.ModelInfoByWeakModel()
+	}
+	return provider.ModelInfo()
+}
+
+// ModelRegistry records the capabilities of models by name prefix, e.g.
+// "gpt-4o" also matches "gpt-4o-2024-08-06", longer prefixes win.
+type ModelRegistry struct {
+	mutex  sync.RWMutex
+	models map[string]ModelInfo
+}
+
+func NewModelRegistry(infos ...ModelInfo) *ModelRegistry {
+	r := &ModelRegistry{ models: map[string]ModelInfo{} }
+	for _, info := range infos {
+		r.Register(info)
+	}
+	return r
+}
+
+// Register records info under the prefix info.Name, replacing the previous one
+func (r *ModelRegistry) Register(info ModelInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.models[info.Name] = info
+}
+
+// Lookup returns the capabilities of model with model as Name, ok is false
+// when no prefix matches.
+func (r *ModelRegistry) Lookup(model string) (info ModelInfo, ok bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	prefix := ""
+	for p, i := range r.models {
+		if strings.HasPrefix(model, p) && (!ok || len(p) > len(prefix)) {
+			info, prefix, ok = i, p, true
+		}
+	}
+	info.Name = model
+	return info, ok
+}
+
+// Models is the registry used by the providers, RegisterModelInfo adds or
+// corrects models in it.
+var Models = NewModelRegistry(
+	ModelInfo{ Name: "gpt-4o", Vendor: "openai", ContextWindow: 128000, MaxOutputTokens: 16384, Tools: true, Vision: true, Tokenizer: "o200k_base" },
+	ModelInfo{ Name: "gpt-4.1", Vendor: "openai", ContextWindow: 1047576, MaxOutputTokens: 32768, Tools: true, Vision: true, Tokenizer: "o200k_base" },
+	ModelInfo{ Name: "gpt-4-turbo", Vendor: "openai", ContextWindow: 128000, MaxOutputTokens: 4096, Tools: true, Vision: true, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "gpt-4-turbo-preview", Vendor: "openai", ContextWindow: 128000, MaxOutputTokens: 4096, Tools: true, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "gpt-4-32k", Vendor: "openai", ContextWindow: 32768, MaxOutputTokens: 32768, Tools: true, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "gpt-4", Vendor: "openai", ContextWindow: 8192, MaxOutputTokens: 8192, Tools: true, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "gpt-3.5-turbo", Vendor: "openai", ContextWindow: 16385, MaxOutputTokens: 4096, Tools: true, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "o1", Vendor: "openai", ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true, Tokenizer: "o200k_base" },
+	ModelInfo{ Name: "o3", Vendor: "openai", ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true, Tokenizer: "o200k_base" },
+	ModelInfo{ Name: "o4-mini", Vendor: "openai", ContextWindow: 200000, MaxOutputTokens: 100000, Tools: true, Vision: true, Tokenizer: "o200k_base" },
+	ModelInfo{ Name: "text-embedding-3-large", Vendor: "openai", ContextWindow: 8191, EmbeddingDimensions: 3072, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "text-embedding-3-small", Vendor: "openai", ContextWindow: 8191, EmbeddingDimensions: 1536, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "text-embedding-ada-002", Vendor: "openai", ContextWindow: 8191, EmbeddingDimensions: 1536, Tokenizer: "cl100k_base" },
+	ModelInfo{ Name: "claude-3-5-sonnet", Vendor: "anthropic", ContextWindow: 200000, MaxOutputTokens: 8192, Tools: true, Vision: true },
+	ModelInfo{ Name: "claude-3-5-haiku", Vendor: "anthropic", ContextWindow: 200000, MaxOutputTokens: 8192, Tools: true },
+	ModelInfo{ Name: "claude-3-7-sonnet", Vendor: "anthropic", ContextWindow: 200000, MaxOutputTokens: 64000, Tools: true, Vision: true },
+	ModelInfo{ Name: "claude-3-opus", Vendor: "anthropic", ContextWindow: 200000, MaxOutputTokens: 4096, Tools: true, Vision: true },
+	ModelInfo{ Name: "claude-3-haiku", Vendor: "anthropic", ContextWindow: 200000, MaxOutputTokens: 4096, Tools: true, Vision: true },
+	ModelInfo{ Name: "claude-sonnet-4", Vendor: "anthropic", ContextWindow: 200000, MaxOutputTokens: 64000, Tools: true, Vision: true },
+	ModelInfo{ Name: "claude-opus-4", Vendor: "anthropic", ContextWindow: 200000, MaxOutputTokens: 32000, Tools: true, Vision: true },
+	ModelInfo{ Name: "gemini-1.5-pro", Vendor: "google", ContextWindow: 2097152, MaxOutputTokens: 8192, Tools: true, Vision: true },
+	ModelInfo{ Name: "gemini-1.5-flash", Vendor: "google", ContextWindow: 1048576, MaxOutputTokens: 8192, Tools: true, Vision: true },
+	ModelInfo{ Name: "gemini-2.0-flash", Vendor: "google", ContextWindow: 1048576, MaxOutputTokens: 8192, Tools: true, Vision: true },
+	ModelInfo{ Name: "gemini-2.5", Vendor: "google", ContextWindow: 1048576, MaxOutputTokens: 65536, Tools: true, Vision: true },
+	ModelInfo{ Name: "text-embedding-004", Vendor: "google", ContextWindow: 2048, EmbeddingDimensions: 768 },
+	ModelInfo{ Name: "gemma:2b", Vendor: "ollama", ContextWindow: 8192 },
+	ModelInfo{ Name: "llama3.1", Vendor: "ollama", ContextWindow: 131072, Tools: true },
+	ModelInfo{ Name: "llama3.2", Vendor: "ollama", ContextWindow: 131072, Tools: true },
+	ModelInfo{ Name: "qwen2.5", Vendor: "ollama", ContextWindow: 32768, Tools: true },
+	ModelInfo{ Name: "mistral", Vendor: "ollama", ContextWindow: 32768, Tools: true },
+	ModelInfo{ Name: "llava", Vendor: "ollama", ContextWindow: 4096, Vision: true },
+	ModelInfo{ Name: "nomic-embed-text", Vendor: "ollama", ContextWindow: 8192, EmbeddingDimensions: 768 },
+	ModelInfo{ Name: "mxbai-embed-large", Vendor: "ollama", ContextWindow: 512, EmbeddingDimensions: 1024 },
+)
+
+// RegisterModelInfo records info in Models
+func RegisterModelInfo(info ModelInfo) {
+	Models.Register(info)
+}
+
+// LookupModelInfo returns the capabilities of model recorded in Models
+func LookupModelInfo(model string) (ModelInfo, bool) {
+	return Models.Lookup(model)
+}
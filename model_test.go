@@ -0,0 +1,43 @@
+package autog_test
+
+import (
+	"fmt"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleModelRegistry() {
+	info, ok := autog.LookupModelInfo("gpt-4o-mini-2024-07-18")
+	fmt.Println(ok, info.Name, info.ContextWindow, info.Tools, info.Vision, info.Tokenizer)
+
+	registry := autog.NewModelRegistry(autog.ModelInfo{ Name: "my-model", ContextWindow: 8192 })
+	registry.Register(autog.ModelInfo{ Name: "my-model-long", ContextWindow: 32768 })
+	info, ok = registry.Lookup("my-model-long-v2")
+	fmt.Println(ok, info.ContextWindow)
+	info, ok = registry.Lookup("my-model-v2")
+	fmt.Println(ok, info.ContextWindow)
+
+	_, ok = registry.Lookup("unknown")
+	fmt.Println(ok)
+
+	// The summary of the history is kept in the context of a small weak model
+	mock := &llm.Mock{ Info: autog.ModelInfo{ ContextWindow: 2048 } }
+	mock.InitLLM()
+	summary := &autog.Summary{ LLM: mock }
+	summary.InitSummary()
+	fmt.Println(summary.MinSummaryTokens)
+
+	// A larger one keeps the default
+	mock.Info.ContextWindow = 128000
+	summary = &autog.Summary{ LLM: mock }
+	summary.InitSummary()
+	fmt.Println(summary.MinSummaryTokens)
+
+	// Output:
+	// true gpt-4o-mini-2024-07-18 128000 true true o200k_base
+	// true 32768
+	// true 8192
+	// false
+	// 512
+	// 1024
+}
@@ -4,6 +4,21 @@ type PromptItem struct {
 	Name string
 	GetMessages func (query string) []ChatMessage
 	GetPrompt func (query string) (role string, prompt string)
+	// Options are the generation options of the calls using this prompt
+	Options *GenerationOptions
+	// Priority orders the truncation of ContextFit, higher is kept longer
+	Priority int
+	// MaxTokens caps the tokens of the item, MinTokens are kept by the
+	// truncation of ContextFit, 0 is no limit
+	MaxTokens int
+	MinTokens int
+	// Truncate is how the item is cut to its budget
+	Truncate TruncateStrategy
+
+	history historyKind
+	actions bool
+	// render is GetPrompt reporting its failure, set by PromptTemplate
+	render func (query string) (role string, prompt string, err error)
 }
 
 func (pi *PromptItem) doGetMessages(query string) []ChatMessage {
@@ -18,4 +33,12 @@ func (pi *PromptItem) doGetPrompt(query string) (role string, prompt string) {
 		return "", ""
 	}
 	return pi.GetPrompt(query)
+}
+
+func (pi *PromptItem) renderPrompt(query string) (role string, prompt string, err error) {
+	if pi.render == nil {
+		role, prompt = pi.doGetPrompt(query)
+		return role, prompt, nil
+	}
+	return pi.render(query)
 }
\ No newline at end of file
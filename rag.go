@@ -3,6 +3,7 @@ package autog
 import (
 	"fmt"
 	"sync"
+	"errors"
 	"context"
 	"strings"
 )
@@ -85,7 +86,12 @@ type Rag struct {
 	EmbeddingBatch int
 	EmbeddingRoutines int
 	EmbeddingDimensions int
+	// EmbeddingCallback is called after each batch texts[i:j] and retries it
+	// when returning true, err is an *EmbeddingError when only some texts of
+	// the batch failed, its Failed indexes are relative to i.
 	EmbeddingCallback  func (stage EmbeddingStage, texts []string, embeds []Embedding, i, j int, finished, tried int, err error) bool
+	// Usage aggregates the token usage of the embedding calls
+	Usage *UsageMeter
 }
 
 func (r *Rag) Embeddings(cxt context.Context, stage EmbeddingStage, texts []string) ([]Embedding, error) {
@@ -109,6 +115,11 @@ func (r *Rag) Embeddings(cxt context.Context, stage EmbeddingStage, texts []stri
 		dimensions = r.EmbeddingDimensions
 	}
 
+	if r.Usage == nil {
+		r.Usage = &UsageMeter{}
+	}
+	cxt = WithUsageRecorder(cxt, r.Usage)
+
 	finished := 0
 	// Create slots
 	concurrents := make(chan struct{}, routines)
@@ -136,17 +147,28 @@ func (r *Rag) Embeddings(cxt context.Context, stage EmbeddingStage, texts []stri
 			for {
 				es, eerr := r.EmbeddingModel.Embeddings(cxt, dimensions, qtexts)
 				mutex.Lock()
-				if eerr != nil {
-					err = eerr
-				} else {
+				done := 0
+				var partial *EmbeddingError
+				if eerr == nil {
 					for x := i; x < j; x++ {
 						embeds[x] = es[x - i]
 					}
-					finished = finished + (j - i)
+					done = j - i
+				} else if errors.As(eerr, &partial) && len(es) == j - i {
+					// Keep the embedded texts of a partial failure
+					for x := i; x < j; x++ {
+						if es[x - i] != nil {
+							embeds[x] = es[x - i]
+							done++
+						}
+					}
 				}
+				finished = finished + done
 				retry = r.doEmbeddingCallback(stage, texts, embeds, i, j, finished, tried, eerr)
-				if retry && eerr == nil {
-					finished = finished - (j - i)
+				if retry {
+					finished = finished - done
+				} else if eerr != nil {
+					err = eerr
 				}
 				mutex.Unlock()
 				if retry {
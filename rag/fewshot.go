@@ -0,0 +1,213 @@
+package rag
+
+import (
+	"fmt"
+	"sort"
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+const (
+	defaultFewShotPath  = "fewshot"
+	defaultFewShotK     = 3
+	defaultFewShotFetch = 4
+)
+
+// FewShotSelector picks the examples most relevant to the query from the
+// examples indexed in Rag, each example is embedded by its Input.
+type FewShotSelector struct {
+	Rag  *autog.Rag
+	// Path is the document path of the examples, "fewshot" if empty
+	Path string
+	// K is the number of examples picked, 3 if not set
+	K int
+	// Fetch is the number of candidates picked from, 4*K if not set
+	Fetch int
+	// Diversity trades relevance against diversity by MMR, 0 is relevance
+	// only and 1 diversity only
+	Diversity float64
+	// MaxTokens caps the tokens of the example messages, counted by LLM or
+	// estimated if LLM is nil. The examples over the cap are skipped while
+	// picking, so a shorter candidate takes their place.
+	MaxTokens int
+	LLM autog.LLM
+	// Context of the retrieval, context.Background() if nil
+	Context context.Context
+}
+
+type fewShotSplitter struct{}
+
+func (s fewShotSplitter) GetParser() autog.ParserFunction {
+	return func (path string, payload interface{}) ([]autog.Chunk, error) {
+		examples, ok := payload.([]autog.FewShotExample)
+		if !ok {
+			return nil, fmt.Errorf("Few-shot examples are needed!")
+		}
+		chunks := make([]autog.Chunk, len(examples))
+		for i, example := range examples {
+			chunks[i] = &MemChunk{
+				Index   : i,
+				Path    : path,
+				Query   : example.Input,
+				Content : example.Input,
+				Payload : example.Output,
+			}
+		}
+		return chunks, nil
+	}
+}
+
+func (s *FewShotSelector) path() string {
+	if len(s.Path) <= 0 {
+		return defaultFewShotPath
+	}
+	return s.Path
+}
+
+// Indexing embeds the inputs of examples and saves them in the database of Rag
+func (s *FewShotSelector) Indexing(cxt context.Context, examples []autog.FewShotExample, overwrite bool) error {
+	return s.Rag.Indexing(cxt, s.path(), examples, fewShotSplitter{}, overwrite)
+}
+
+// Select returns the examples for query, the most relevant first
+func (s *FewShotSelector) Select(cxt context.Context, query string) ([]autog.FewShotExample, error) {
+	k := s.K
+	if k <= 0 {
+		k = defaultFewShotK
+	}
+	fetch := s.Fetch
+	if fetch < k {
+		fetch = k * defaultFewShotFetch
+	}
+	// Relevance only without a cap picks the top k
+	if s.Diversity <= 0 && s.MaxTokens <= 0 {
+		fetch = k
+	}
+	scoredss, err := s.Rag.Retrieval(cxt, s.path(), []string{ query }, fetch)
+	if err != nil {
+		return nil, err
+	}
+	var candidates autog.ScoredChunks
+	if len(scoredss) > 0 {
+		candidates = append(candidates, scoredss[0]...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	var fits func (scored *autog.ScoredChunk) bool
+	if s.MaxTokens > 0 {
+		tokens := 0
+		fits = func (scored *autog.ScoredChunk) bool {
+			etokens := s.countTokens(cxt, fewShotExample(scored))
+			if tokens + etokens > s.MaxTokens {
+				return false
+			}
+			tokens += etokens
+			return true
+		}
+	}
+	var examples []autog.FewShotExample
+	for _, scored := range pickMMR(candidates, k, s.Diversity, fits) {
+		examples = append(examples, fewShotExample(scored))
+	}
+	return examples, nil
+}
+
+func fewShotExample(scored *autog.ScoredChunk) autog.FewShotExample {
+	output, _ := scored.Chunk.GetPayload().(string)
+	return autog.FewShotExample{ Input: scored.Chunk.GetContent(), Output: output }
+}
+
+func (s *FewShotSelector) countTokens(cxt context.Context, example autog.FewShotExample) int {
+	if s.LLM == nil {
+		estimator := tokenizer.Estimator{}
+		return 2 * autog.MessageOverheadTokens + estimator.Count(example.Input) + estimator.Count(example.Output)
+	}
+	user      := autog.ChatMessage{ Role: autog.ROLE_USER, Content: example.Input }
+	assistant := autog.ChatMessage{ Role: autog.ROLE_ASSISTANT, Content: example.Output }
+	return autog.MessageTokens(cxt, s.LLM, user) + autog.MessageTokens(cxt, s.LLM, assistant)
+}
+
+// PromptItem returns a prompt item of the examples for the query, as
+// alternating user and assistant messages. A failed retrieval gives none.
+func (s *FewShotSelector) PromptItem() *autog.PromptItem {
+	return &autog.PromptItem{
+		Name : "fewshot",
+		GetMessages : func (query string) []autog.ChatMessage {
+			cxt := s.Context
+			if cxt == nil {
+				cxt = context.Background()
+			}
+			examples, err := s.Select(cxt, query)
+			if err != nil {
+				return []autog.ChatMessage{}
+			}
+			msgs := make([]autog.ChatMessage, 0, 2 * len(examples))
+			for _, example := range examples {
+				msgs = append(msgs,
+					autog.ChatMessage{ Role: autog.ROLE_USER, Content: example.Input },
+					autog.ChatMessage{ Role: autog.ROLE_ASSISTANT, Content: example.Output },
+				)
+			}
+			return msgs
+		},
+	}
+}
+
+func cosine(a, b autog.Embedding) float64 {
+	na, nb := Norm(a), Norm(b)
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return DotProduct(a, b) / (na * nb)
+}
+
+// MMR picks k of candidates sorted by score, by maximal marginal relevance:
+// each pick maximizes (1-diversity)*score - diversity*(similarity to the picked).
+func MMR(candidates autog.ScoredChunks, k int, diversity float64) autog.ScoredChunks {
+	return pickMMR(candidates, k, diversity, nil)
+}
+
+// pickMMR is MMR skipping the candidates fits rejects, fits is called once
+// for each pick in order and may be nil.
+func pickMMR(candidates autog.ScoredChunks, k int, diversity float64, fits func (scored *autog.ScoredChunk) bool) autog.ScoredChunks {
+	if fits == nil && (diversity <= 0 || len(candidates) <= k) {
+		return candidates[:min(k, len(candidates))]
+	}
+	var picked autog.ScoredChunks
+	if diversity <= 0 {
+		for _, c := range candidates {
+			if len(picked) >= k {
+				break
+			}
+			if fits(c) {
+				picked = append(picked, c)
+			}
+		}
+		return picked
+	}
+	remaining := append(autog.ScoredChunks{}, candidates...)
+	for len(picked) < k && len(remaining) > 0 {
+		best, bestScore := 0, 0.0
+		for i, c := range remaining {
+			similarity := 0.0
+			for j, p := range picked {
+				sim := cosine(c.Chunk.GetEmbedding(), p.Chunk.GetEmbedding())
+				if j == 0 || sim > similarity {
+					similarity = sim
+				}
+			}
+			score := (1 - diversity) * c.Score - diversity * similarity
+			if i == 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if fits == nil || fits(remaining[best]) {
+			picked = append(picked, remaining[best])
+		}
+		remaining = append(remaining[:best], remaining[best + 1:]...)
+	}
+	return picked
+}
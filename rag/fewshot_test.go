@@ -0,0 +1,62 @@
+package rag_test
+
+import (
+	"fmt"
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+	"github.com/autogorg/autog/rag"
+)
+
+func ExampleFewShotSelector() {
+	mock := &llm.Mock{}
+	mock.InitLLM()
+	db, _ := rag.NewMemDatabase()
+	selector := &rag.FewShotSelector{
+		Rag : &autog.Rag{ Database: db, EmbeddingModel: mock },
+		K   : 2,
+	}
+	err := selector.Indexing(context.Background(), []autog.FewShotExample{
+		{ Input: "what is the weather in paris today", Output: "Sunny" },
+		{ Input: "what is the weather in paris now", Output: "Sunny now" },
+		{ Input: "what is the capital of france", Output: "Paris" },
+		{ Input: "how tall is the eiffel tower", Output: "330 m" },
+	}, true)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	// The most relevant examples, as user and assistant messages
+	item := selector.PromptItem()
+	for _, msg := range item.GetMessages("what is the weather in paris") {
+		fmt.Println(msg.Role, msg.Content)
+	}
+
+	// MMR skips the near duplicates
+	selector.Diversity = 0.7
+	examples, _ := selector.Select(context.Background(), "what is the weather in paris")
+	for _, example := range examples {
+		fmt.Println(example.Input)
+	}
+
+	// The examples over the token cap are skipped while picking, a less
+	// relevant but shorter one takes their place
+	selector.Diversity = 0
+	selector.MaxTokens = 34
+	selector.LLM = mock
+	examples, _ = selector.Select(context.Background(), "what is the weather in paris")
+	for _, example := range examples {
+		fmt.Println(example.Input)
+	}
+
+	// Output:
+	// user what is the weather in paris today
+	// assistant Sunny
+	// user what is the weather in paris now
+	// assistant Sunny now
+	// what is the weather in paris today
+	// how tall is the eiffel tower
+	// what is the weather in paris today
+	// what is the capital of france
+}
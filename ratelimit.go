@@ -0,0 +1,261 @@
+package autog
+
+import (
+	"sync"
+	"time"
+	"context"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+// RateLimiter is a token bucket limiting the requests and tokens per minute,
+// and the number of concurrent calls. Share one limiter between LLMs and
+// embedding models that draw from the same provider quota.
+type RateLimiter struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+	MaxConcurrent     int
+
+	mutex    sync.Mutex
+	inited   bool
+	requests float64
+	tokens   float64
+	last     time.Time
+	slots    chan struct{}
+}
+
+func (l *RateLimiter) init() {
+	if l.inited {
+		return
+	}
+	l.inited   = true
+	l.requests = float64(l.RequestsPerMinute)
+	l.tokens   = float64(l.TokensPerMinute)
+	l.last     = time.Now()
+	if l.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, l.MaxConcurrent)
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Minutes()
+	l.last = now
+	if l.RequestsPerMinute > 0 {
+		l.requests += elapsed * float64(l.RequestsPerMinute)
+		if l.requests > float64(l.RequestsPerMinute) {
+			l.requests = float64(l.RequestsPerMinute)
+		}
+	}
+	if l.TokensPerMinute > 0 {
+		l.tokens += elapsed * float64(l.TokensPerMinute)
+		if l.tokens > float64(l.TokensPerMinute) {
+			l.tokens = float64(l.TokensPerMinute)
+		}
+	}
+}
+
+// reserve takes one request and tokens from the buckets, or returns how
+// long to wait until they are refilled.
+func (l *RateLimiter) reserve(tokens int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.init()
+	l.refill(time.Now())
+
+	need := float64(tokens)
+	if l.TokensPerMinute > 0 && need > float64(l.TokensPerMinute) {
+		// A call larger than the bucket waits for a full bucket
+		need = float64(l.TokensPerMinute)
+	}
+
+	var wait float64
+	if l.RequestsPerMinute > 0 && l.requests < 1 {
+		wait = (1 - l.requests) / float64(l.RequestsPerMinute)
+	}
+	if l.TokensPerMinute > 0 && l.tokens < need {
+		if w := (need - l.tokens) / float64(l.TokensPerMinute); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return time.Duration(wait * float64(time.Minute)) + time.Millisecond
+	}
+
+	if l.RequestsPerMinute > 0 {
+		l.requests -= 1
+	}
+	if l.TokensPerMinute > 0 {
+		l.tokens -= need
+	}
+	return 0
+}
+
+// Acquire waits until a call of tokens is allowed, the returned release
+// must be called when the call is done.
+func (l *RateLimiter) Acquire(cxt context.Context, tokens int) (release func(), err error) {
+	if cxt == nil {
+		cxt = context.Background()
+	}
+	if err := cxt.Err(); err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	l.init()
+	slots := l.slots
+	l.mutex.Unlock()
+
+	release = func() {}
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+			release = func() { <-slots }
+		case <-cxt.Done():
+			return nil, cxt.Err()
+		}
+	}
+
+	for {
+		wait := l.reserve(tokens)
+		if wait <= 0 {
+			return release, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-cxt.Done():
+			timer.Stop()
+			release()
+			return nil, cxt.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Consume takes tokens used beyond the estimate of Acquire, e.g. the
+// completion tokens reported in the usage of a call.
+func (l *RateLimiter) Consume(tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.init()
+	l.refill(time.Now())
+	if l.TokensPerMinute > 0 {
+		l.tokens -= float64(tokens)
+	}
+}
+
+// Available returns the requests and tokens left in the buckets, 0 for an
+// unlimited one.
+func (l *RateLimiter) Available() (requests int, tokens int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.init()
+	l.refill(time.Now())
+	return int(l.requests), int(l.tokens)
+}
+
+// RateLimitedLLM calls LLM within the limits of Limiter, the tokens of a
+// call are counted by CalcTokens and settled by the usage of the response.
+type RateLimitedLLM struct {
+	LLM
+	Limiter *RateLimiter
+}
+
+func (r *RateLimitedLLM) countMessages(cxt context.Context, msgs []ChatMessage, weak bool) int {
+	tokens := 0
+	for _, msg := range msgs {
+		if weak {
+			tokens += r.LLM.CalcTokensByWeakModel(cxt, msg.Text())
+		} else {
+			tokens += r.LLM.CalcTokens(cxt, msg.Text())
+		}
+	}
+	return tokens
+}
+
+func (r *RateLimitedLLM) send(cxt context.Context, msgs []ChatMessage, weak bool, send func () (LLMStatus, ChatMessage)) (LLMStatus, ChatMessage) {
+	if r.Limiter == nil {
+		return send()
+	}
+	tokens := r.countMessages(cxt, msgs, weak)
+	release, err := r.Limiter.Acquire(cxt, tokens)
+	if err != nil {
+		return LLM_STATUS_USER_CANCELED, ChatMessage{ Role: ROLE_ASSISTANT, Content: err.Error(), Err: err }
+	}
+	defer release()
+	sts, msg := send()
+	if msg.Usage != nil {
+		r.Limiter.Consume(msg.Usage.PromptTokens + msg.Usage.CompletionTokens - tokens)
+	}
+	return sts, msg
+}
+
+func (r *RateLimitedLLM) SendMessages(cxt context.Context, msgs []ChatMessage) (LLMStatus, ChatMessage) {
+	return r.send(cxt, msgs, false, func () (LLMStatus, ChatMessage) {
+		return r.LLM.SendMessages(cxt, msgs)
+	})
+}
+
+func (r *RateLimitedLLM) SendMessagesStream(cxt context.Context, msgs []ChatMessage, reader StreamReader) (LLMStatus, ChatMessage) {
+	return r.send(cxt, msgs, false, func () (LLMStatus, ChatMessage) {
+		return r.LLM.SendMessagesStream(cxt, msgs, reader)
+	})
+}
+
+func (r *RateLimitedLLM) SendMessagesByWeakModel(cxt context.Context, msgs []ChatMessage) (LLMStatus, ChatMessage) {
+	return r.send(cxt, msgs, true, func () (LLMStatus, ChatMessage) {
+		return r.LLM.SendMessagesByWeakModel(cxt, msgs)
+	})
+}
+
+func (r *RateLimitedLLM) SendMessagesStreamByWeakModel(cxt context.Context, msgs []ChatMessage, reader StreamReader) (LLMStatus, ChatMessage) {
+	return r.send(cxt, msgs, true, func () (LLMStatus, ChatMessage) {
+		return r.LLM.SendMessagesStreamByWeakModel(cxt, msgs, reader)
+	})
+}
+
+func (r *RateLimitedLLM) ModelInfo() ModelInfo {
+	return ModelInfoOf(r.LLM, false)
+}
+
+func (r *RateLimitedLLM) ModelInfoByWeakModel() ModelInfo {
+	return ModelInfoOf(r.LLM, true)
+}
+
+// RateLimitedEmbeddingModel calls EmbeddingModel within the limits of Limiter,
+// the tokens are counted by CalcTokens of the model if it is also an LLM, or
+// estimated, and settled by the usage the model records.
+type RateLimitedEmbeddingModel struct {
+	EmbeddingModel
+	Limiter *RateLimiter
+}
+
+func (r *RateLimitedEmbeddingModel) countTexts(cxt context.Context, texts []string) int {
+	tokens := 0
+	llm, ok := r.EmbeddingModel.(LLM)
+	for _, text := range texts {
+		if ok {
+			tokens += llm.CalcTokens(cxt, text)
+		} else {
+			tokens += tokenizer.Estimator{}.Count(text)
+		}
+	}
+	return tokens
+}
+
+func (r *RateLimitedEmbeddingModel) Embeddings(cxt context.Context, dimensions int, texts []string) ([]Embedding, error) {
+	if r.Limiter == nil {
+		return r.EmbeddingModel.Embeddings(cxt, dimensions, texts)
+	}
+	tokens := r.countTexts(cxt, texts)
+	release, err := r.Limiter.Acquire(cxt, tokens)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	meter := &UsageMeter{}
+	embeds, err := r.EmbeddingModel.Embeddings(WithUsageRecorder(cxt, meter), dimensions, texts)
+	used := meter.Total()
+	r.Limiter.Consume(used.PromptTokens + used.CompletionTokens - tokens)
+	return embeds, err
+}
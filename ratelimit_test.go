@@ -0,0 +1,126 @@
+package autog_test
+
+import (
+	"fmt"
+	"time"
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleRateLimiter() {
+	limiter := &autog.RateLimiter{ RequestsPerMinute: 2, TokensPerMinute: 100 }
+
+	release, err := limiter.Acquire(context.Background(), 60)
+	fmt.Println(err)
+	release()
+	fmt.Println(limiter.Available())
+
+	// The tokens are refilled at 100 per minute, a call waits for them
+	cxt, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	_, err = limiter.Acquire(cxt, 60)
+	cancel()
+	fmt.Println(err)
+
+	release, err = limiter.Acquire(context.Background(), 30)
+	fmt.Println(err)
+	release()
+	requests, tokens := limiter.Available()
+	fmt.Println(requests, tokens)
+
+	// The requests are exhausted too
+	cxt, cancel = context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	_, err = limiter.Acquire(cxt, 1)
+	cancel()
+	fmt.Println(err)
+
+	// Consume takes the tokens used beyond the estimate
+	limiter.Consume(10)
+	_, tokens = limiter.Available()
+	fmt.Println(tokens)
+
+	// Output:
+	// <nil>
+	// 1 40
+	// context deadline exceeded
+	// <nil>
+	// 0 10
+	// context deadline exceeded
+	// 0
+}
+
+func ExampleRateLimiter_refill() {
+	// 1000 tokens per second
+	limiter := &autog.RateLimiter{ TokensPerMinute: 60000 }
+	release, _ := limiter.Acquire(context.Background(), 60000)
+	release()
+
+	start := time.Now()
+	release, err := limiter.Acquire(context.Background(), 100)
+	release()
+	elapsed := time.Since(start)
+	fmt.Println(err, elapsed >= 90 * time.Millisecond, elapsed < time.Second)
+
+	// Output:
+	// <nil> true true
+}
+
+func ExampleRateLimiter_concurrent() {
+	limiter := &autog.RateLimiter{ MaxConcurrent: 1 }
+	release, _ := limiter.Acquire(context.Background(), 0)
+
+	cxt, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	_, err := limiter.Acquire(cxt, 0)
+	cancel()
+	fmt.Println(err)
+
+	release()
+	release, err = limiter.Acquire(context.Background(), 0)
+	fmt.Println(err)
+	release()
+
+	// Output:
+	// context deadline exceeded
+	// <nil>
+}
+
+func ExampleRateLimitedLLM() {
+	mock := &llm.Mock{ Reply: func (msgs []autog.ChatMessage) llm.MockResponse {
+		return llm.MockResponse{ Content: "one two three four" }
+	} }
+	mock.InitLLM()
+
+	limiter := &autog.RateLimiter{ TokensPerMinute: 1000 }
+	limited := &autog.RateLimitedLLM{ LLM: mock, Limiter: limiter }
+	msgs := []autog.ChatMessage{ { Role: autog.ROLE_USER, Content: "count to four" } }
+	estimate := mock.CalcTokens(context.Background(), "count to four")
+
+	// The completion tokens of the usage are settled after the call
+	status, msg := limited.SendMessages(context.Background(), msgs)
+	_, tokens := limiter.Available()
+	fmt.Println(status == autog.LLM_STATUS_OK, 1000 - tokens == msg.Usage.PromptTokens + msg.Usage.CompletionTokens, estimate == msg.Usage.PromptTokens)
+
+	// The embedding usage recorded by the model is settled the same way
+	elimiter := &autog.RateLimiter{ TokensPerMinute: 1000 }
+	embedder := &autog.RateLimitedEmbeddingModel{ EmbeddingModel: mock, Limiter: elimiter }
+	meter := &autog.UsageMeter{}
+	_, err := embedder.Embeddings(autog.WithUsageRecorder(context.Background(), meter), 0, []string{ "hello world", "how are you" })
+	_, tokens = elimiter.Available()
+	fmt.Println(err, 1000 - tokens == meter.Total().PromptTokens)
+
+	// A canceled call is not sent
+	cxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	status, _ = limited.SendMessages(cxt, msgs)
+	fmt.Println(status == autog.LLM_STATUS_USER_CANCELED, len(mock.Calls()))
+
+	// The context window of the wrapped LLM is kept for ContextFit
+	mock.Info.ContextWindow = 8192
+	fmt.Println(autog.ModelInfoOf(limited, false).ContextWindow)
+
+	// Output:
+	// true true true
+	// <nil> true
+	// true 1
+	// 8192
+}
@@ -0,0 +1,303 @@
+package autog
+
+import (
+	"fmt"
+	"time"
+	"sort"
+	"strings"
+	"context"
+	"reflect"
+	"encoding/json"
+)
+
+const (
+	RESPONSE_FORMAT_TEXT        string = "text"
+	RESPONSE_FORMAT_JSON        string = "json_object"
+	RESPONSE_FORMAT_JSON_SCHEMA string = "json_schema"
+)
+
+// ResponseFormat asks the model for a JSON reply, Schema constrains it when
+// Type is RESPONSE_FORMAT_JSON_SCHEMA.
+type ResponseFormat struct {
+	Type   string
+	Name   string
+	Schema map[string]interface{}
+	// Strict asks for an exact schema match, where the provider supports it
+	Strict bool
+}
+
+type responseFormatContextKey struct{}
+
+// WithResponseFormat returns a copy of cxt whose calls ask for format
+func WithResponseFormat(cxt context.Context, format ResponseFormat) context.Context {
+	if cxt == nil {
+		cxt = context.Background()
+	}
+	return context.WithValue(cxt, responseFormatContextKey{}, &format)
+}
+
+// ResponseFormatFromContext returns the format attached by WithResponseFormat
+func ResponseFormatFromContext(cxt context.Context) *ResponseFormat {
+	if cxt == nil {
+		return nil
+	}
+	format, _ := cxt.Value(responseFormatContextKey{}).(*ResponseFormat)
+	return format
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// JsonSchema returns the JSON schema of the type of v, generated from its
+// json tags. Fields without omitempty are required, and a desc tag is used
+// as the description of a field.
+func JsonSchema(v interface{}) map[string]interface{} {
+	return typeSchema(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{ "type": "string", "format": "date-time" }
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{ "type": "string" }
+	case reflect.Bool:
+		return map[string]interface{}{ "type": "boolean" }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{ "type": "integer" }
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{ "type": "number" }
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as a base64 string
+			return map[string]interface{}{ "type": "string" }
+		}
+		return map[string]interface{}{ "type": "array", "items": typeSchema(t.Elem(), visiting) }
+	case reflect.Map:
+		return map[string]interface{}{ "type": "object", "additionalProperties": typeSchema(t.Elem(), visiting) }
+	case reflect.Struct:
+		if visiting[t] {
+			// A recursive type is not expanded again
+			return map[string]interface{}{ "type": "object" }
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		properties := map[string]interface{}{}
+		required := []string{}
+		structFields(t, visiting, properties, &required)
+		return map[string]interface{}{
+			"type": "object",
+			"properties": properties,
+			"required": required,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+func structFields(t reflect.Type, visiting map[reflect.Type]bool, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && len(name) <= 0 {
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structFields(ft, visiting, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if len(name) <= 0 {
+			name = field.Name
+		}
+		schema := typeSchema(field.Type, visiting)
+		if desc := field.Tag.Get("desc"); len(desc) > 0 {
+			schema["description"] = desc
+		}
+		properties[name] = schema
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// ValidateJson checks value, decoded from JSON, against schema, checking
+// the types and the required properties.
+func ValidateJson(schema map[string]interface{}, value interface{}) error {
+	return validateJson(schema, value, "$")
+}
+
+func validateJson(schema map[string]interface{}, value interface{}, path string) error {
+	typ, _ := schema["type"].(string)
+	switch typ {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range schemaRequired(schema) {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := properties[name].(map[string]interface{}); ok {
+				if err := validateJson(prop, obj[name], path + "." + name); err != nil {
+					return err
+				}
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				if err := validateJson(additional, obj[name], path + "." + name); err != nil {
+					return err
+				}
+			} else if schema["additionalProperties"] == false {
+				return fmt.Errorf("%s.%s is not allowed", path, name)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range arr {
+				if err := validateJson(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s must be a number", path)
+		}
+	case "integer":
+		f, ok := value.(float64)
+		if !ok || f != float64(int64(f)) {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+	}
+	return nil
+}
+
+// schemaRequired returns the required properties of schema, a []string if
+// built in Go or a []interface{} if decoded from JSON.
+func schemaRequired(schema map[string]interface{}) []string {
+	switch required := schema["required"].(type) {
+	case []string:
+		return required
+	case []interface{}:
+		names := make([]string, 0, len(required))
+		for _, name := range required {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// JsonOutput decodes the JSON reply of a model into Value, its Format asks
+// the model for the schema of T, and its DoAction feeds decoding and
+// validation errors back to the model through the reflection of the agent.
+type JsonOutput[T any] struct {
+	Name  string
+	Value T
+	// Validate checks the decoded value beyond the schema
+	Validate func (value *T) error
+	schema   map[string]interface{}
+}
+
+func NewJsonOutput[T any](name string) *JsonOutput[T] {
+	return &JsonOutput[T]{ Name: name }
+}
+
+func (o *JsonOutput[T]) Schema() map[string]interface{} {
+	if o.schema == nil {
+		var zero T
+		o.schema = JsonSchema(zero)
+	}
+	return o.schema
+}
+
+func (o *JsonOutput[T]) Format() ResponseFormat {
+	name := o.Name
+	if len(name) <= 0 {
+		name = "output"
+	}
+	return ResponseFormat{ Type: RESPONSE_FORMAT_JSON_SCHEMA, Name: name, Schema: o.Schema() }
+}
+
+// Context returns a copy of cxt asking for the format of the output
+func (o *JsonOutput[T]) Context(cxt context.Context) context.Context {
+	return WithResponseFormat(cxt, o.Format())
+}
+
+// Decode decodes and validates content, a markdown code fence around the
+// JSON is removed.
+func (o *JsonOutput[T]) Decode(content string) error {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+	}
+	var raw interface{}
+	if err := json.Unmarshal([]byte(content), &raw); err != nil {
+		return fmt.Errorf("Invalid JSON: %s", err)
+	}
+	if err := ValidateJson(o.Schema(), raw); err != nil {
+		return fmt.Errorf("Invalid JSON: %s", err)
+	}
+	var value T
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return fmt.Errorf("Invalid JSON: %s", err)
+	}
+	if o.Validate != nil {
+		if err := o.Validate(&value); err != nil {
+			return err
+		}
+	}
+	o.Value = value
+	return nil
+}
+
+func (o *JsonOutput[T]) DoAction() *DoAction {
+	return &DoAction{
+		Do : func (content string) (ok bool, reflection string) {
+			if err := o.Decode(content); err != nil {
+				return false, fmt.Sprintf("Your reply is rejected: %s\nReply again with only the JSON object.", err)
+			}
+			return true, ""
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package autog_test
+
+import (
+	"fmt"
+	"encoding/json"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExampleJsonOutput() {
+	type Weather struct {
+		City    string   `json:"city" desc:"Name of the city"`
+		Celsius float64  `json:"celsius"`
+		Tags    []string `json:"tags,omitempty"`
+	}
+
+	mock := &llm.Mock{
+		Responses: []llm.MockResponse{
+			{ Content: `{"city":"Paris"}` },
+			{ Content: "```json\n{\"city\":\"Paris\",\"celsius\":21.5}\n```" },
+		},
+	}
+	mock.InitLLM()
+
+	output := autog.NewJsonOutput[Weather]("weather")
+	schema, _ := json.Marshal(output.Schema())
+	fmt.Println(string(schema))
+
+	agent := &autog.Agent{}
+	agent.Prompt().
+	ReadQuestion(nil, &autog.Input{ ReadContent: func() string { return "How is the weather in Paris?" } }, nil).
+	AskLLM(mock, false).
+	WaitResponse(output.Context(nil)).
+	Action(output.DoAction()).
+	Reflection(nil, 3)
+
+	calls := mock.Calls()
+	fmt.Println(agent.Err(), len(calls), calls[1].Format.Name)
+	fmt.Println(calls[1].Messages[len(calls[1].Messages)-1].Content)
+	fmt.Printf("%+v\n", output.Value)
+
+	// Output:
+	// {"additionalProperties":false,"properties":{"celsius":{"type":"number"},"city":{"description":"Name of the city","type":"string"},"tags":{"items":{"type":"string"},"type":"array"}},"required":["city","celsius"],"type":"object"}
+	// <nil> 2 weather
+	// Your reply is rejected: Invalid JSON: $.celsius is required
+	// Reply again with only the JSON object.
+	// {City:Paris Celsius:21.5 Tags:[]}
+}
+
+func ExampleValidateJson() {
+	// A schema decoded from JSON lists its required properties as []interface{}
+	var schema map[string]interface{}
+	json.Unmarshal([]byte(`{"type":"object","properties":{"city":{"type":"string"},"days":{"type":"integer"}},"required":["city","days"]}`), &schema)
+
+	for _, data := range []string{ `{"city":"Paris","days":3}`, `{"city":"Paris"}`, `{"city":"Paris","days":1.5}` } {
+		var value interface{}
+		json.Unmarshal([]byte(data), &value)
+		fmt.Println(autog.ValidateJson(schema, value))
+	}
+
+	// Output:
+	// <nil>
+	// $.days is required
+	// $.days must be an integer
+}
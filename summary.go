@@ -10,6 +10,7 @@ var (
 	defaultMinSummaryTokens int = 1024
 	defaultMinSplit int = 4
 	defaultMaxDepth int = 3
+	defaultImageTokens int = 765
 )
 
 type TokenizedMessage struct {
@@ -28,6 +29,8 @@ type Summary struct {
 
 	MinSplit    int
 	MaxDepth    int
+	// ImageTokens is the estimated tokens of an image part
+	ImageTokens int
 }
 
 func OutputSummaryContent(output StreamReader, contentbuf *strings.Builder, delta string) {
@@ -48,6 +51,11 @@ func OutputSummaryError(output StreamReader, contentbuf *strings.Builder, status
 func (s *Summary) InitSummary() error {
 	if s.MinSummaryTokens <= 0 {
 		s.MinSummaryTokens = defaultMinSummaryTokens
+		// The weak model summarizes the history, so it must fit its context
+		info := ModelInfoOf(s.LLM, true)
+		if info.ContextWindow > 0 && info.ContextWindow / 4 < s.MinSummaryTokens {
+			s.MinSummaryTokens = info.ContextWindow / 4
+		}
 	}
 	if s.MinSplit <= 0 {
 		s.MinSplit = defaultMinSplit
@@ -55,6 +63,9 @@ func (s *Summary) InitSummary() error {
 	if s.MaxDepth <= 0 {
 		s.MaxDepth = defaultMaxDepth
 	}
+	if s.ImageTokens <= 0 {
+		s.ImageTokens = defaultImageTokens
+	}
 	return nil
 }
 
@@ -65,8 +76,12 @@ func (s *Summary) TokenizeMessages(messages []ChatMessage) ([]TokenizedMessage,
 	tokenized := make([]TokenizedMessage, len(messages))
 	total := 0
 	for i, msg := range messages {
+		// Images are estimated, not counted by their data
+		images := msg.Images()
+		msg.Content = msg.Text()
+		msg.Parts   = nil
 		bytes, _ := json.Marshal(msg)
-		tokens  := s.LLM.CalcTokensByWeakModel(s.Cxt, string(bytes))
+		tokens  := s.LLM.CalcTokensByWeakModel(s.Cxt, string(bytes)) + images * s.ImageTokens
 		tokenized[i] = TokenizedMessage{Tokens: tokens}
 		total += tokens
 	}
@@ -98,10 +113,14 @@ func (s *Summary) SummarizeOnce(msgs []ChatMessage) (LLMStatus, []ChatMessage) {
 		contentbuf.WriteString("# ")
 		contentbuf.WriteString(strings.ToUpper(msg.Role))
 		contentbuf.WriteString("\n")
-		contentbuf.WriteString(msg.Content)
-		if !strings.HasSuffix(msg.Content, "\n") {
+		text := msg.Text()
+		contentbuf.WriteString(text)
+		if !strings.HasSuffix(text, "\n") {
 			contentbuf.WriteString("\n")
 		}
+		for i := 0; i < msg.Images(); i++ {
+			contentbuf.WriteString("[image]\n")
+		}
 	}
 
 	content := contentbuf.String()
@@ -168,8 +187,7 @@ func (s *Summary) SummarizeSplit(force bool, msgs []ChatMessage, depth int) (LLM
 	summarybytes, _ := json.Marshal(summarymsgs)
 	summarytokens   := s.LLM.CalcTokensByWeakModel(s.Cxt, string(summarybytes))
 
-	tailbytes, _ := json.Marshal(tailmsgs)
-	tailtokens   := s.LLM.CalcTokensByWeakModel(s.Cxt, string(tailbytes))
+	_, tailtokens := s.TokenizeMessages(tailmsgs)
 
 	finalmsgs := append(summarymsgs, tailmsgs...)
 
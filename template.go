@@ -0,0 +1,308 @@
+package autog
+
+import (
+	"fmt"
+	"sort"
+	"path"
+	"context"
+	"strings"
+	"io/fs"
+	"encoding/json"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	// TemplateVarQuery is the request of the agent, always given
+	TemplateVarQuery    = "Query"
+	// TemplateVarExamples are the Examples of the template, always given
+	TemplateVarExamples = "Examples"
+	// TemplateExamples is the partial rendering the Examples
+	TemplateExamples    = "examples"
+)
+
+const defaultExamplesPartial = `{{range .Examples}}Input: {{.Input}}
+Output: {{.Output}}
+
+{{end}}`
+
+// TemplateVar returns the value of a template variable for the query
+type TemplateVar func (query string) interface{}
+
+// TemplateValue returns a TemplateVar of a fixed value, e.g. a tool list
+func TemplateValue(v interface{}) TemplateVar {
+	return func (query string) interface{} {
+		return v
+	}
+}
+
+// FewShotExample is an input and the output the model should answer
+type FewShotExample struct {
+	Input  string
+	Output string
+}
+
+// PromptTemplate is a prompt rendered by text/template, the template sees
+// .Query, .Examples and the Vars by name. Partials are reusable templates
+// called by {{template "name" .}}, the "examples" partial renders the
+// Examples unless replaced.
+type PromptTemplate struct {
+	Name string
+	// Role of the rendered prompt, ROLE_SYSTEM if empty
+	Role string
+	Text string
+	Partials map[string]string
+	Examples []FewShotExample
+	Vars  map[string]TemplateVar
+	Funcs template.FuncMap
+}
+
+// LoadPromptTemplate reads the template text from file of fsys, and the
+// partials from the files matching patterns, each named by its file name
+// without extension. fsys is an embed.FS or os.DirFS for plain files.
+func LoadPromptTemplate(fsys fs.FS, file string, patterns ...string) (*PromptTemplate, error) {
+	text, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	t := &PromptTemplate{
+		Name     : templateFileName(file),
+		Text     : string(text),
+		Partials : map[string]string{},
+	}
+	for _, pattern := range patterns {
+		files, gerr := fs.Glob(fsys, pattern)
+		if gerr != nil {
+			return nil, gerr
+		}
+		for _, f := range files {
+			if f == file {
+				continue
+			}
+			partial, rerr := fs.ReadFile(fsys, f)
+			if rerr != nil {
+				return nil, rerr
+			}
+			t.Partials[templateFileName(f)] = string(partial)
+		}
+	}
+	return t, nil
+}
+
+func templateFileName(file string) string {
+	base := path.Base(file)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+func defaultTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"join" : strings.Join,
+		"trim" : strings.TrimSpace,
+		"json" : func (v interface{}) (string, error) {
+			bytes, err := json.Marshal(v)
+			return string(bytes), err
+		},
+	}
+}
+
+// Parse parses the template and its partials, and checks every variable
+// the template uses is given.
+func (t *PromptTemplate) Parse() (*template.Template, error) {
+	name := t.Name
+	if len(name) <= 0 {
+		name = "prompt"
+	}
+	tmpl := template.New(name).Option("missingkey=error").Funcs(defaultTemplateFuncs())
+	if t.Funcs != nil {
+		tmpl = tmpl.Funcs(t.Funcs)
+	}
+	if _, ok := t.Partials[TemplateExamples]; !ok {
+		if _, err := tmpl.New(TemplateExamples).Parse(defaultExamplesPartial); err != nil {
+			return nil, err
+		}
+	}
+	// Sorted, so the first error is the same every time
+	var partials []string
+	for pname := range t.Partials {
+		partials = append(partials, pname)
+	}
+	sort.Strings(partials)
+	for _, pname := range partials {
+		if _, err := tmpl.New(pname).Parse(t.Partials[pname]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tmpl.New(name).Parse(t.Text); err != nil {
+		return nil, err
+	}
+	tmpl = tmpl.Lookup(name)
+
+	known := map[string]bool{ TemplateVarQuery: true, TemplateVarExamples: true }
+	for vname := range t.Vars {
+		known[vname] = true
+	}
+	check := &templateChecker{ tmpl: tmpl, known: known, seen: map[string]bool{}, missing: map[string]bool{} }
+	check.walk(tmpl.Tree.Root, true)
+	if len(check.missing) > 0 {
+		var missing []string
+		for vname := range check.missing {
+			missing = append(missing, vname)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("Prompt template %s misses variables: %s!", name, strings.Join(missing, ", "))
+	}
+	return tmpl, nil
+}
+
+// Data returns the variables of the template for query
+func (t *PromptTemplate) Data(query string) map[string]interface{} {
+	data := map[string]interface{}{
+		TemplateVarQuery    : query,
+		TemplateVarExamples : t.Examples,
+	}
+	for vname, v := range t.Vars {
+		if v != nil {
+			data[vname] = v(query)
+		}
+	}
+	return data
+}
+
+// PromptItem parses the template and returns a prompt item rendering it. A
+// template failing at request time gives no prompt by GetPrompt, the agent
+// records the failure as a PromptError.
+func (t *PromptTemplate) PromptItem() (*PromptItem, error) {
+	tmpl, err := t.Parse()
+	if err != nil {
+		return nil, err
+	}
+	role := t.Role
+	if len(role) <= 0 {
+		role = ROLE_SYSTEM
+	}
+	render := func (query string) (string, string, error) {
+		buf := strings.Builder{}
+		if err := tmpl.Execute(&buf, t.Data(query)); err != nil {
+			return "", "", err
+		}
+		return role, buf.String(), nil
+	}
+	return &PromptItem{
+		Name : t.Name,
+		GetPrompt : func (query string) (string, string) {
+			role, prompt, _ := render(query)
+			return role, prompt
+		},
+		render : render,
+	}, nil
+}
+
+// templateChecker finds the variables a template uses on its root data
+type templateChecker struct {
+	tmpl    *template.Template
+	known   map[string]bool
+	seen    map[string]bool
+	missing map[string]bool
+}
+
+func (c *templateChecker) field(name string) {
+	if !c.known[name] {
+		c.missing[name] = true
+	}
+}
+
+// walk checks node, root is whether the dot is the root data there
+func (c *templateChecker) walk(node parse.Node, root bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, root)
+		}
+	case *parse.ActionNode:
+		c.walk(n.Pipe, root)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			c.walk(cmd, root)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			c.walk(arg, root)
+		}
+	case *parse.ChainNode:
+		c.walk(n.Node, root)
+	case *parse.FieldNode:
+		if root {
+			c.field(n.Ident[0])
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			c.field(n.Ident[1])
+		}
+	case *parse.IfNode:
+		c.walk(n.Pipe, root)
+		c.walk(n.List, root)
+		c.walk(n.ElseList, root)
+	case *parse.RangeNode:
+		// The dot is an element of the range inside
+		c.walk(n.Pipe, root)
+		c.walk(n.List, false)
+		c.walk(n.ElseList, root)
+	case *parse.WithNode:
+		c.walk(n.Pipe, root)
+		c.walk(n.List, false)
+		c.walk(n.ElseList, root)
+	case *parse.TemplateNode:
+		c.walk(n.Pipe, root)
+		// A partial given the root data uses its variables
+		if !root || !isDotPipe(n.Pipe) || c.seen[n.Name] {
+			return
+		}
+		c.seen[n.Name] = true
+		if partial := c.tmpl.Lookup(n.Name); partial != nil && partial.Tree != nil {
+			c.walk(partial.Tree.Root, true)
+		}
+	}
+}
+
+func isDotPipe(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := pipe.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
+
+// TemplateVars returns the template variables of the agent: Request,
+// History, LongHistory and Actions.
+func (a *Agent) TemplateVars() map[string]TemplateVar {
+	return map[string]TemplateVar{
+		"Request"     : func (query string) interface{} { return a.Request },
+		"History"     : func (query string) interface{} { return a.ShortHistoryMessages },
+		"LongHistory" : func (query string) interface{} { return a.LongHistoryMessages },
+		"Actions"     : func (query string) interface{} { return a.Actions },
+	}
+}
+
+// TemplateVar returns a template variable of the contents of the topk
+// chunks of path retrieved for the query, empty if the retrieval fails.
+func (r *Rag) TemplateVar(cxt context.Context, path string, topk int) TemplateVar {
+	return func (query string) interface{} {
+		var contents []string
+		scoredss, err := r.Retrieval(cxt, path, []string{ query }, topk)
+		if err != nil {
+			return contents
+		}
+		for _, scoreds := range scoredss {
+			for _, scored := range scoreds {
+				contents = append(contents, scored.Chunk.GetContent())
+			}
+		}
+		return contents
+	}
+}
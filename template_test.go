@@ -0,0 +1,124 @@
+package autog_test
+
+import (
+	"fmt"
+	"errors"
+	"text/template"
+	"testing/fstest"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+func ExamplePromptTemplate() {
+	agent := &autog.Agent{}
+	agent.RegisterActions(&autog.Action{ Name: "search", Desc: "search the web" })
+
+	vars := agent.TemplateVars()
+	vars["Chunks"] = func (query string) interface{} {
+		return []string{ "The sky is blue.", "Grass is green." }
+	}
+	vars["Tools"] = autog.TemplateValue([]autog.Tool{ { Name: "weather", Desc: "get the weather" } })
+
+	system := &autog.PromptTemplate{
+		Name : "system",
+		Text : `{{template "rules" .}}
+{{template "examples" .}}Context:
+{{range .Chunks}}- {{.}}
+{{end}}Tools:{{range .Tools}} {{.Name}}{{end}}
+Actions:{{range .Actions}} {{.Name}}{{end}}
+Question: {{.Query}}`,
+		Partials : map[string]string{
+			"rules" : "Answer in one word.",
+		},
+		Examples : []autog.FewShotExample{
+			{ Input: "Color of snow?", Output: "White" },
+		},
+		Vars : vars,
+	}
+	item, err := system.PromptItem()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	role, prompt := item.GetPrompt("Color of the sky?")
+	fmt.Println(role)
+	fmt.Println(prompt)
+
+	// Missing variables fail at construction, also inside partials
+	broken := &autog.PromptTemplate{
+		Name : "broken",
+		Text : `{{.Query}} {{template "footer" .}}{{range .Items}}{{.Name}}{{end}}`,
+		Partials : map[string]string{ "footer" : "{{.Signature}}" },
+	}
+	_, err = broken.PromptItem()
+	fmt.Println(err)
+
+	// Templates load from an embed.FS, os.DirFS or any fs.FS
+	fsys := fstest.MapFS{
+		"prompts/qa.tmpl"     : { Data: []byte(`{{template "header" .}} {{.Query}}`) },
+		"prompts/header.tmpl" : { Data: []byte(`Q:`) },
+	}
+	loaded, err := autog.LoadPromptTemplate(fsys, "prompts/qa.tmpl", "prompts/*.tmpl")
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	loaded.Role = autog.ROLE_USER
+	item, err = loaded.PromptItem()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	role, prompt = item.GetPrompt("Why?")
+	fmt.Println(item.Name, role, prompt)
+
+	// Output:
+	// system
+	// Answer in one word.
+	// Input: Color of snow?
+	// Output: White
+	//
+	// Context:
+	// - The sky is blue.
+	// - Grass is green.
+	// Tools: weather
+	// Actions: search
+	// Question: Color of the sky?
+	// Prompt template broken misses variables: Items, Signature!
+	// qa user Q: Why?
+}
+
+func ExamplePromptTemplate_renderError() {
+	mock := &llm.Mock{}
+	mock.InitLLM()
+
+	weather := &autog.PromptTemplate{
+		Name : "weather",
+		Text : `Weather: {{weather .Query}}`,
+		Funcs : template.FuncMap{
+			"weather" : func (city string) (string, error) {
+				return "", errors.New("weather service down")
+			},
+		},
+	}
+	item, err := weather.PromptItem()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	// A template failing at request time is recorded, the LLM is not called
+	agent := &autog.Agent{}
+	input := &autog.Input{ ReadContent: func() string { return "Paris" } }
+	agent.Prompt(item).
+	ReadQuestion(nil, input, nil).
+	AskLLM(mock, false).
+	WaitResponse(nil)
+	var perr *autog.PromptError
+	fmt.Println(errors.As(agent.Err(), &perr), perr.Name, len(mock.Calls()))
+	fmt.Println(agent.Err())
+
+	// Output:
+	// true weather 0
+	// Prompt ERROR (weather): template: weather:1:11: executing "weather" at <weather .Query>: error calling weather: weather service down
+}
@@ -0,0 +1,196 @@
+package tokenizer
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"bytes"
+	"bufio"
+	"strconv"
+	"strings"
+	"io/fs"
+	"encoding/base64"
+)
+
+const (
+	// UnknownToken is emitted for a byte missing from an incomplete vocab
+	UnknownToken = -1
+)
+
+type Tokenizer interface {
+	Encode(text string) []int
+	Count(text string) int
+}
+
+// SplitFunction splits text into the pieces that are merged separately by BPE
+type SplitFunction func (text string) []string
+
+// Encoding is a byte level BPE tokenizer, Ranks maps token bytes to token ids
+// and lower ids are merged first, as in the tiktoken vocab files.
+type Encoding struct {
+	Name     string
+	Ranks    map[string]int
+	Specials map[string]int
+	Split    SplitFunction
+}
+
+func NewEncoding(name string, ranks map[string]int, specials map[string]int, split SplitFunction) *Encoding {
+	return &Encoding{
+		Name     : name,
+		Ranks    : ranks,
+		Specials : specials,
+		Split    : split,
+	}
+}
+
+// LoadTiktoken reads a vocab in the tiktoken format, one base64 token and
+// its rank per line.
+func LoadTiktoken(r io.Reader) (map[string]int, error) {
+	ranks   := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) <= 0 {
+			continue
+		}
+		fields := bytes.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid tiktoken line %d!", lineno)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tiktoken token at line %d: %w", lineno, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tiktoken rank at line %d: %w", lineno, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
+
+func LoadTiktokenFile(path string) (map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadTiktoken(file)
+}
+
+// LoadTiktokenFS reads a vocab from fsys, e.g. an embed.FS
+func LoadTiktokenFS(fsys fs.FS, name string) (map[string]int, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadTiktoken(file)
+}
+
+func (e *Encoding) Encode(text string) []int {
+	var tokens []int
+	for len(text) > 0 {
+		start, special, id := e.findSpecial(text)
+		if start < 0 {
+			tokens = e.encodeOrdinary(tokens, text)
+			break
+		}
+		tokens = e.encodeOrdinary(tokens, text[:start])
+		tokens = append(tokens, id)
+		text = text[start+len(special):]
+	}
+	return tokens
+}
+
+func (e *Encoding) Count(text string) int {
+	return len(e.Encode(text))
+}
+
+func (e *Encoding) findSpecial(text string) (start int, special string, id int) {
+	start = -1
+	for s, sid := range e.Specials {
+		i := strings.Index(text, s)
+		if i < 0 {
+			continue
+		}
+		if start < 0 || i < start || (i == start && len(s) > len(special)) {
+			start, special, id = i, s, sid
+		}
+	}
+	return start, special, id
+}
+
+func (e *Encoding) encodeOrdinary(tokens []int, text string) []int {
+	if len(text) <= 0 {
+		return tokens
+	}
+	var pieces []string
+	if e.Split != nil {
+		pieces = e.Split(text)
+	} else {
+		pieces = []string{text}
+	}
+	for _, piece := range pieces {
+		if rank, ok := e.Ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = e.bytePairEncode(tokens, piece)
+	}
+	return tokens
+}
+
+func (e *Encoding) rank(piece string, parts []int, i int) (int, bool) {
+	if i + 2 >= len(parts) {
+		return 0, false
+	}
+	rank, ok := e.Ranks[piece[parts[i]:parts[i+2]]]
+	return rank, ok
+}
+
+// bytePairEncode merges the adjacent parts of piece with the lowest rank
+// until no pair can be merged, parts holds the byte offsets of the parts.
+func (e *Encoding) bytePairEncode(tokens []int, piece string) []int {
+	parts := make([]int, len(piece) + 1)
+	for i := range parts {
+		parts[i] = i
+	}
+	for len(parts) > 2 {
+		minrank := -1
+		minidx  := -1
+		for i := 0; i + 2 < len(parts); i++ {
+			rank, ok := e.rank(piece, parts, i)
+			if ok && (minidx < 0 || rank < minrank) {
+				minrank, minidx = rank, i
+			}
+		}
+		if minidx < 0 {
+			break
+		}
+		parts = append(parts[:minidx+1], parts[minidx+2:]...)
+	}
+	for i := 0; i + 1 < len(parts); i++ {
+		part := piece[parts[i]:parts[i+1]]
+		if rank, ok := e.Ranks[part]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		// Incomplete vocab, fall back to single bytes
+		for j := 0; j < len(part); j++ {
+			if rank, ok := e.Ranks[part[j:j+1]]; ok {
+				tokens = append(tokens, rank)
+			} else {
+				tokens = append(tokens, UnknownToken)
+			}
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,49 @@
+package tokenizer_test
+
+import (
+	"fmt"
+	"strings"
+	"encoding/base64"
+	"github.com/autogorg/autog/tokenizer"
+)
+
+func ExampleSplitCl100k() {
+	fmt.Printf("%q\n", tokenizer.SplitCl100k("Hello world's 12345 tokens!!\n\n  end"))
+
+	// Output:
+	// ["Hello" " world" "'s" " " "123" "45" " tokens" "!!\n\n" " " " end"]
+}
+
+func ExampleSplitO200k() {
+	fmt.Printf("%q\n", tokenizer.SplitO200k("HelloWorld's JSONParser 你好"))
+
+	// Output:
+	// ["Hello" "World's" " JSONParser" " 你好"]
+}
+
+func ExampleEncoding() {
+	// A tiny vocab in the tiktoken format: all single bytes, then the merges
+	vocab := strings.Builder{}
+	rank  := 0
+	for b := 0; b < 256; b++ {
+		vocab.WriteString(fmt.Sprintf("%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), rank))
+		rank++
+	}
+	for _, merge := range []string{ "ll", "he", "hell", "hello", " w", " wo" } {
+		vocab.WriteString(fmt.Sprintf("%s %d\n", base64.StdEncoding.EncodeToString([]byte(merge)), rank))
+		rank++
+	}
+
+	ranks, err := tokenizer.LoadTiktoken(strings.NewReader(vocab.String()))
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	enc := tokenizer.NewNamedEncoding(tokenizer.CL100K_BASE, ranks)
+	fmt.Println(enc.Encode("hello world<|endoftext|>"))
+	fmt.Println(enc.Count("hello world"))
+
+	// Output:
+	// [259 261 114 108 100 100257]
+	// 5
+}
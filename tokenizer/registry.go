@@ -0,0 +1,174 @@
+package tokenizer
+
+import (
+	"os"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+	"strings"
+	"path/filepath"
+)
+
+const (
+	CL100K_BASE = "cl100k_base"
+	O200K_BASE  = "o200k_base"
+
+	// VocabDirEnv names the directory holding <encoding>.tiktoken vocab files
+	VocabDirEnv = "AUTOG_TIKTOKEN_DIR"
+)
+
+var (
+	// VocabDir overrides VocabDirEnv when not empty
+	VocabDir string
+
+	splits = map[string]SplitFunction{
+		CL100K_BASE : SplitCl100k,
+		O200K_BASE  : SplitO200k,
+	}
+
+	specials = map[string]map[string]int{
+		CL100K_BASE : {
+			"<|endoftext|>"   : 100257,
+			"<|fim_prefix|>"  : 100258,
+			"<|fim_middle|>"  : 100259,
+			"<|fim_suffix|>"  : 100260,
+			"<|endofprompt|>" : 100276,
+		},
+		O200K_BASE : {
+			"<|endoftext|>"   : 199999,
+			"<|endofprompt|>" : 200018,
+		},
+	}
+
+	// modelPrefixes maps model name prefixes to encodings, longer prefixes win
+	modelPrefixes = map[string]string{
+		"gpt-5"                  : O200K_BASE,
+		"gpt-4.1"                : O200K_BASE,
+		"gpt-4.5"                : O200K_BASE,
+		"gpt-4o"                 : O200K_BASE,
+		"o1"                     : O200K_BASE,
+		"o3"                     : O200K_BASE,
+		"o4"                     : O200K_BASE,
+		"gpt-4"                  : CL100K_BASE,
+		"gpt-3.5"                : CL100K_BASE,
+		"text-embedding-3"       : CL100K_BASE,
+		"text-embedding-ada-002" : CL100K_BASE,
+	}
+
+	mutex     sync.Mutex
+	encodings = map[string]*Encoding{}
+	failed    = map[string]error{}
+)
+
+// Register makes enc available by its name, e.g. an encoding built from a
+// vocab embedded in the program.
+func Register(enc *Encoding) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	encodings[enc.Name] = enc
+	delete(failed, enc.Name)
+}
+
+// RegisterModel selects the encoding used by models starting with prefix
+func RegisterModel(prefix string, encoding string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	modelPrefixes[prefix] = encoding
+}
+
+// NewNamedEncoding builds a known encoding, e.g. cl100k_base, from its vocab
+func NewNamedEncoding(name string, ranks map[string]int) *Encoding {
+	return NewEncoding(name, ranks, specials[name], splits[name])
+}
+
+func vocabDir() string {
+	if len(VocabDir) > 0 {
+		return VocabDir
+	}
+	return os.Getenv(VocabDirEnv)
+}
+
+// Get returns a registered encoding, or loads <VocabDir>/<name>.tiktoken
+func Get(name string) (*Encoding, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if enc, ok := encodings[name]; ok {
+		return enc, nil
+	}
+	if err, ok := failed[name]; ok {
+		return nil, err
+	}
+	dir := vocabDir()
+	if len(dir) <= 0 {
+		return nil, os.ErrNotExist
+	}
+	ranks, err := LoadTiktokenFile(filepath.Join(dir, name + ".tiktoken"))
+	if err != nil {
+		failed[name] = err
+		return nil, err
+	}
+	enc := NewNamedEncoding(name, ranks)
+	encodings[name] = enc
+	return enc, nil
+}
+
+// EncodingNameForModel returns the encoding used by model, or "" if unknown
+func EncodingNameForModel(model string) string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	name   := ""
+	prefix := ""
+	for p, n := range modelPrefixes {
+		if strings.HasPrefix(model, p) && len(p) > len(prefix) {
+			name, prefix = n, p
+		}
+	}
+	return name
+}
+
+// ForModel returns the BPE encoding of model when its vocab is available,
+// otherwise an Estimator, so it never returns nil.
+func ForModel(model string) Tokenizer {
+	name := EncodingNameForModel(model)
+	if len(name) > 0 {
+		if enc, err := Get(name); err == nil {
+			return enc
+		}
+	}
+	return Estimator{}
+}
+
+// Estimator estimates token counts without a vocab, it splits text like
+// cl100k and counts one token per CJK character, which BPE vocabs rarely merge.
+type Estimator struct{}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func (e Estimator) Count(text string) int {
+	count := 0
+	for _, piece := range SplitCl100k(text) {
+		bytes := 0
+		for _, r := range piece {
+			if isCJK(r) {
+				count++
+				continue
+			}
+			bytes += utf8.RuneLen(r)
+		}
+		if bytes > 0 {
+			count += 1 + (bytes - 1) / 6
+		}
+	}
+	return count
+}
+
+// Encode returns UnknownToken for each estimated token
+func (e Estimator) Encode(text string) []int {
+	tokens := make([]int, e.Count(text))
+	for i := range tokens {
+		tokens[i] = UnknownToken
+	}
+	return tokens
+}
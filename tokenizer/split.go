@@ -0,0 +1,233 @@
+package tokenizer
+
+import (
+	"unicode"
+)
+
+// The pre-tokenizer patterns of cl100k and o200k use look-ahead and possessive
+// quantifiers, which regexp does not support, so they are matched by hand.
+//
+// cl100k_base:
+//   '(?i:[sdmt]|ll|ve|re)|[^\r\n\p{L}\p{N}]?+\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]++[\r\n]*|\s*[\r\n]|\s+(?!\S)|\s+
+//
+// o200k_base:
+//   [^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//   [^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//   \p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+
+type matchFunction func (runes []rune, i int) int
+
+func SplitCl100k(text string) []string {
+	return splitBy(text, []matchFunction{
+		matchContraction,
+		matchPrefixedLetters,
+		matchNumbers,
+		func (runes []rune, i int) int {
+			return matchPunctuation(runes, i, false)
+		},
+		matchNewlines,
+		matchTrailingSpaces,
+		matchSpaces,
+	})
+}
+
+func SplitO200k(text string) []string {
+	return splitBy(text, []matchFunction{
+		func (runes []rune, i int) int {
+			return matchCasedWord(runes, i, false)
+		},
+		func (runes []rune, i int) int {
+			return matchCasedWord(runes, i, true)
+		},
+		matchNumbers,
+		func (runes []rune, i int) int {
+			return matchPunctuation(runes, i, true)
+		},
+		matchNewlines,
+		matchTrailingSpaces,
+		matchSpaces,
+	})
+}
+
+func splitBy(text string, alts []matchFunction) []string {
+	var pieces []string
+	runes := []rune(text)
+	i := 0
+	for i < len(runes) {
+		n := 0
+		for _, alt := range alts {
+			n = alt(runes, i)
+			if n > 0 {
+				break
+			}
+		}
+		if n <= 0 {
+			n = 1
+		}
+		pieces = append(pieces, string(runes[i:i+n]))
+		i += n
+	}
+	return pieces
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// isOther matches [^\r\n\p{L}\p{N}]
+func isOther(r rune) bool {
+	return !isNewline(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isPunct matches [^\s\p{L}\p{N}]
+func isPunct(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isUpperish matches [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]
+func isUpperish(r rune) bool {
+	return unicode.IsUpper(r) || unicode.IsTitle(r) || unicode.In(r, unicode.Lm, unicode.Lo) || unicode.IsMark(r)
+}
+
+// isLowerish matches [\p{Ll}\p{Lm}\p{Lo}\p{M}]
+func isLowerish(r rune) bool {
+	return unicode.IsLower(r) || unicode.In(r, unicode.Lm, unicode.Lo) || unicode.IsMark(r)
+}
+
+func runOf(runes []rune, i int, match func (r rune) bool) int {
+	j := i
+	for j < len(runes) && match(runes[j]) {
+		j++
+	}
+	return j - i
+}
+
+// matchPrefixedLetters matches [^\r\n\p{L}\p{N}]?+\p{L}+
+func matchPrefixedLetters(runes []rune, i int) int {
+	j := i
+	if j < len(runes) && isOther(runes[j]) {
+		j++
+	}
+	n := runOf(runes, j, unicode.IsLetter)
+	if n <= 0 {
+		return 0
+	}
+	return j + n - i
+}
+
+// matchNumbers matches \p{N}{1,3}
+func matchNumbers(runes []rune, i int) int {
+	n := runOf(runes, i, unicode.IsNumber)
+	if n > 3 {
+		n = 3
+	}
+	return n
+}
+
+// matchPunctuation matches ' ?[^\s\p{L}\p{N}]+[\r\n]*', slash adds '/' to the tail
+func matchPunctuation(runes []rune, i int, slash bool) int {
+	j := i
+	if j < len(runes) && runes[j] == ' ' {
+		j++
+	}
+	n := runOf(runes, j, isPunct)
+	if n <= 0 {
+		return 0
+	}
+	j += n
+	j += runOf(runes, j, func (r rune) bool {
+		return isNewline(r) || (slash && r == '/')
+	})
+	return j - i
+}
+
+// matchNewlines matches \s*[\r\n]+, the whitespace up to the last newline
+func matchNewlines(runes []rune, i int) int {
+	n := runOf(runes, i, unicode.IsSpace)
+	for k := i + n - 1; k >= i; k-- {
+		if isNewline(runes[k]) {
+			return k + 1 - i
+		}
+	}
+	return 0
+}
+
+// matchTrailingSpaces matches \s+(?!\S), all but the last whitespace before a non space
+func matchTrailingSpaces(runes []rune, i int) int {
+	n := runOf(runes, i, unicode.IsSpace)
+	if i + n >= len(runes) {
+		return n
+	}
+	if n >= 2 {
+		return n - 1
+	}
+	return 0
+}
+
+// matchSpaces matches \s+
+func matchSpaces(runes []rune, i int) int {
+	return runOf(runes, i, unicode.IsSpace)
+}
+
+// matchCasedWord matches the first (upper false) or second (upper true) word
+// pattern of o200k, the optional prefix is tried with and without.
+func matchCasedWord(runes []rune, i int, upper bool) int {
+	starts := []int{}
+	if i < len(runes) && isOther(runes[i]) {
+		starts = append(starts, i + 1)
+	}
+	starts = append(starts, i)
+	for _, j := range starts {
+		var end int
+		if upper {
+			end = matchUpperLower(runes, j)
+		} else {
+			end = matchLowerTail(runes, j)
+		}
+		if end > j {
+			end += matchContraction(runes, end)
+			return end - i
+		}
+	}
+	return 0
+}
+
+// matchLowerTail matches [upperish]*[lowerish]+ at j and returns the end
+func matchLowerTail(runes []rune, j int) int {
+	m := runOf(runes, j, isUpperish)
+	for k := m; k >= 0; k-- {
+		n := runOf(runes, j + k, isLowerish)
+		if n > 0 {
+			return j + k + n
+		}
+	}
+	return j
+}
+
+// matchUpperLower matches [upperish]+[lowerish]* at j and returns the end
+func matchUpperLower(runes []rune, j int) int {
+	m := runOf(runes, j, isUpperish)
+	if m <= 0 {
+		return j
+	}
+	return j + m + runOf(runes, j + m, isLowerish)
+}
+
+// matchContraction matches '(?i:[sdmt]|ll|ve|re), the same set as (?i:'s|'t|'re|'ve|'m|'ll|'d)
+func matchContraction(runes []rune, i int) int {
+	if i + 1 >= len(runes) || runes[i] != '\'' {
+		return 0
+	}
+	c := unicode.ToLower(runes[i+1])
+	if c == 's' || c == 'd' || c == 'm' || c == 't' {
+		return 2
+	}
+	if i + 2 >= len(runes) {
+		return 0
+	}
+	pair := string([]rune{c, unicode.ToLower(runes[i+2])})
+	if pair == "ll" || pair == "ve" || pair == "re" {
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,198 @@
+package autog
+
+import (
+	"sort"
+	"reflect"
+	"sync"
+	"strings"
+	"context"
+)
+
+type UsageKind int
+
+const (
+	UsageKindChat UsageKind = iota
+	UsageKindEmbedding
+)
+
+// Usage is the token usage of one or more LLM calls
+type Usage struct {
+	Model            string    `json:"model,omitempty"`
+	Kind             UsageKind `json:"kind"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+}
+
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens     += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens      += o.TotalTokens
+}
+
+type UsageRecorder interface {
+	RecordUsage(usage Usage)
+}
+
+type usageContextKey struct{}
+
+type usageRecorders []UsageRecorder
+
+func (rs usageRecorders) RecordUsage(usage Usage) {
+	for _, r := range rs {
+		r.RecordUsage(usage)
+	}
+}
+
+// WithUsageRecorder returns a copy of cxt whose LLM calls report their usage
+// to recorder, recorders already in cxt keep receiving the usage too. A
+// pointer recorder already in cxt is not added twice.
+func WithUsageRecorder(cxt context.Context, recorder UsageRecorder) context.Context {
+	if cxt == nil {
+		cxt = context.Background()
+	}
+	if recorder == nil {
+		return cxt
+	}
+	var recorders usageRecorders
+	if rs, ok := cxt.Value(usageContextKey{}).(usageRecorders); ok {
+		for _, r := range rs {
+			if sameRecorder(r, recorder) {
+				return cxt
+			}
+		}
+		recorders = append(recorders, rs...)
+	}
+	recorders = append(recorders, recorder)
+	return context.WithValue(cxt, usageContextKey{}, recorders)
+}
+
+// sameRecorder reports if a and b are the same pointer, recorders of other
+// kinds are never deduplicated as they may not be comparable, e.g. a func.
+func sameRecorder(a, b UsageRecorder) bool {
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || t.Kind() != reflect.Ptr {
+		return false
+	}
+	return a == b
+}
+
+// RecordUsage reports usage to the recorders in cxt, called by the providers
+func RecordUsage(cxt context.Context, usage Usage) {
+	if cxt == nil {
+		return
+	}
+	if rs, ok := cxt.Value(usageContextKey{}).(usageRecorders); ok {
+		rs.RecordUsage(usage)
+	}
+}
+
+// ModelPrice is the price of a model in currency units per million tokens
+type ModelPrice struct {
+	PromptPerMillion     float64
+	CompletionPerMillion float64
+}
+
+// PriceTable maps model names to prices, a name also matches the models it
+// prefixes, e.g. "gpt-4o" matches "gpt-4o-2024-08-06", and the longest wins.
+type PriceTable map[string]ModelPrice
+
+func (p PriceTable) Lookup(model string) (ModelPrice, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+	var found ModelPrice
+	prefix := ""
+	for name, price := range p {
+		if strings.HasPrefix(model, name) && len(name) > len(prefix) {
+			found, prefix = price, name
+		}
+	}
+	return found, len(prefix) > 0
+}
+
+func (p PriceTable) Cost(usage Usage) float64 {
+	price, ok := p.Lookup(usage.Model)
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens) * price.PromptPerMillion +
+		float64(usage.CompletionTokens) * price.CompletionPerMillion) / 1000000
+}
+
+// UsageMeter aggregates the usage of the calls of an agent or a rag, it is
+// safe for concurrent use.
+type UsageMeter struct {
+	Prices PriceTable
+
+	mutex   sync.Mutex
+	calls   int
+	total   Usage
+	models  map[string]*Usage
+}
+
+func (m *UsageMeter) RecordUsage(usage Usage) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if usage.TotalTokens <= 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	if m.models == nil {
+		m.models = make(map[string]*Usage)
+	}
+	mu, ok := m.models[usage.Model]
+	if !ok {
+		mu = &Usage{ Model: usage.Model, Kind: usage.Kind }
+		m.models[usage.Model] = mu
+	}
+	mu.Add(usage)
+	m.total.Add(usage)
+	m.calls++
+}
+
+// Calls returns the number of recorded calls
+func (m *UsageMeter) Calls() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.calls
+}
+
+// Total returns the usage of all recorded calls
+func (m *UsageMeter) Total() Usage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.total
+}
+
+// ByModel returns the usage of the recorded calls per model
+func (m *UsageMeter) ByModel() []Usage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var usages []Usage
+	for _, mu := range m.models {
+		usages = append(usages, *mu)
+	}
+	sort.Slice(usages, func (i, j int) bool {
+		return usages[i].Model < usages[j].Model
+	})
+	return usages
+}
+
+// Cost returns the cost of the recorded calls by Prices
+func (m *UsageMeter) Cost() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cost := 0.0
+	for _, mu := range m.models {
+		cost += m.Prices.Cost(*mu)
+	}
+	return cost
+}
+
+func (m *UsageMeter) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.calls  = 0
+	m.total  = Usage{}
+	m.models = nil
+}
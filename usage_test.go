@@ -0,0 +1,33 @@
+package autog_test
+
+import (
+	"fmt"
+	"context"
+	"github.com/autogorg/autog"
+	"github.com/autogorg/autog/llm"
+)
+
+type usageFunc func (usage autog.Usage)
+
+func (f usageFunc) RecordUsage(usage autog.Usage) {
+	f(usage)
+}
+
+func ExampleWithUsageRecorder() {
+	mock := &llm.Mock{}
+	mock.InitLLM()
+
+	// A meter given twice records once, a func recorder is not compared
+	meter := &autog.UsageMeter{}
+	calls := 0
+	count := usageFunc(func (usage autog.Usage) { calls++ })
+	cxt := autog.WithUsageRecorder(context.Background(), meter)
+	cxt  = autog.WithUsageRecorder(cxt, count)
+	cxt  = autog.WithUsageRecorder(cxt, count)
+	cxt  = autog.WithUsageRecorder(cxt, meter)
+	mock.SendMessages(cxt, []autog.ChatMessage{ { Role: autog.ROLE_USER, Content: "Hi!" } })
+	fmt.Println(meter.Calls(), calls)
+
+	// Output:
+	// 1 2
+}